@@ -131,8 +131,7 @@ func main() {
 					}
 					
 					out, err := target.buildGo(
-						filepath.Join(srcDir, fmt.Sprintf("%s.go", binName)),
-						filepath.Join(binDir, target.formatBin(binName)))
+						srcDir, filepath.Join(binDir, target.formatBin(binName)))
 					if err != nil { log.Fatal(out) }
 				}
 			}
@@ -146,6 +145,7 @@ func main() {
 				hostSystem.arch.str(), hostSystem.osys.str())
 		}
 		var configDir string
+		var dataDir string
 		var binDir string
 		var err error
 
@@ -155,8 +155,11 @@ func main() {
             platformDirs, err := linux.GetPlatformDirs()
 			if err != nil { log.Fatal(err) }
 
-			configDir = platformDirs.ConfigDir
-			configDir = filepath.Join(configDir, "knot")
+			knotDirs, err := knot.GetKnotDirs(&platformDirs)
+			if err != nil { log.Fatal(err) }
+
+			configDir = knotDirs.ConfigDir
+			dataDir = knotDirs.DataDir
 
             binDir = platformDirs.BinDir
 		default:
@@ -168,7 +171,7 @@ func main() {
 		if ! *quiet {
 			fmt.Printf("installing main in %s\n", knotInstall)
 		}
-		_, err = knot.CopyFile(
+		_, err = knot.CopyFile(knot.OSFileSystem{},
 			filepath.Join(hostBinDir, hostSystem.formatBin("main")),
 			knotInstall)
 		if err != nil { log.Fatal(err) }
@@ -176,7 +179,7 @@ func main() {
 		if ! *quiet {
 			fmt.Printf("creating %s\n", configDir)
 		}
-		err = knot.EnsureDirExists(configDir)
+		err = knot.EnsureDirExists(knot.OSFileSystem{}, configDir)
 		if err != nil { log.Fatal(err) }
 		
 		templateInstall := filepath.Join(configDir, "templates")
@@ -187,17 +190,23 @@ func main() {
 			if ! *quiet {
 				fmt.Printf("copying templates to %s\n", templateInstall)
 			}
-			err = knot.CopyDir(
+			err = knot.CopyDir(knot.OSFileSystem{},
 				filepath.Join(wd, "templates"),
 				templateInstall)
 			if err != nil { log.Fatal(err) }
 		}
 		
-		exportInstall := filepath.Join(configDir, "export.py")
+		if ! *quiet {
+			fmt.Printf("creating %s\n", dataDir)
+		}
+		err = knot.EnsureDirExists(knot.OSFileSystem{}, dataDir)
+		if err != nil { log.Fatal(err) }
+
+		exportInstall := filepath.Join(dataDir, "export.py")
 		if ! *quiet {
 			fmt.Printf("copying export script to %s\n", exportInstall)
 		}
-		_, err = knot.CopyFile(
+		_, err = knot.CopyFile(knot.OSFileSystem{},
 			filepath.Join(utilsDir, "export.py"),
 			exportInstall)
 		if err != nil { log.Fatal(err) }
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"knot/utils"
+)
+
+// Commands are the subcommands knot accepts after its flags
+var Commands = []string{
+	"run", "config", "shell-init", "sessions", "completion", "path", "cd",
+	"snapshot", "history", "restore", "versions", "archive", "import", "verify",
+	"backup", "mark", "stats", "batches", "export"}
+
+// the values that each flag expects, for the purpose of completion.
+// Flags that aren't listed either take no value or a free form one
+var flagCompletions = map[string]string{
+	"o":     "projects",
+	"d":     "projects",
+	"t":     "templates",
+	"sb":    "batches",
+	"sp":    "batches",
+	"se":    "batches",
+	"ob":    "batches",
+	"ib":    "batches",
+	"i":     "dirs",
+	"cd":    "dirs",
+	"wd":    "dirs",
+	"site":  "dirs",
+	"md":    "dirs",
+	"vault": "dirs"}
+
+func flagsCompleting(kind string) []string {
+	result := make([]string, 0)
+	for name, flagKind := range flagCompletions {
+		if flagKind == kind {
+			result = append(result, "-"+name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// CompletionCandidates returns the words that can complete an argument
+// of the given kind
+func CompletionCandidates(kind string, si *knot.SystemInfo, projects *knot.Projects, pi *knot.ProjectInfo) ([]string, error) {
+	result := make([]string, 0)
+
+	switch kind {
+	case "commands":
+		result = append(result, Commands...)
+	case "flags":
+		flag.VisitAll(func(f *flag.Flag) {
+			result = append(result, "-"+f.Name)
+		})
+	case "projects":
+		for name := range *projects {
+			result = append(result, name)
+		}
+	case "templates":
+		dir, err := si.FS.ReadDir(si.TemplateDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range dir {
+			if item.IsDir() {
+				result = append(result, item.Name())
+			}
+		}
+	case "batches":
+		if pi.ContentDir == "" {
+			return result, nil
+		}
+		batchNumbers, err := knot.GetBatchNumbers(si.FS, pi)
+		if err != nil {
+			return nil, err
+		}
+		for _, batchNumber := range batchNumbers {
+			result = append(result, knot.BatchLabel(pi, batchNumber))
+		}
+		root := pi.Root()
+		groups, err := knot.GetBatchGroups(si.FS, &root)
+		if err != nil {
+			return nil, err
+		}
+		groups.Walk(func(group *knot.ProjectInfo, batchNumber int) error {
+			if group.Group != "" {
+				result = append(result, knot.BatchPath(group, batchNumber))
+			}
+			return nil
+		})
+		return result, nil
+	case "custom":
+		for name := range si.Commands {
+			result = append(result, name)
+		}
+	default:
+		return nil, knot.UsageError("unknown completion <%s>", kind)
+	}
+
+	sort.Strings(result)
+	return result, nil
+}
+
+// runComplete prints the candidates of knot complete kind, one per line
+func runComplete(platform knot.Platform, args []string) error {
+	if len(args) < 1 {
+		return knot.UsageError("knot complete kind")
+	}
+	si, err := knot.GetCompletionSystemInfo(platform, knot.OSFileSystem{})
+	if err != nil {
+		return err
+	}
+	projects, err := knot.GetProjects(si.FS, si.ProjectsFile)
+	if err != nil {
+		return err
+	}
+	if args[0] == "custom" {
+		si.ConfigInfo = knot.LoadLayeredConfigInfo(si.ConfigFile)
+	}
+	pi, _ := knot.GetProjectInfo(&si, &projects)
+
+	candidates, err := CompletionCandidates(args[0], &si, &projects, &pi)
+	if err != nil {
+		return err
+	}
+	for _, candidate := range candidates {
+		fmt.Println(candidate)
+	}
+	return nil
+}
+
+// GetPath returns the directory of the project or batch given by args
+func GetPath(fsys knot.FileSystem, args []string, projects *knot.Projects, pi *knot.ProjectInfo, errProjectInfo error) (string, error) {
+	if len(args) > 2 {
+		return "", knot.UsageError("knot path [project] [batch]")
+	}
+
+	if len(args) > 0 {
+		if _, _, err := knot.ParseBatchPath(pi, args[0]); err != nil {
+			info, ok := (*projects)[args[0]]
+			if !ok {
+				return "", &knot.Error{Kind: knot.ErrProjectNotFound, Project: args[0]}
+			}
+			pi, errProjectInfo = &info, nil
+			args = args[1:]
+		}
+	}
+
+	if errProjectInfo != nil {
+		return "", errProjectInfo
+	}
+	if len(args) == 0 {
+		return pi.ProjectDir, nil
+	}
+
+	group, batchNumber, err := knot.ParseBatchPath(pi, args[0])
+	if err != nil {
+		return "", err
+	}
+	batchDir := knot.GetBatchDir(&group, batchNumber)
+	if _, err = fsys.Stat(batchDir); err != nil {
+		return "", &knot.Error{Kind: knot.ErrBatchNotFound, Path: batchDir}
+	}
+	return batchDir, nil
+}
+
+// ShellCompletion returns the completion script for a shell, along
+// with a knot function that implements `knot cd`
+func ShellCompletion(shell string) (string, error) {
+	switch shell {
+	case "bash":
+		return bashCompletion(), nil
+	case "zsh":
+		return "autoload -U +X bashcompinit && bashcompinit\n" + bashCompletion(), nil
+	case "fish":
+		return fishCompletion(), nil
+	default:
+		return "", knot.UsageError("unsupported shell <%s>", shell)
+	}
+}
+
+func bashCompletion() string {
+	var script strings.Builder
+
+	script.WriteString(`knot() {
+    if [ "$1" = "cd" ]; then
+        shift
+        local dir
+        dir="$(command knot path "$@")" && cd "$dir" && command knot -wd "$dir"
+    else
+        command knot "$@"
+    fi
+}
+
+_knot() {
+    local cur prev
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev="${COMP_WORDS[COMP_CWORD-1]}"
+
+    case "$prev" in
+`)
+	for _, kind := range []string{"projects", "templates", "batches"} {
+		fmt.Fprintf(&script,
+			"        %s)\n            COMPREPLY=($(compgen -W \"$(command knot complete %s 2>/dev/null)\" -- \"$cur\"))\n            return;;\n",
+			strings.Join(flagsCompleting(kind), "|"), kind)
+	}
+	fmt.Fprintf(&script,
+		"        %s)\n            COMPREPLY=($(compgen -d -- \"$cur\"))\n            return;;\n",
+		strings.Join(flagsCompleting("dirs"), "|"))
+
+	script.WriteString(`        run)
+            COMPREPLY=($(compgen -W "$(command knot complete custom 2>/dev/null)" -- "$cur"))
+            return;;
+        cd|path)
+            COMPREPLY=($(compgen -W "$(command knot complete projects 2>/dev/null) $(command knot complete batches 2>/dev/null)" -- "$cur"))
+            return;;
+        shell-init|completion)
+            COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur"))
+            return;;
+    esac
+
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W "$(command knot complete flags 2>/dev/null)" -- "$cur"))
+    else
+        COMPREPLY=($(compgen -W "$(command knot complete commands 2>/dev/null)" -- "$cur"))
+    fi
+}
+
+complete -F _knot knot
+`)
+	return script.String()
+}
+
+func fishCompletion() string {
+	var script strings.Builder
+
+	script.WriteString(`function knot
+    if test "$argv[1]" = cd
+        set -l dir (command knot path $argv[2..-1]); and cd $dir; and command knot -wd $dir
+    else
+        command knot $argv
+    end
+end
+
+complete -c knot -f
+complete -c knot -n __fish_use_subcommand -a '(command knot complete commands 2>/dev/null)'
+complete -c knot -n '__fish_seen_subcommand_from run' -a '(command knot complete custom 2>/dev/null)'
+complete -c knot -n '__fish_seen_subcommand_from cd path' -a '(command knot complete projects 2>/dev/null; command knot complete batches 2>/dev/null)'
+complete -c knot -n '__fish_seen_subcommand_from shell-init completion' -a 'bash zsh fish'
+`)
+
+	flag.VisitAll(func(f *flag.Flag) {
+		switch flagCompletions[f.Name] {
+		case "projects", "templates", "batches":
+			fmt.Fprintf(&script,
+				"complete -c knot -o %s -x -a '(command knot complete %s 2>/dev/null)' -d %s\n",
+				f.Name, flagCompletions[f.Name], fishQuote(firstSentence(f.Usage)))
+		case "dirs":
+			fmt.Fprintf(&script,
+				"complete -c knot -o %s -x -a '(__fish_complete_directories)' -d %s\n",
+				f.Name, fishQuote(firstSentence(f.Usage)))
+		default:
+			fmt.Fprintf(&script, "complete -c knot -o %s -d %s\n",
+				f.Name, fishQuote(firstSentence(f.Usage)))
+		}
+	})
+
+	return script.String()
+}
+
+func firstSentence(usage string) string {
+	if i := strings.IndexAny(usage, ".;"); i >= 0 {
+		usage = usage[:i]
+	}
+	return usage
+}
+
+func fishQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `'`, `\'`)
+	return fmt.Sprintf("'%s'", s)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+)
+
+type Flags struct {
+	SilentMode           bool
+	ContentDirName       string
+	ContentName          string
+	InitDirName          string
+	NextBatch            bool
+	SpecifiedBatch       string
+	NextPage             bool
+	SpecifiedPage        string
+	ExportLatestBatch    bool
+	ExportSpecifiedBatch string
+	ExportDirName        string
+	TemplateName         string
+	Git                  bool
+	BatchNaming          string
+	PackExports          bool
+	PruneBackup          bool
+	ImportBatch          string
+	FitToTemplate        bool
+	JSON                 bool
+	Weeks                int
+	DeregisterProject    string
+	OpenProject          string
+	OpenBatch            string
+	RecentPages          int
+	ListProjects         bool
+	PrintWD              bool
+	SetWD                string
+	SiteDirName          string
+	MarkdownDirName      string
+	VaultRoot            string
+	Tags                 string
+	DryRun               bool
+	Verbose              bool
+	JSONErrors           bool
+	Args                 []string
+}
+
+func GetFlags() Flags {
+	silentModePtr := flag.Bool("s", false, "silent mode; disable automatic opening of files")
+
+	contentDirNamePtr := flag.String("cd", "", "name the directory of the content files. If none is specified, they will be dumped at the top level of the project directory")
+
+	contentNamePtr := flag.String("c", "", "name of the content files. If none is specified, the name of the project directory will be used")
+
+	initDirNamePtr := flag.String("i", "", "initialise new project directory with the given name. By default, it will be created in $PWD")
+
+	nextBatchPtr := flag.Bool("b", false, "create the next batch of notes")
+
+	specifiedBatchPtr := flag.String("sb", "", "create a new batch of notes with a specified batch number, or date or week if the batches are named by them")
+
+	nextPagePtr := flag.Bool("p", false, "create the next batch of notes")
+
+	specifiedPagePtr := flag.String("sp", "", "create a new page in a  batch of notes with a specified batch number")
+
+	exportLatestBatchPtr := flag.Bool("e", false, "export the latest batch to pdf")
+
+	exportSpecifiedBatchPtr := flag.String("se", "", "export a batch with specified batch number to pdf")
+
+	exportDirNamePtr := flag.String("ed", "export", "the subdirectory in each batch where all pages will be exported to pngs")
+
+	templateNamePtr := flag.String("t", "default", "the template used for initialising the new project directory")
+
+	gitPtr := flag.Bool("git", false, "with -i, make the new project a git repository that ignores exports and krita backups")
+
+	batchNamingPtr := flag.String("naming", "", "with -i, name the batches after the day they are for, as name-2026-10-18, with date, or after the week, as name-2026-week-07, with week. By default they are numbered")
+
+	packExportsPtr := flag.Bool("exports", false, "with archive, also pack the exported pngs and pdfs of each batch")
+
+	pruneBackupPtr := flag.Bool("prune", false, "with backup, delete the files that were deleted from the projects from the backup too")
+
+	importBatchPtr := flag.String("ib", "", "with import, add the imported images and pdfs to the batch with the given number instead of the latest one")
+
+	fitToTemplatePtr := flag.Bool("fit", false, "with import, scale the imported images to fit the canvas of the template's page instead of sizing the pages to them")
+
+	jsonPtr := flag.Bool("json", false, "with stats, print the statistics as JSON")
+
+	weeksPtr := flag.Int("weeks", 26, "with stats, how many weeks of activity to show")
+
+	deregisterProjectPtr := flag.String("d", "", "deregister: remove current project from projects list")
+
+	openProjectPtr := flag.String("o", "", "open the latest batch of a given project")
+
+	openBatchPtr := flag.String("ob", "", "open the batch with the given number in krita. Ignores silent mode")
+
+	recentPagesPtr := flag.Int("n", 0, "when opening a batch, only open this many of its most recently modified pages. 0 opens all of them")
+
+	listProjectsPtr := flag.Bool("l", false, "list all registered projects")
+
+	printWD := flag.Bool("pwd", false, "print the current knot working directory")
+
+	setWD := flag.String("wd", "", "set the current knot working directory")
+
+	siteDirName := flag.String("site", "", "export the whole project as a static html site to the given directory")
+
+	markdownDirName := flag.String("md", "", "export the project as markdown notes, one per batch plus an index, to the given directory")
+
+	vaultRoot := flag.String("vault", "", "make the links in exported markdown notes relative to this directory, e.g. the root of an obsidian vault")
+
+	tags := flag.String("tags", "", "comma separated tags added to the front matter of exported markdown notes")
+
+	dryRun := flag.Bool("dry-run", false, "print the changes to disk and the programs that would be launched, without doing any of it")
+
+	verbose := flag.Bool("v", false, "verbose mode; log every change to disk and every program launched to stderr")
+
+	jsonErrors := flag.Bool("json-errors", false, "report errors on stderr as a JSON object with the message, kind, exit code and path")
+
+	flag.Parse()
+
+	return Flags{
+		SilentMode:           *silentModePtr,
+		ContentDirName:       *contentDirNamePtr,
+		ContentName:          *contentNamePtr,
+		InitDirName:          *initDirNamePtr,
+		NextBatch:            *nextBatchPtr,
+		SpecifiedBatch:       *specifiedBatchPtr,
+		NextPage:             *nextPagePtr,
+		SpecifiedPage:        *specifiedPagePtr,
+		ExportLatestBatch:    *exportLatestBatchPtr,
+		ExportSpecifiedBatch: *exportSpecifiedBatchPtr,
+		ExportDirName:        *exportDirNamePtr,
+		TemplateName:         *templateNamePtr,
+		Git:                  *gitPtr,
+		BatchNaming:          *batchNamingPtr,
+		PackExports:          *packExportsPtr,
+		PruneBackup:          *pruneBackupPtr,
+		ImportBatch:          *importBatchPtr,
+		FitToTemplate:        *fitToTemplatePtr,
+		JSON:                 *jsonPtr,
+		Weeks:                *weeksPtr,
+		DeregisterProject:    *deregisterProjectPtr,
+		OpenProject:          *openProjectPtr,
+		OpenBatch:            *openBatchPtr,
+		RecentPages:          *recentPagesPtr,
+		ListProjects:         *listProjectsPtr,
+		PrintWD:              *printWD,
+		SetWD:                *setWD,
+		SiteDirName:          *siteDirName,
+		MarkdownDirName:      *markdownDirName,
+		VaultRoot:            *vaultRoot,
+		Tags:                 *tags,
+		DryRun:               *dryRun,
+		Verbose:              *verbose,
+		JSONErrors:           *jsonErrors,
+		Args:                 flag.Args()}
+}
@@ -1,9 +1,909 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
 	"knot/utils"
 )
 
 func main() {
-	knot.Main(knot.Linux{})
+	flags := GetFlags()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, knot.Linux{}, &flags); err != nil {
+		stop()
+		Fatal(err, flags.JSONErrors)
+	}
+}
+
+// Fatal reports err on stderr and exits with its exit code
+func Fatal(err error, asJSON bool) {
+	knot.ReportError(os.Stderr, err, asJSON)
+	os.Exit(knot.ExitCode(err))
+}
+
+func run(ctx context.Context, platform knot.Platform, flags *Flags) error {
+	// completion runs on every tab, so it skips the rest
+	if len(flags.Args) > 0 && flags.Args[0] == "complete" {
+		return runComplete(platform, flags.Args[1:])
+	}
+
+	var plan knot.Plan
+	opts := knot.Options{
+		DryRun:   flags.DryRun,
+		Plan:     &plan,
+		Warnings: os.Stderr}
+	if flags.Verbose {
+		opts.Log = os.Stderr
+	}
+
+	workspace, err := knot.OpenWorkspace(ctx, platform, opts)
+	if err != nil {
+		return err
+	}
+
+	project, errProject := workspace.CurrentProject()
+
+	needsProject := flags.NextBatch || flags.SpecifiedBatch != "" ||
+		flags.NextPage || flags.SpecifiedPage != "" ||
+		flags.ExportLatestBatch || flags.ExportSpecifiedBatch != "" ||
+		flags.OpenBatch != "" ||
+		flags.SiteDirName != "" || flags.MarkdownDirName != ""
+	if needsProject && flags.InitDirName == "" && errProject != nil {
+		return errProject
+	}
+
+	if flags.InitDirName != "" {
+		project, err = workspace.InitProject(ctx, flags.InitDirName, knot.ProjectOptions{
+			ContentDirName: flags.ContentDirName,
+			ContentName:    flags.ContentName,
+			ExportDirName:  flags.ExportDirName,
+			TemplateName:   flags.TemplateName,
+			Git:            flags.Git,
+			BatchNaming:    flags.BatchNaming})
+		if err != nil {
+			return err
+		}
+		errProject = nil
+
+		info := project.Info()
+		first := project.Batch(knot.FirstBatchNumber(&info, time.Now()))
+		if err = openUnlessSilent(ctx, flags, first.Page(0)); err != nil {
+			return err
+		}
+	}
+
+	configInfo := workspace.Config()
+	if errProject == nil {
+		projectConfig := project.Config()
+		configInfo = &projectConfig
+	}
+	for _, warning := range configInfo.ConfigWarnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+	configCommand := len(flags.Args) > 0 && flags.Args[0] == "config"
+	if len(configInfo.ConfigErrors) > 0 && !configCommand {
+		for _, err := range configInfo.ConfigErrors[1:] {
+			if flags.JSONErrors {
+				knot.ReportError(os.Stderr, err, true)
+			} else {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			}
+		}
+		return configInfo.ConfigErrors[0]
+	}
+
+	if flags.NextBatch {
+		batch, err := project.NewBatch(ctx)
+		if err != nil {
+			return err
+		}
+		if err = openUnlessSilent(ctx, flags, batch.Page(0)); err != nil {
+			return err
+		}
+	}
+
+	if flags.SpecifiedBatch != "" {
+		batch, err := newSpecifiedBatch(ctx, project, flags.SpecifiedBatch)
+		if err != nil {
+			return err
+		}
+		if err = openUnlessSilent(ctx, flags, batch.Page(0)); err != nil {
+			return err
+		}
+	}
+
+	if flags.NextPage {
+		batch, err := project.LatestBatch(ctx)
+		if err != nil {
+			return err
+		}
+		page, err := batch.NewPage(ctx)
+		if err != nil {
+			return err
+		}
+		if err = openUnlessSilent(ctx, flags, page); err != nil {
+			return err
+		}
+	}
+
+	if flags.SpecifiedPage != "" {
+		batch, err := project.BatchLabeled(flags.SpecifiedPage)
+		if err != nil {
+			return err
+		}
+		page, err := batch.NewPage(ctx)
+		if err != nil {
+			return err
+		}
+		if err = openUnlessSilent(ctx, flags, page); err != nil {
+			return err
+		}
+	}
+
+	if flags.ExportLatestBatch {
+		batch, err := project.LatestBatch(ctx)
+		if err != nil {
+			return err
+		}
+		if err = export(ctx, flags, workspace, batch); err != nil {
+			return err
+		}
+	}
+
+	if flags.ExportSpecifiedBatch != "" {
+		batch, err := project.BatchLabeled(flags.ExportSpecifiedBatch)
+		if err != nil {
+			return err
+		}
+		if err = export(ctx, flags, workspace, batch); err != nil {
+			return err
+		}
+	}
+
+	if flags.SiteDirName != "" {
+		siteDir, err := filepath.Abs(flags.SiteDirName)
+		if err != nil {
+			return err
+		}
+
+		if _, err = project.ExportSite(ctx, siteDir); err != nil {
+			return err
+		}
+
+		if !flags.SilentMode {
+			if err = workspace.Open(ctx, siteDir); err != nil {
+				return err
+			}
+		}
+	}
+
+	if flags.MarkdownDirName != "" {
+		markdownOptions := knot.MarkdownOptions{Tags: strings.Split(flags.Tags, ",")}
+
+		markdownOptions.OutputDir, err = filepath.Abs(flags.MarkdownDirName)
+		if err != nil {
+			return err
+		}
+		if flags.VaultRoot != "" {
+			markdownOptions.VaultRoot, err = filepath.Abs(flags.VaultRoot)
+			if err != nil {
+				return err
+			}
+		}
+
+		if _, err = project.ExportMarkdown(ctx, markdownOptions); err != nil {
+			return err
+		}
+	}
+
+	if flags.DeregisterProject != "" {
+		deregistered, err := workspace.Deregister(ctx, flags.DeregisterProject)
+		if err == nil {
+			fmt.Printf("deregistered project <%s>, found in <%s>\n",
+				deregistered.Name(), deregistered.Dir())
+		} else if !errors.Is(err, knot.ErrProjectNotFound) {
+			return err
+		}
+	}
+
+	if flags.OpenProject != "" {
+		opened, err := workspace.Project(flags.OpenProject)
+		if err != nil {
+			return err
+		}
+		if err = opened.Open(ctx); err != nil {
+			return err
+		}
+
+		if !flags.SilentMode {
+			batch, err := opened.LatestBatch(ctx)
+			if err != nil {
+				return err
+			}
+			if err = batch.Open(ctx, flags.RecentPages); err != nil {
+				return err
+			}
+		}
+
+		if err = workspace.SetWD(ctx, opened.Dir()); err != nil {
+			return err
+		}
+	}
+
+	if flags.OpenBatch != "" {
+		batch, err := project.BatchLabeled(flags.OpenBatch)
+		if err != nil {
+			return err
+		}
+		if err = batch.Open(ctx, flags.RecentPages); err != nil {
+			return err
+		}
+	}
+
+	if flags.ListProjects {
+		fmt.Printf("registered projects:\n")
+		for _, registered := range workspace.Projects() {
+			fmt.Printf("\t project <%s> in <%s>\n", registered.Name(), registered.Dir())
+		}
+	}
+
+	if flags.PrintWD {
+		fmt.Println(workspace.WD())
+	}
+
+	if flags.SetWD != "" {
+		if err := workspace.SetWD(ctx, flags.SetWD); err != nil {
+			return err
+		}
+	}
+
+	if len(flags.Args) > 0 {
+		if err := runCommand(ctx, platform, flags, workspace, project, errProject); err != nil {
+			return err
+		}
+	}
+
+	if flags.DryRun {
+		plan.Print(os.Stdout)
+	}
+	return nil
+}
+
+// runCommand runs the subcommand given after the flags
+func runCommand(ctx context.Context, platform knot.Platform, flags *Flags, workspace *knot.Workspace, project *knot.Project, errProject error) error {
+	si := workspace.SystemInfo()
+
+	var pi knot.ProjectInfo
+	if errProject == nil {
+		pi = project.Info()
+	}
+
+	switch flags.Args[0] {
+	case "run":
+		if len(flags.Args) < 2 {
+			return knot.UsageError("knot run command [arguments]")
+		}
+		if errProject == nil {
+			return project.RunCommand(ctx, flags.Args[1], flags.Args[2:])
+		}
+		return workspace.RunCommand(ctx, flags.Args[1], flags.Args[2:])
+	case "shell-init":
+		shell := ""
+		if len(flags.Args) > 1 {
+			shell = flags.Args[1]
+		}
+		snippet, err := knot.ShellInit(shell)
+		if err != nil {
+			return err
+		}
+		fmt.Print(snippet)
+	case "sessions":
+		sessions, err := knot.ListSessions(si, platform)
+		if err != nil {
+			return err
+		}
+		projects, err := knot.GetProjects(si.FS, si.ProjectsFile)
+		if err != nil {
+			return err
+		}
+		return knot.PrintSessions(os.Stdout, si, sessions, &projects)
+	case "completion":
+		if len(flags.Args) < 2 {
+			return knot.UsageError("knot completion bash|zsh|fish")
+		}
+		script, err := ShellCompletion(flags.Args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Print(script)
+	case "path":
+		projects, err := knot.GetProjects(si.FS, si.ProjectsFile)
+		if err != nil {
+			return err
+		}
+		path, err := GetPath(si.FS, flags.Args[1:], &projects, &pi, errProject)
+		if err != nil {
+			return err
+		}
+		fmt.Println(path)
+	case "snapshot":
+		if errProject != nil {
+			return errProject
+		}
+		message, err := project.Snapshot(ctx)
+		if err != nil {
+			return err
+		}
+		if message == "" {
+			fmt.Println("no page changed since the last snapshot")
+		} else {
+			fmt.Println(message)
+		}
+	case "history":
+		page, err := pageFromArgs(project, errProject, flags.Args[1:], 2,
+			"knot history batch page")
+		if err != nil {
+			return err
+		}
+		revisions, err := page.History(ctx)
+		if err != nil {
+			return err
+		}
+		for _, revision := range revisions {
+			fmt.Printf("%.10s  %s  %s\n", revision.Hash,
+				revision.Date.Format("2006-01-02 15:04"), revision.Message)
+		}
+	case "restore":
+		page, err := pageFromArgs(project, errProject, flags.Args[1:], 3,
+			"knot restore batch page revision")
+		if err != nil {
+			return err
+		}
+		restored, err := page.Restore(ctx, flags.Args[3])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("restored <%s> from revision <%s> as <%s>\n",
+			page.Path(), flags.Args[3], restored.Path())
+	case "versions":
+		if errProject != nil {
+			return errProject
+		}
+		return runVersions(ctx, flags, workspace, project, flags.Args[1:])
+	case "archive":
+		if len(flags.Args) > 2 {
+			return knot.UsageError("knot archive [file]")
+		}
+		if errProject != nil {
+			return errProject
+		}
+		dst := project.Name() + knot.PackExtension
+		if len(flags.Args) == 2 {
+			dst = flags.Args[1]
+		}
+		dst, err := filepath.Abs(dst)
+		if err != nil {
+			return err
+		}
+		manifest, err := project.Archive(ctx, dst, flags.PackExports)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("archived project <%s> with %d files to <%s>\n",
+			manifest.Name, len(manifest.Files), dst)
+	case "import":
+		pages, err := importsPages(flags.Args[1:])
+		if err != nil {
+			return err
+		}
+		if pages {
+			if errProject != nil {
+				return errProject
+			}
+			return runImportPages(ctx, flags, project, flags.Args[1:])
+		}
+		if len(flags.Args) < 2 || len(flags.Args) > 3 {
+			return knot.UsageError("knot import file.knotpack [dir], or knot import image|pdf ...")
+		}
+		src, err := filepath.Abs(flags.Args[1])
+		if err != nil {
+			return err
+		}
+		dir := ""
+		if len(flags.Args) == 3 {
+			dir = flags.Args[2]
+		}
+		imported, err := workspace.Import(ctx, src, dir)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("imported project <%s> into <%s>\n", imported.Name(), imported.Dir())
+	case "verify":
+		if len(flags.Args) != 2 {
+			return knot.UsageError("knot verify file")
+		}
+		src, err := filepath.Abs(flags.Args[1])
+		if err != nil {
+			return err
+		}
+		manifest, err := workspace.VerifyPack(ctx, src)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("<%s> is intact: project <%s>, %d files\n",
+			src, manifest.Name, len(manifest.Files))
+	case "export":
+		if len(flags.Args) != 1 {
+			return knot.UsageError("knot export")
+		}
+		if errProject != nil {
+			return errProject
+		}
+		output, err := project.Export(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Println(output)
+		if !flags.SilentMode {
+			return workspace.Open(ctx, output)
+		}
+	case "batches":
+		if errProject != nil {
+			return errProject
+		}
+		return runBatches(ctx, project, flags.Args[1:])
+	case "mark":
+		if errProject != nil {
+			return errProject
+		}
+		return runMark(ctx, os.Stdout, project, flags.Args[1:])
+	case "stats":
+		if flags.Weeks < 1 {
+			return knot.UsageError("invalid number of weeks <%d>", flags.Weeks)
+		}
+		stats, err := workspace.Stats(ctx, flags.Args[1:])
+		if err != nil {
+			return err
+		}
+		if flags.JSON {
+			statsBytes, err := json.MarshalIndent(stats, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s\n", statsBytes)
+			return nil
+		}
+		return knot.PrintStats(os.Stdout, &stats, time.Now(), flags.Weeks)
+	case "backup":
+		return runBackup(ctx, flags, workspace, flags.Args[1:])
+	case "cd":
+		return knot.UsageError("knot cd needs the shell function from `knot completion`, see the README")
+	case "config":
+		configInfo := *workspace.Config()
+		if errProject == nil {
+			configInfo = project.Config()
+			if len(flags.Args) > 1 {
+				batch, err := project.BatchLabeled(flags.Args[1])
+				if err != nil {
+					return err
+				}
+				configInfo = batch.Config()
+			}
+		}
+		return knot.PrintConfig(os.Stdout, &configInfo)
+	default:
+		return knot.UsageError("unknown command <%s>", flags.Args[0])
+	}
+	return nil
+}
+
+// runVersions runs the subcommands of knot versions, which work on the
+// built-in history store
+func runVersions(ctx context.Context, flags *Flags, workspace *knot.Workspace, project *knot.Project, args []string) error {
+	const usage = "knot versions [save|list batch page|diff batch page version [version]|restore batch page version|watch [minutes]|prune]"
+
+	subcommand := "save"
+	if len(args) > 0 {
+		subcommand, args = args[0], args[1:]
+	}
+
+	switch subcommand {
+	case "save":
+		pages, err := project.SaveVersions(ctx)
+		if err != nil {
+			return err
+		}
+		printVersioned(pages)
+	case "list":
+		page, err := pageFromArgs(project, nil, args, 2, usage)
+		if err != nil {
+			return err
+		}
+		versions, err := page.Versions(ctx)
+		if err != nil {
+			return err
+		}
+		for _, version := range versions {
+			fmt.Printf("%.10s  %s  %s\n", version.Hash,
+				version.Date.Format("2006-01-02 15:04"), version.Message)
+		}
+	case "diff":
+		if len(args) != 3 && len(args) != 4 {
+			return knot.UsageError(usage)
+		}
+		page, err := pageFromArgs(project, nil, args[:2], 2, usage)
+		if err != nil {
+			return err
+		}
+		to := ""
+		if len(args) == 4 {
+			to = args[3]
+		}
+		preview, err := page.CompareVersions(ctx, args[2], to)
+		if err != nil {
+			return err
+		}
+		fmt.Println(preview)
+		if !flags.SilentMode {
+			return workspace.Open(ctx, preview)
+		}
+	case "restore":
+		page, err := pageFromArgs(project, nil, args, 3, usage)
+		if err != nil {
+			return err
+		}
+		if err = page.RestoreVersion(ctx, args[2]); err != nil {
+			return err
+		}
+		fmt.Printf("restored <%s> to version <%s>\n", page.Path(), args[2])
+	case "watch":
+		minutes := project.Config().HistoryInterval
+		if len(args) > 0 {
+			var err error
+			if minutes, err = strconv.Atoi(args[0]); err != nil || minutes < 1 {
+				return knot.UsageError("invalid number of minutes <%s>", args[0])
+			}
+		}
+		fmt.Printf("saving versions of the pages of <%s> every %d minutes\n",
+			project.Name(), minutes)
+		return project.WatchVersions(ctx, time.Duration(minutes)*time.Minute,
+			func(pages []string, err error) {
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+					return
+				}
+				if len(pages) > 0 {
+					printVersioned(pages)
+				}
+			})
+	case "prune":
+		dropped, err := project.PruneVersions(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("dropped %d versions\n", dropped)
+	default:
+		return knot.UsageError(usage)
+	}
+	return nil
+}
+
+// importsPages tells whether knot import was given images and pdfs to
+// make pages of, rather than a knotpack. Mixing them is a usage error
+func importsPages(args []string) (bool, error) {
+	importable := 0
+	for _, arg := range args {
+		if knot.IsImportable(arg) {
+			importable++
+		}
+	}
+	if importable > 0 && importable < len(args) {
+		return false, knot.UsageError("knot import takes either a knotpack or images and pdfs, not both")
+	}
+	return importable > 0, nil
+}
+
+// runImportPages runs knot import with images and pdfs, which adds
+// them as pages to the latest batch or the one given with -ib
+func runImportPages(ctx context.Context, flags *Flags, project *knot.Project, files []string) error {
+	var batch *knot.Batch
+	var err error
+	if flags.ImportBatch != "" {
+		batch, err = project.BatchLabeled(flags.ImportBatch)
+	} else {
+		batch, err = project.LatestBatch(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, len(files))
+	for i, file := range files {
+		if paths[i], err = filepath.Abs(file); err != nil {
+			return err
+		}
+	}
+	pages, err := batch.Import(ctx, paths, flags.FitToTemplate)
+	if err != nil {
+		return err
+	}
+	for _, page := range pages {
+		fmt.Println(page.Path())
+	}
+	for _, page := range pages {
+		if err = openUnlessSilent(ctx, flags, page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runBatches runs knot batches, which lists the batches of the project
+// and its groups in order, and its migrate subcommand
+func runBatches(ctx context.Context, project *knot.Project, args []string) error {
+	const usage = "knot batches [migrate date|week]"
+
+	switch {
+	case len(args) == 0:
+		batches, err := project.Root().AllBatches(ctx)
+		if err != nil {
+			return err
+		}
+		for _, batch := range batches {
+			fmt.Printf("%s\t%s\n", batch.Label(), batch.Dir())
+		}
+	case len(args) == 2 && args[0] == "migrate":
+		renames, err := project.MigrateBatchNaming(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		for _, rename := range renames {
+			fmt.Printf("renamed batch <%s> to <%s>\n", rename.From, rename.To)
+		}
+	default:
+		return knot.UsageError(usage)
+	}
+	return nil
+}
+
+// runMark runs the subcommands of knot mark, which star, bookmark and
+// add notes to pages, and list and open the marked ones
+func runMark(ctx context.Context, out io.Writer, project *knot.Project, args []string) error {
+	const usage = "knot mark star|unstar batch page|note batch page [text]|bookmark batch page [label]|list [batch]|open batch [label]|pdf batch [label]"
+
+	if len(args) == 0 {
+		return knot.UsageError(usage)
+	}
+	subcommand, args := args[0], args[1:]
+
+	var change func(*knot.Annotation)
+	switch subcommand {
+	case "star", "unstar":
+		star := subcommand == "star"
+		change = func(annotation *knot.Annotation) { annotation.Star = star }
+	case "note":
+		text := ""
+		if len(args) > 2 {
+			text, args = strings.Join(args[2:], " "), args[:2]
+		}
+		change = func(annotation *knot.Annotation) { annotation.Note = text }
+	case "bookmark":
+		label := ""
+		if len(args) > 2 {
+			label, args = strings.Join(args[2:], " "), args[:2]
+		}
+		change = func(annotation *knot.Annotation) { annotation.Bookmark = label }
+	case "list", "open", "pdf":
+		// annotations are kept per group, so a grouped batch reads those
+		// of its group
+		batchNumber := -1
+		if len(args) > 0 {
+			batch, err := project.BatchLabeled(args[0])
+			if err != nil {
+				return err
+			}
+			project, batchNumber = batch.Project(), batch.Number()
+		}
+		if subcommand != "list" && batchNumber < 0 {
+			return knot.UsageError(usage)
+		}
+		annotations, err := project.Annotations(ctx)
+		if err != nil {
+			return err
+		}
+
+		if subcommand == "list" {
+			if len(args) > 1 {
+				return knot.UsageError(usage)
+			}
+			for _, page := range annotations.Pages(batchNumber) {
+				star := " "
+				if page.Star {
+					star = "*"
+				}
+				fmt.Fprintf(out, "%s batch %s page %d", star, project.Batch(page.Batch).Label(), page.Page)
+				if page.Bookmark != "" {
+					fmt.Fprintf(out, "  [%s]", page.Bookmark)
+				}
+				if page.Note != "" {
+					fmt.Fprintf(out, "  %s", page.Note)
+				}
+				fmt.Fprintln(out)
+			}
+			return nil
+		}
+
+		label := strings.Join(args[1:], " ")
+		pages := annotations.Bookmarked(batchNumber, label)
+		if len(pages) == 0 {
+			cause := errors.New("no marked page")
+			if label != "" {
+				cause = fmt.Errorf("no page bookmarked <%s>", label)
+			}
+			return &knot.Error{Kind: knot.ErrPageNotFound,
+				Project: project.Name(), Path: project.Batch(batchNumber).Dir(), Cause: cause}
+		}
+		if subcommand == "pdf" {
+			// the first of them, as a pdf reader opens at one page
+			return project.Batch(pages[0].Batch).Page(pages[0].Page).OpenExported(ctx)
+		}
+		for _, page := range pages {
+			if err = project.Batch(page.Batch).Page(page.Page).Open(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return knot.UsageError(usage)
+	}
+
+	page, err := pageFromArgs(project, nil, args, 2, usage)
+	if err != nil {
+		return err
+	}
+	_, err = page.Annotate(ctx, change)
+	return err
+}
+
+// runBackup runs knot backup, which mirrors every project into a
+// directory, along with its restore and missing subcommands
+func runBackup(ctx context.Context, flags *Flags, workspace *knot.Workspace, args []string) error {
+	const usage = "knot backup dir|restore dir [into]|missing"
+
+	if len(args) == 0 {
+		return knot.UsageError(usage)
+	}
+
+	switch args[0] {
+	case "missing":
+		if len(args) != 1 {
+			return knot.UsageError(usage)
+		}
+		printMissing(workspace.MissingProjects())
+	case "restore":
+		if len(args) < 2 || len(args) > 3 {
+			return knot.UsageError(usage)
+		}
+		src, err := filepath.Abs(args[1])
+		if err != nil {
+			return err
+		}
+		into := ""
+		if len(args) == 3 {
+			if into, err = filepath.Abs(args[2]); err != nil {
+				return err
+			}
+		}
+		report, err := workspace.RestoreBackup(ctx, src, into)
+		if err != nil {
+			return err
+		}
+		for _, project := range report.Projects {
+			switch {
+			case project.Skipped != "":
+				fmt.Printf("project <%s>: skipped, %s\n", project.Name, project.Skipped)
+			case project.Copied > 0:
+				fmt.Printf("project <%s>: restored %d files and registered\n",
+					project.Name, project.Copied)
+			default:
+				fmt.Printf("project <%s>: registered\n", project.Name)
+			}
+		}
+	default:
+		if len(args) != 1 {
+			return knot.UsageError(usage)
+		}
+		dst, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+		report, err := workspace.Backup(ctx, dst, flags.PruneBackup)
+		if err != nil {
+			return err
+		}
+		for _, project := range report.Projects {
+			fmt.Printf("project <%s>: %d copied, %d unchanged, %d pruned\n",
+				project.Name, project.Copied, project.Unchanged, project.Pruned)
+		}
+		printMissing(report.Missing)
+	}
+	return nil
+}
+
+func printMissing(missing []string) {
+	for _, name := range missing {
+		fmt.Printf("project <%s>: directory is missing\n", name)
+	}
+}
+
+func printVersioned(pages []string) {
+	if len(pages) == 0 {
+		fmt.Println("no page changed since its last version")
+		return
+	}
+	for _, page := range pages {
+		fmt.Printf("saved a version of <%s>\n", page)
+	}
+}
+
+// pageFromArgs returns the page given by a batch and a page number at
+// the start of args, which must have exactly count elements
+func pageFromArgs(project *knot.Project, errProject error, args []string, count int, usage string) (*knot.Page, error) {
+	if len(args) != count {
+		return nil, knot.UsageError(usage)
+	}
+	if errProject != nil {
+		return nil, errProject
+	}
+	batch, err := project.BatchLabeled(args[0])
+	if err != nil {
+		return nil, err
+	}
+	pageNumber, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, knot.UsageError("invalid page number <%s>", args[1])
+	}
+	return batch.Page(pageNumber), nil
+}
+
+// newSpecifiedBatch creates the batch given by label, in the group the
+// label names if it has one
+func newSpecifiedBatch(ctx context.Context, project *knot.Project, label string) (*knot.Batch, error) {
+	specified, err := project.BatchLabeled(label)
+	if err != nil {
+		return nil, err
+	}
+	return specified.Project().NewNumberedBatch(ctx, specified.Number())
+}
+
+// export exports a batch to pdf and opens it, unless in silent mode
+func export(ctx context.Context, flags *Flags, workspace *knot.Workspace, batch *knot.Batch) error {
+	output, err := batch.Export(ctx)
+	if err != nil {
+		return err
+	}
+	if flags.SilentMode {
+		return nil
+	}
+	return workspace.Open(ctx, output)
+}
+
+func openUnlessSilent(ctx context.Context, flags *Flags, page *knot.Page) error {
+	if flags.SilentMode {
+		return nil
+	}
+	return page.Open(ctx)
 }
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"knot/utils"
+)
+
+// newTestProject returns the project notes, initialised from a one
+// page template in a filesystem in memory
+func newTestProject(t *testing.T) (*knot.Project, *knot.MemFileSystem) {
+	t.Helper()
+
+	mem := knot.NewMemFileSystem()
+	si := &knot.SystemInfo{
+		ConfigDir:      "/config",
+		ProjectsFile:   "/config/projects.json",
+		TemplateDir:    "/config/templates",
+		TempConfigFile: "/state/knotconfig.json",
+		SessionsDir:    "/state/sessions",
+		SessionFile:    "/state/sessions/test.json",
+		SessionKey:     "test",
+		FS:             mem,
+		Actions:        knot.SystemActions{FS: mem}}
+	if err := mem.MkdirAll("/state", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	kra, err := knot.NewKra(image.NewNRGBA(image.Rect(0, 0, 4, 3)), "page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustWriteFile(t, mem, "/config/templates/default/batch/page.kra", kra)
+
+	workspace, err := knot.NewWorkspace(si)
+	if err != nil {
+		t.Fatal(err)
+	}
+	project, err := workspace.InitProject(context.Background(), "/projects/notes",
+		knot.ProjectOptions{ContentDirName: "content"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return project, mem
+}
+
+func mustWriteFile(t *testing.T, mem *knot.MemFileSystem, name string, data []byte) {
+	t.Helper()
+	if err := mem.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunMarkInGroup(t *testing.T) {
+	project, mem := newTestProject(t)
+	ctx := context.Background()
+	mustWriteFile(t, mem, "/projects/notes/content/unit-2/knot.zy", nil)
+	mustWriteFile(t, mem, "/projects/notes/content/unit-2/notes-3/page-0.kra", []byte("page"))
+
+	if err := runMark(ctx, nil, project, []string{"bookmark", "unit-2/3", "0", "Definitions"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := runMark(ctx, &out, project, []string{"list", "unit-2/3"}); err != nil {
+		t.Fatal(err)
+	}
+	if listed := strings.TrimSpace(out.String()); listed != "batch unit-2/3 page 0  [Definitions]" {
+		t.Errorf("expected the bookmark of the grouped batch, got %q", listed)
+	}
+
+	if err := runMark(ctx, nil, project, []string{"pdf"}); !errors.Is(err, knot.ErrUsage) {
+		t.Errorf("expected mark pdf to need a batch, got %v", err)
+	}
+	if err := runMark(ctx, nil, project, []string{"pdf", "unit-2/3"}); !errors.Is(err, knot.ErrExportFailed) {
+		t.Errorf("expected mark pdf to need an exported batch, got %v", err)
+	}
+
+	out.Reset()
+	if err := runMark(ctx, &out, project, []string{"list"}); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected the project itself to have no marked page, got %q", out.String())
+	}
+}
+
+func TestImportsPages(t *testing.T) {
+	for _, test := range []struct {
+		args  []string
+		pages bool
+		usage bool
+	}{
+		{args: []string{"notes.knotpack", "/projects"}},
+		{args: []string{"title.png", "deck.pdf"}, pages: true},
+		{args: []string{"title.png", "notes.knotpack"}, usage: true},
+		{args: []string{"notes.knotpack", "title.png"}, usage: true},
+	} {
+		pages, err := importsPages(test.args)
+		if pages != test.pages || errors.Is(err, knot.ErrUsage) != test.usage {
+			t.Errorf("%v: expected pages %v and usage error %v, got %v, %v",
+				test.args, test.pages, test.usage, pages, err)
+		}
+	}
+}
+
+func TestNewSpecifiedBatchInGroup(t *testing.T) {
+	project, mem := newTestProject(t)
+
+	batch, err := newSpecifiedBatch(context.Background(), project, "unit-3/3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch.Label() != "unit-3/3" {
+		t.Errorf("expected batch unit-3/3, got %s", batch.Label())
+	}
+	for _, file := range []string{"unit-3/knot.zy", "unit-3/notes-3/page-0.kra"} {
+		if _, err = mem.Stat(filepath.Join("/projects/notes/content", file)); err != nil {
+			t.Errorf("expected <%s> in the group: %v", file, err)
+		}
+	}
+	if _, err = mem.Stat("/projects/notes/content/notes-3"); err == nil {
+		t.Error("expected no batch 3 outside the group")
+	}
+}
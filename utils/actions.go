@@ -0,0 +1,316 @@
+package knot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Actions carries out every change to the disk and every process
+// launch, so they can be logged or only planned
+type Actions interface {
+	MkdirAll(dir string) error
+	WriteFile(file string, data []byte) error
+	// StreamFile writes to file whatever write writes to w
+	StreamFile(file string, write func(w io.Writer) error) error
+	CopyFile(src, dst string) error
+	CopyDir(src, dst string) error
+	MoveFile(src, dst string) error
+	Rename(src, dst string) error
+	RemoveAll(path string) error
+	SetModTime(file string, modTime time.Time) error
+	Start(runner CommandRunner, inputs []string) error
+	Run(runner CommandRunner, inputs []string) (string, error)
+}
+
+// SystemActions performs the actions for real, on FS
+type SystemActions struct {
+	FS FileSystem
+}
+
+func (actions SystemActions) MkdirAll(dir string) error {
+	return actions.FS.MkdirAll(dir, os.ModePerm)
+}
+
+func (actions SystemActions) WriteFile(file string, data []byte) error {
+	return actions.FS.WriteFile(file, data, 0644)
+}
+
+func (actions SystemActions) StreamFile(file string, write func(w io.Writer) error) error {
+	f, err := actions.FS.Create(file)
+	if err != nil {
+		return err
+	}
+	if err = write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (actions SystemActions) CopyFile(src, dst string) error {
+	_, err := CopyFile(actions.FS, src, dst)
+	return err
+}
+
+func (actions SystemActions) CopyDir(src, dst string) error {
+	return CopyDir(actions.FS, src, dst)
+}
+
+func (actions SystemActions) MoveFile(src, dst string) error {
+	_, err := MoveFile(actions.FS, src, dst)
+	return err
+}
+
+func (actions SystemActions) Rename(src, dst string) error {
+	return actions.FS.Rename(src, dst)
+}
+
+func (actions SystemActions) RemoveAll(path string) error {
+	return actions.FS.RemoveAll(path)
+}
+
+func (actions SystemActions) SetModTime(file string, modTime time.Time) error {
+	return actions.FS.Chtimes(file, modTime, modTime)
+}
+
+func (SystemActions) Start(runner CommandRunner, inputs []string) error {
+	return runner.Start(inputs)
+}
+
+func (SystemActions) Run(runner CommandRunner, inputs []string) (string, error) {
+	return runner.Run(inputs)
+}
+
+// NoActions does nothing at all. Run returns no output, and what
+// StreamFile writes is discarded
+type NoActions struct{}
+
+func (NoActions) MkdirAll(dir string) error                { return nil }
+func (NoActions) WriteFile(file string, data []byte) error { return nil }
+func (NoActions) CopyFile(src, dst string) error           { return nil }
+func (NoActions) CopyDir(src, dst string) error            { return nil }
+func (NoActions) MoveFile(src, dst string) error           { return nil }
+func (NoActions) Rename(src, dst string) error             { return nil }
+func (NoActions) RemoveAll(path string) error              { return nil }
+
+// StreamFile runs write, so that whatever it computes on the way is
+// still known, but discards what it writes
+func (NoActions) StreamFile(file string, write func(w io.Writer) error) error {
+	return write(io.Discard)
+}
+
+func (NoActions) SetModTime(file string, modTime time.Time) error {
+	return nil
+}
+
+func (NoActions) Start(runner CommandRunner, inputs []string) error {
+	return nil
+}
+
+func (NoActions) Run(runner CommandRunner, inputs []string) (string, error) {
+	return "", nil
+}
+
+// RecordingActions passes a description of each action to Record
+// before handing it over to Actions
+type RecordingActions struct {
+	Actions Actions
+	Record  func(action string)
+}
+
+func (actions *RecordingActions) MkdirAll(dir string) error {
+	actions.Record(fmt.Sprintf("create directory <%s>", dir))
+	return actions.Actions.MkdirAll(dir)
+}
+
+func (actions *RecordingActions) WriteFile(file string, data []byte) error {
+	actions.Record(fmt.Sprintf("write <%s> (%d bytes)", file, len(data)))
+	return actions.Actions.WriteFile(file, data)
+}
+
+func (actions *RecordingActions) StreamFile(file string, write func(w io.Writer) error) error {
+	actions.Record(fmt.Sprintf("write <%s>", file))
+	return actions.Actions.StreamFile(file, write)
+}
+
+func (actions *RecordingActions) CopyFile(src, dst string) error {
+	actions.Record(fmt.Sprintf("copy <%s> to <%s>", src, dst))
+	return actions.Actions.CopyFile(src, dst)
+}
+
+func (actions *RecordingActions) CopyDir(src, dst string) error {
+	actions.Record(fmt.Sprintf("copy directory <%s> to <%s>", src, dst))
+	return actions.Actions.CopyDir(src, dst)
+}
+
+func (actions *RecordingActions) MoveFile(src, dst string) error {
+	actions.Record(fmt.Sprintf("move <%s> to <%s>", src, dst))
+	return actions.Actions.MoveFile(src, dst)
+}
+
+func (actions *RecordingActions) Rename(src, dst string) error {
+	actions.Record(fmt.Sprintf("move <%s> to <%s>", src, dst))
+	return actions.Actions.Rename(src, dst)
+}
+
+func (actions *RecordingActions) RemoveAll(path string) error {
+	actions.Record(fmt.Sprintf("remove <%s>", path))
+	return actions.Actions.RemoveAll(path)
+}
+
+func (actions *RecordingActions) SetModTime(file string, modTime time.Time) error {
+	actions.Record(fmt.Sprintf("set the modification time of <%s>", file))
+	return actions.Actions.SetModTime(file, modTime)
+}
+
+func (actions *RecordingActions) Start(runner CommandRunner, inputs []string) error {
+	actions.Record(fmt.Sprintf("start <%s>", DescribeCommand(runner, inputs)))
+	return actions.Actions.Start(runner, inputs)
+}
+
+func (actions *RecordingActions) Run(runner CommandRunner, inputs []string) (string, error) {
+	actions.Record(fmt.Sprintf("run <%s>", DescribeCommand(runner, inputs)))
+	return actions.Actions.Run(runner, inputs)
+}
+
+// DescribeCommand returns the command line a runner would launch
+func DescribeCommand(runner CommandRunner, inputs []string) string {
+	type commandLiner interface {
+		commandLine(inputs []string) []string
+	}
+	if liner, ok := runner.(commandLiner); ok {
+		return strings.Join(liner.commandLine(inputs), " ")
+	}
+	return fmt.Sprintf("%v %s", runner, strings.Join(inputs, " "))
+}
+
+// Plan holds the actions of a dry run, in the order they were asked for
+type Plan []string
+
+func (plan *Plan) Add(action string) {
+	*plan = append(*plan, action)
+}
+
+func (plan Plan) Print(w io.Writer) {
+	if len(plan) == 0 {
+		fmt.Fprintln(w, "dry run: nothing to do")
+		return
+	}
+	fmt.Fprintln(w, "dry run, planned actions:")
+	for i, action := range plan {
+		fmt.Fprintf(w, "\t%d. %s\n", i+1, action)
+	}
+}
+
+// GetActions returns the actions on fsys for the given options
+func GetActions(opts *Options, fsys FileSystem) Actions {
+	switch {
+	case opts.DryRun:
+		record := func(string) {}
+		if opts.Plan != nil {
+			record = opts.Plan.Add
+		}
+		return &RecordingActions{Actions: NoActions{}, Record: record}
+	case opts.Log != nil:
+		return &RecordingActions{
+			Actions: SystemActions{FS: fsys},
+			Record: func(action string) {
+				fmt.Fprintf(opts.Log, "knot: %s\n", action)
+			}}
+	default:
+		return SystemActions{FS: fsys}
+	}
+}
+
+// contextActions refuses every action but removals once ctx is done
+type contextActions struct {
+	ctx     context.Context
+	actions Actions
+}
+
+func (actions *contextActions) MkdirAll(dir string) error {
+	if err := actions.ctx.Err(); err != nil {
+		return err
+	}
+	return actions.actions.MkdirAll(dir)
+}
+
+func (actions *contextActions) WriteFile(file string, data []byte) error {
+	if err := actions.ctx.Err(); err != nil {
+		return err
+	}
+	return actions.actions.WriteFile(file, data)
+}
+
+func (actions *contextActions) StreamFile(file string, write func(w io.Writer) error) error {
+	if err := actions.ctx.Err(); err != nil {
+		return err
+	}
+	return actions.actions.StreamFile(file, write)
+}
+
+func (actions *contextActions) CopyFile(src, dst string) error {
+	if err := actions.ctx.Err(); err != nil {
+		return err
+	}
+	return actions.actions.CopyFile(src, dst)
+}
+
+func (actions *contextActions) CopyDir(src, dst string) error {
+	if err := actions.ctx.Err(); err != nil {
+		return err
+	}
+	return actions.actions.CopyDir(src, dst)
+}
+
+func (actions *contextActions) MoveFile(src, dst string) error {
+	if err := actions.ctx.Err(); err != nil {
+		return err
+	}
+	return actions.actions.MoveFile(src, dst)
+}
+
+func (actions *contextActions) Rename(src, dst string) error {
+	if err := actions.ctx.Err(); err != nil {
+		return err
+	}
+	return actions.actions.Rename(src, dst)
+}
+
+func (actions *contextActions) RemoveAll(path string) error {
+	return actions.actions.RemoveAll(path)
+}
+
+func (actions *contextActions) SetModTime(file string, modTime time.Time) error {
+	if err := actions.ctx.Err(); err != nil {
+		return err
+	}
+	return actions.actions.SetModTime(file, modTime)
+}
+
+func (actions *contextActions) Start(runner CommandRunner, inputs []string) error {
+	if err := actions.ctx.Err(); err != nil {
+		return err
+	}
+	return actions.actions.Start(runner, inputs)
+}
+
+func (actions *contextActions) Run(runner CommandRunner, inputs []string) (string, error) {
+	if err := actions.ctx.Err(); err != nil {
+		return "", err
+	}
+	return actions.actions.Run(runner, inputs)
+}
+
+// EnsureDir creates dir through actions, unless it already exists in
+// fsys
+func EnsureDir(fsys FileSystem, actions Actions, dir string) error {
+	if _, err := fsys.Stat(dir); err == nil {
+		return nil
+	}
+	return actions.MkdirAll(dir)
+}
@@ -0,0 +1,167 @@
+package knot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Annotation is what knot keeps about a page besides the page itself:
+// a star, a bookmark label and a note
+type Annotation struct {
+	Star     bool   `json:",omitempty"`
+	Bookmark string `json:",omitempty"`
+	Note     string `json:",omitempty"`
+}
+
+func (annotation Annotation) empty() bool {
+	return annotation == Annotation{}
+}
+
+// Marked tells whether a page is starred or bookmarked, which is what
+// makes it show up in the outline of exported pdfs
+func (annotation Annotation) Marked() bool {
+	return annotation.Star || annotation.Bookmark != ""
+}
+
+// Annotations are the annotations of a project or of a group of its
+// batches, by batch and page number
+type Annotations map[int]map[int]Annotation
+
+// AnnotatedPage is an annotation along with the page it belongs to
+type AnnotatedPage struct {
+	Batch int
+	Page  int
+	Annotation
+}
+
+// annotationsFile is where the annotations of a project are kept. Each
+// group keeps its own, since its batches are numbered on their own
+func annotationsFile(pi *ProjectInfo) string {
+	if pi.Group != "" {
+		return filepath.Join(pi.ProjectDir, ".knot", "groups",
+			filepath.FromSlash(pi.Group), "annotations.json")
+	}
+	return filepath.Join(pi.ProjectDir, ".knot", "annotations.json")
+}
+
+// GetAnnotations reads the annotations of a project. A project without
+// any has none
+func GetAnnotations(fsys FileSystem, pi *ProjectInfo) (Annotations, error) {
+	annotations := make(Annotations)
+
+	file := annotationsFile(pi)
+	annotationsBytes, err := fsys.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return annotations, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(annotationsBytes, &annotations); err != nil {
+		return nil, fmt.Errorf("<%s>: %w", file, err)
+	}
+	return annotations, nil
+}
+
+func (annotations Annotations) Save(si *SystemInfo, pi *ProjectInfo) error {
+	annotationsBytes, err := json.MarshalIndent(annotations, "", "\t")
+	if err != nil {
+		return err
+	}
+	file := annotationsFile(pi)
+	if err = EnsureDir(si.FS, si.Actions, filepath.Dir(file)); err != nil {
+		return err
+	}
+	return si.Actions.WriteFile(file, annotationsBytes)
+}
+
+// Get returns the annotation of a page, which is empty if it has none
+func (annotations Annotations) Get(batchNumber, pageNumber int) Annotation {
+	return annotations[batchNumber][pageNumber]
+}
+
+// Set replaces the annotation of a page. An empty annotation removes it
+func (annotations Annotations) Set(batchNumber, pageNumber int, annotation Annotation) {
+	if annotation.empty() {
+		delete(annotations[batchNumber], pageNumber)
+		if len(annotations[batchNumber]) == 0 {
+			delete(annotations, batchNumber)
+		}
+		return
+	}
+	if annotations[batchNumber] == nil {
+		annotations[batchNumber] = make(map[int]Annotation)
+	}
+	annotations[batchNumber][pageNumber] = annotation
+}
+
+// Pages returns the annotated pages of a batch, in order, or of every
+// batch if batchNumber is negative
+func (annotations Annotations) Pages(batchNumber int) []AnnotatedPage {
+	var result []AnnotatedPage
+	for batch, pages := range annotations {
+		if batchNumber >= 0 && batch != batchNumber {
+			continue
+		}
+		for page, annotation := range pages {
+			result = append(result, AnnotatedPage{Batch: batch, Page: page, Annotation: annotation})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Batch != result[j].Batch {
+			return result[i].Batch < result[j].Batch
+		}
+		return result[i].Page < result[j].Page
+	})
+	return result
+}
+
+// Bookmarked returns the marked pages of a batch whose bookmark
+// matches label, or all of them if label is empty
+func (annotations Annotations) Bookmarked(batchNumber int, label string) []AnnotatedPage {
+	var result []AnnotatedPage
+	for _, page := range annotations.Pages(batchNumber) {
+		if !page.Marked() {
+			continue
+		}
+		if label == "" || strings.EqualFold(page.Bookmark, label) {
+			result = append(result, page)
+		}
+	}
+	return result
+}
+
+// Annotate changes the annotation of a page with change and saves the
+// annotations of the project. The page must exist
+func Annotate(si *SystemInfo, pi *ProjectInfo, batchNumber, pageNumber int, change func(*Annotation)) (Annotation, error) {
+	page := filepath.Join(GetBatchDir(pi, batchNumber), GetPageName(pageNumber))
+	if _, err := si.FS.Stat(page); err != nil {
+		if _, errBatch := si.FS.Stat(GetBatchDir(pi, batchNumber)); errBatch != nil {
+			return Annotation{}, &Error{Kind: ErrBatchNotFound, Path: GetBatchDir(pi, batchNumber)}
+		}
+		return Annotation{}, &Error{Kind: ErrPageNotFound, Path: page}
+	}
+
+	annotations, err := GetAnnotations(si.FS, pi)
+	if err != nil {
+		return Annotation{}, err
+	}
+	annotation := annotations.Get(batchNumber, pageNumber)
+	change(&annotation)
+	annotations.Set(batchNumber, pageNumber, annotation)
+	return annotation, annotations.Save(si, pi)
+}
+
+// outlineTitle is the title of a marked page in the outline of an
+// exported pdf: its bookmark label, or its number if it only has a star
+func (page AnnotatedPage) outlineTitle() string {
+	if page.Bookmark != "" {
+		return page.Bookmark
+	}
+	return fmt.Sprintf("page %d", page.Page)
+}
@@ -0,0 +1,84 @@
+package knot
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAnnotate(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+	if _, _, err := MakePage(testTemplatePath, si, &pi, 0, false); err != nil {
+		t.Fatal(err)
+	}
+
+	star := func(annotation *Annotation) { annotation.Star = true }
+	if _, err := Annotate(si, &pi, 0, 1, star); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Annotate(si, &pi, 0, 0, func(annotation *Annotation) {
+		annotation.Bookmark = "Definitions"
+		annotation.Note = "check the proof"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Annotate(si, &pi, 0, 5, star); !errors.Is(err, ErrPageNotFound) {
+		t.Errorf("expected ErrPageNotFound, got %v", err)
+	}
+	if _, err = Annotate(si, &pi, 3, 0, star); !errors.Is(err, ErrBatchNotFound) {
+		t.Errorf("expected ErrBatchNotFound, got %v", err)
+	}
+
+	annotations, err := GetAnnotations(mem, &pi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := annotations.Pages(-1)
+	if len(pages) != 2 || pages[0].Bookmark != "Definitions" || !pages[1].Star {
+		t.Errorf("expected a bookmark on page 0 and a star on page 1, got %+v", pages)
+	}
+	if bookmarked := annotations.Bookmarked(0, "definitions"); len(bookmarked) != 1 || bookmarked[0].Page != 0 {
+		t.Errorf("expected page 0 to be bookmarked definitions, got %+v", bookmarked)
+	}
+
+	if _, err = Annotate(si, &pi, 0, 1, func(annotation *Annotation) { annotation.Star = false }); err != nil {
+		t.Fatal(err)
+	}
+	if annotations, _ = GetAnnotations(mem, &pi); len(annotations.Pages(0)) != 1 {
+		t.Errorf("expected an unstarred page to lose its annotation, got %+v", annotations.Pages(0))
+	}
+}
+
+func TestExportBatchOutline(t *testing.T) {
+	si, _, pi := newTestProject(t)
+	if _, _, err := MakePage(testTemplatePath, si, &pi, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Annotate(si, &pi, 0, 1, func(annotation *Annotation) {
+		annotation.Bookmark = "Lemma 2"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var runs []string
+	t.Setenv("KNOT_STAMP_MARKS", "true")
+	si.Actions = &RecordingActions{Actions: NoActions{}, Record: func(action string) {
+		if strings.HasPrefix(action, "run ") {
+			runs = append(runs, action)
+		}
+	}}
+	if _, err = ExportBatch(0, &pi, si); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(runs) != 1 {
+		t.Fatalf("expected the export script to run once, got %v", runs)
+	}
+	for _, arg := range []string{"--bookmark=1:Lemma 2", "--mark=1"} {
+		if !strings.Contains(runs[0], arg) {
+			t.Errorf("expected %s in <%s>", arg, runs[0])
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package knot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// A backup mirrors every registered project into a directory named
+// after it, next to a backup.json that lists their files
+
+const backupManifestName = "backup.json"
+
+// BackupManifest describes the projects in a backup
+type BackupManifest struct {
+	Updated  time.Time
+	Projects map[string]BackupProject
+}
+
+// BackupProject is a project in a backup
+type BackupProject struct {
+	// Info is the project as it was registered, with its original paths
+	Info    ProjectInfo
+	Updated time.Time
+	Files   []PackFile
+}
+
+// BackupReport says what a backup or a restore did to each project
+type BackupReport struct {
+	Projects []BackupProjectReport
+	// Missing are the registered projects whose directory is missing
+	Missing []string
+}
+
+// BackupProjectReport says what a backup or a restore did to a project
+type BackupProjectReport struct {
+	Name      string
+	Copied    int
+	Unchanged int
+	Pruned    int
+	// Skipped tells why nothing was done to the project, if it wasn't
+	Skipped string
+}
+
+func readBackupManifest(fsys FileSystem, dir string) (BackupManifest, error) {
+	manifest := BackupManifest{Projects: make(map[string]BackupProject)}
+
+	file := filepath.Join(dir, backupManifestName)
+	manifestBytes, err := fsys.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return manifest, err
+	}
+	if err = json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return manifest, fmt.Errorf("<%s>: %w", file, err)
+	}
+	if manifest.Projects == nil {
+		manifest.Projects = make(map[string]BackupProject)
+	}
+	return manifest, nil
+}
+
+// MissingProjects returns the names of the registered projects whose
+// directory doesn't exist, in order
+func MissingProjects(fsys FileSystem, projects *Projects) []string {
+	var result []string
+	for name, pi := range *projects {
+		if _, err := fsys.Stat(pi.ProjectDir); err != nil {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// mirrorFiles copies the files that differ from those in dst and
+// returns their hashes and how many were copied
+func mirrorFiles(si *SystemInfo, src, dst string, files []string) ([]PackFile, int, error) {
+	result := make([]PackFile, len(files))
+	copied := 0
+	for i, file := range files {
+		srcFile := filepath.Join(src, filepath.FromSlash(file))
+		dstFile := filepath.Join(dst, filepath.FromSlash(file))
+
+		srcStat, err := si.FS.Stat(srcFile)
+		if err != nil {
+			return nil, 0, err
+		}
+		content, err := si.FS.ReadFile(srcFile)
+		if err != nil {
+			return nil, 0, err
+		}
+		result[i] = PackFile{Path: file, Size: int64(len(content)), SHA256: fileHash(content)}
+
+		if dstStat, err := si.FS.Stat(dstFile); err == nil && dstStat.Size() == result[i].Size {
+			existing, err := si.FS.ReadFile(dstFile)
+			if err == nil && fileHash(existing) == result[i].SHA256 {
+				if !dstStat.ModTime().Equal(srcStat.ModTime()) {
+					if err = si.Actions.SetModTime(dstFile, srcStat.ModTime()); err != nil {
+						return nil, 0, err
+					}
+				}
+				continue
+			}
+		}
+
+		if err = EnsureDir(si.FS, si.Actions, filepath.Dir(dstFile)); err != nil {
+			return nil, 0, err
+		}
+		if err = si.Actions.WriteFile(dstFile, content); err != nil {
+			return nil, 0, err
+		}
+		if err = si.Actions.SetModTime(dstFile, srcStat.ModTime()); err != nil {
+			return nil, 0, err
+		}
+		copied++
+	}
+	return result, copied, nil
+}
+
+// pruneMirror removes the files in dst, other than keep, and returns how
+// many it removed. Directories left empty are removed too
+func pruneMirror(si *SystemInfo, dst string, keep []PackFile) (int, error) {
+	kept := make(map[string]bool)
+	for _, file := range keep {
+		kept[file.Path] = true
+	}
+
+	dstInfo := &ProjectInfo{ProjectDir: dst}
+	existing, err := projectFiles(si.FS, dstInfo, dst, func(string, bool) bool { return false })
+	if err != nil {
+		return 0, err
+	}
+
+	pruned := 0
+	dirs := make(map[string]bool)
+	for _, file := range existing {
+		if kept[file] {
+			continue
+		}
+		path := filepath.Join(dst, filepath.FromSlash(file))
+		if err = si.Actions.RemoveAll(path); err != nil {
+			return pruned, err
+		}
+		pruned++
+		for dir := filepath.Dir(path); dir != dst && dir != "."; dir = filepath.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+
+	// the deepest directories go first
+	sortedDirs := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sortedDirs = append(sortedDirs, dir)
+	}
+	sort.Slice(sortedDirs, func(i, j int) bool { return len(sortedDirs[i]) > len(sortedDirs[j]) })
+	for _, dir := range sortedDirs {
+		if entries, err := si.FS.ReadDir(dir); err == nil && len(entries) == 0 {
+			if err = si.Actions.RemoveAll(dir); err != nil {
+				return pruned, err
+			}
+		}
+	}
+	return pruned, nil
+}
+
+// BackupProjects mirrors every registered project into dst. With
+// prune, files deleted from a project are deleted from its backup
+func BackupProjects(si *SystemInfo, projects *Projects, dst string, prune bool) (BackupReport, error) {
+	var report BackupReport
+
+	manifest, err := readBackupManifest(si.FS, dst)
+	if err != nil {
+		return report, err
+	}
+
+	names := make([]string, 0, len(*projects))
+	for name := range *projects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	now := time.Now().UTC()
+	report.Missing = MissingProjects(si.FS, projects)
+	missing := make(map[string]bool)
+	for _, name := range report.Missing {
+		missing[name] = true
+	}
+
+	for _, name := range names {
+		if missing[name] {
+			continue
+		}
+		pi := (*projects)[name]
+		projectReport := BackupProjectReport{Name: name}
+
+		files, err := projectFiles(si.FS, &pi, pi.ProjectDir, func(path string, isDir bool) bool {
+			return transientFile(path)
+		})
+		if err != nil {
+			return report, err
+		}
+
+		projectBackup := filepath.Join(dst, name)
+		mirrored, copied, err := mirrorFiles(si, pi.ProjectDir, projectBackup, files)
+		if err != nil {
+			return report, err
+		}
+		projectReport.Copied = copied
+		projectReport.Unchanged = len(files) - copied
+
+		if prune {
+			if projectReport.Pruned, err = pruneMirror(si, projectBackup, mirrored); err != nil {
+				return report, err
+			}
+		}
+
+		manifest.Projects[name] = BackupProject{Info: pi, Updated: now, Files: mirrored}
+		report.Projects = append(report.Projects, projectReport)
+	}
+
+	manifest.Updated = now
+	manifestBytes, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return report, err
+	}
+	if err = EnsureDir(si.FS, si.Actions, dst); err != nil {
+		return report, err
+	}
+	// the manifest is written aside and renamed over the old one, so an
+	// interrupted backup never leaves a truncated manifest
+	manifestFile := filepath.Join(dst, backupManifestName)
+	tempFile := manifestFile + ".tmp"
+	if err = si.Actions.WriteFile(tempFile, manifestBytes); err != nil {
+		return report, err
+	}
+	return report, si.Actions.Rename(tempFile, manifestFile)
+}
+
+// RestoreBackup registers the projects of the backup in src again,
+// copying back those whose directory is missing, into dir if it is set
+func RestoreBackup(si *SystemInfo, projects *Projects, src string, dir string) (BackupReport, error) {
+	var report BackupReport
+
+	manifest, err := readBackupManifest(si.FS, src)
+	if err != nil {
+		return report, err
+	}
+	if len(manifest.Projects) == 0 {
+		return report, &Error{Kind: ErrBackupInvalid, Path: src,
+			Cause: fmt.Errorf("no projects in <%s>", backupManifestName)}
+	}
+
+	names := make([]string, 0, len(manifest.Projects))
+	for name := range manifest.Projects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		backup := manifest.Projects[name]
+		projectReport := BackupProjectReport{Name: name}
+
+		pi := backup.Info
+		if dir != "" {
+			pi = relocatedProject(pi, filepath.Join(dir, name))
+		}
+
+		if registered, ok := (*projects)[name]; ok {
+			if _, err = si.FS.Stat(registered.ProjectDir); err == nil {
+				projectReport.Skipped = fmt.Sprintf("registered in <%s>", registered.ProjectDir)
+				report.Projects = append(report.Projects, projectReport)
+				continue
+			}
+		}
+
+		if _, err = si.FS.Stat(pi.ProjectDir); err != nil {
+			projectReport.Copied, err = restoreProjectFiles(
+				si, filepath.Join(src, name), pi.ProjectDir, backup.Files)
+			if err != nil {
+				return report, err
+			}
+		} else {
+			projectReport.Unchanged = len(backup.Files)
+		}
+
+		(*projects)[name] = pi
+		report.Projects = append(report.Projects, projectReport)
+	}
+	return report, projects.Save(si, si.ProjectsFile)
+}
+
+// relocatedProject returns pi moved to dir
+func relocatedProject(pi ProjectInfo, dir string) ProjectInfo {
+	contentDir, err := filepath.Rel(pi.ProjectDir, pi.ContentDir)
+	if err != nil {
+		contentDir = "."
+	}
+	pi.ContentDir = filepath.Join(dir, contentDir)
+	pi.ProjectDir = dir
+	return pi
+}
+
+// restoreProjectFiles copies the files of a project from its backup
+// to dst, checking their hashes, and returns how many it copied
+func restoreProjectFiles(si *SystemInfo, src, dst string, files []PackFile) (int, error) {
+	parent := filepath.Dir(dst)
+	if err := EnsureDir(si.FS, si.Actions, parent); err != nil {
+		return 0, err
+	}
+
+	err := withTransaction(si, parent, func(tx *transaction) error {
+		stagedProject := tx.staged("project")
+		if err := si.Actions.MkdirAll(stagedProject); err != nil {
+			return err
+		}
+		for _, file := range files {
+			if !localPackPath(file.Path) {
+				return &Error{Kind: ErrBackupInvalid, Path: src,
+					Cause: fmt.Errorf("<%s> is outside the project", file.Path)}
+			}
+			srcFile := filepath.Join(src, filepath.FromSlash(file.Path))
+			content, err := si.FS.ReadFile(srcFile)
+			if err != nil {
+				return &Error{Kind: ErrBackupInvalid, Path: srcFile, Cause: err}
+			}
+			if fileHash(content) != file.SHA256 {
+				return &Error{Kind: ErrBackupInvalid, Path: srcFile,
+					Cause: errors.New("doesn't match its hash")}
+			}
+
+			staged := filepath.Join(stagedProject, filepath.FromSlash(file.Path))
+			if err = EnsureDir(si.FS, si.Actions, filepath.Dir(staged)); err != nil {
+				return err
+			}
+			if err = si.Actions.WriteFile(staged, content); err != nil {
+				return err
+			}
+			srcStat, err := si.FS.Stat(srcFile)
+			if err != nil {
+				return &Error{Kind: ErrBackupInvalid, Path: srcFile, Cause: err}
+			}
+			if err = si.Actions.SetModTime(staged, srcStat.ModTime()); err != nil {
+				return err
+			}
+		}
+		return tx.commit("project", dst, ErrProjectExists)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(files), nil
+}
@@ -0,0 +1,120 @@
+package knot
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBackupProjects(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+	gone := testProjectInfo("gone")
+	projects := Projects{"notes": pi, "gone": gone}
+
+	report, err := BackupProjects(si, &projects, "/backup", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.Missing, []string{"gone"}) {
+		t.Errorf("expected gone to be reported missing, got %v", report.Missing)
+	}
+	// the page, its version and the history index
+	if len(report.Projects) != 1 || report.Projects[0].Copied != 3 {
+		t.Fatalf("expected 3 files copied, got %+v", report.Projects)
+	}
+	assertExists(t, mem, "/backup/notes/content/notes-0/page-0.kra")
+	assertExists(t, mem, "/backup/backup.json")
+
+	page, _, err := MakePage(testTemplatePath, si, &pi, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report, err = BackupProjects(si, &projects, "/backup", false); err != nil {
+		t.Fatal(err)
+	}
+	// the new page is a copy of the template, like its version
+	if report.Projects[0].Copied != 2 || report.Projects[0].Unchanged != 2 {
+		t.Errorf("expected only the new page and the history index to be copied, got %+v", report.Projects[0])
+	}
+
+	if err = mem.Remove(page); err != nil {
+		t.Fatal(err)
+	}
+	if report, err = BackupProjects(si, &projects, "/backup", true); err != nil {
+		t.Fatal(err)
+	}
+	if report.Projects[0].Pruned != 1 {
+		t.Errorf("expected the deleted page to be pruned, got %+v", report.Projects[0])
+	}
+	assertNotExists(t, mem, "/backup/notes/content/notes-0/page-1.kra")
+}
+
+func TestRestoreBackup(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+	projects := Projects{"notes": pi}
+	if _, err := BackupProjects(si, &projects, "/backup", false); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := RestoreBackup(si, &projects, "/backup", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Projects[0].Skipped == "" {
+		t.Error("expected a registered project to be left alone")
+	}
+
+	if err = mem.RemoveAll(pi.ProjectDir); err != nil {
+		t.Fatal(err)
+	}
+	delete(projects, "notes")
+	if _, err = RestoreBackup(si, &projects, "/backup", "/restored"); err != nil {
+		t.Fatal(err)
+	}
+	restored, ok := projects["notes"]
+	if !ok || restored.ContentDir != "/restored/notes/content" {
+		t.Fatalf("expected notes to be registered in </restored/notes>, got %+v", restored)
+	}
+	assertExists(t, mem, filepath.Join(GetBatchDir(&restored, 0), GetPageName(0)))
+
+	registered, err := GetProjects(mem, si.ProjectsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registered["notes"] != restored {
+		t.Error("expected the restored project to be saved to the project list")
+	}
+}
+
+func TestBackupKeepsModTimes(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+	projects := Projects{"notes": pi}
+	page := filepath.Join(GetBatchDir(&pi, 0), GetPageName(0))
+	drawn := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := mem.Chtimes(page, drawn, drawn); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := BackupProjects(si, &projects, "/backup", false); err != nil {
+		t.Fatal(err)
+	}
+	backedUp := "/backup/notes/content/notes-0/page-0.kra"
+	if stat, err := mem.Stat(backedUp); err != nil || !stat.ModTime().Equal(drawn) {
+		t.Fatalf("expected the backup to keep the modification time, got %v, %v", stat, err)
+	}
+	assertNotExists(t, mem, "/backup/backup.json.tmp")
+
+	if err := mem.RemoveAll(pi.ProjectDir); err != nil {
+		t.Fatal(err)
+	}
+	delete(projects, "notes")
+	if _, err := RestoreBackup(si, &projects, "/backup", "/restored"); err != nil {
+		t.Fatal(err)
+	}
+	restored := projects["notes"]
+	restoredPage := filepath.Join(GetBatchDir(&restored, 0), GetPageName(0))
+	if stat, err := mem.Stat(restoredPage); err != nil || !stat.ModTime().Equal(drawn) {
+		t.Errorf("expected the restore to keep the modification time, got %v, %v", stat, err)
+	}
+}
@@ -2,13 +2,258 @@ package knot
 
 import (
 	"fmt"
-	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Batch naming schemes, which say how the batches of a project are
+// named after their number
+const (
+	// BatchNumbered names batches name-0, name-1 and so on
+	BatchNumbered = ""
+	// BatchDated names batches after a day, as name-2026-10-18
+	BatchDated = "date"
+	// BatchWeekly names batches after an ISO week, as name-2026-week-07
+	BatchWeekly = "week"
+)
+
+const batchDateLayout = "2006-01-02"
+
+// batchLabelPatterns match the label of a batch in each naming scheme
+var batchLabelPatterns = map[string]string{
+	BatchNumbered: `[0-9]+`,
+	BatchDated:    `[0-9]{4}-[0-9]{2}-[0-9]{2}`,
+	BatchWeekly:   `[0-9]{4}-week-[0-9]{2}`}
+
+// ValidBatchNaming tells whether naming is a batch naming scheme
+func ValidBatchNaming(naming string) bool {
+	_, ok := batchLabelPatterns[naming]
+	return ok
+}
+
+// BatchLabel returns the part of the name of a batch after the content
+// name: its number, date or week
+func BatchLabel(pi *ProjectInfo, batchNumber int) string {
+	switch pi.BatchNaming {
+	case BatchDated:
+		return fmt.Sprintf("%04d-%02d-%02d",
+			batchNumber/10000, batchNumber/100%100, batchNumber%100)
+	case BatchWeekly:
+		return fmt.Sprintf("%04d-week-%02d", batchNumber/100, batchNumber%100)
+	}
+	return strconv.Itoa(batchNumber)
+}
+
 func GetBatchName(pi *ProjectInfo, batchNumber int) string {
-	return fmt.Sprintf("%s-%d", pi.ContentName, batchNumber)
+	return fmt.Sprintf("%s-%s", pi.ContentName, BatchLabel(pi, batchNumber))
+}
+
+// ParseBatchLabel returns the number of the batch with the given label,
+// or false if it isn't a label of the project's naming scheme
+func ParseBatchLabel(pi *ProjectInfo, label string) (int, bool) {
+	if !GetBatchRegexp(pi).MatchString(fmt.Sprintf("%s-%s", pi.ContentName, label)) {
+		return -1, false
+	}
+
+	switch pi.BatchNaming {
+	case BatchDated:
+		date, err := time.Parse(batchDateLayout, label)
+		if err != nil {
+			return -1, false
+		}
+		return BatchForDate(pi, date), true
+	case BatchWeekly:
+		var year, week int
+		if _, err := fmt.Sscanf(label, "%04d-week-%02d", &year, &week); err != nil || week < 1 || week > 53 {
+			return -1, false
+		}
+		return year*100 + week, true
+	}
+	number, err := strconv.Atoi(label)
+	if err != nil {
+		return -1, false
+	}
+	return number, true
+}
+
+// ParseBatchName returns the number of the batch with the given
+// directory name, or false if it doesn't name a batch of the project
+func ParseBatchName(pi *ProjectInfo, name string) (int, bool) {
+	prefix := pi.ContentName + "-"
+	if !strings.HasPrefix(name, prefix) {
+		return -1, false
+	}
+	return ParseBatchLabel(pi, strings.TrimPrefix(name, prefix))
+}
+
+// ParseBatch returns the number of the batch given on the command line
+// by its label, as in 3, 2026-10-18 or 2026-week-07
+func ParseBatch(pi *ProjectInfo, label string) (int, error) {
+	number, ok := ParseBatchLabel(pi, label)
+	if !ok {
+		return -1, UsageError("invalid batch <%s>", label)
+	}
+	return number, nil
+}
+
+// ParseBatchPath returns the group and number of a batch given by its
+// label, or by its path through the groups, as in unit-2/3
+func ParseBatchPath(pi *ProjectInfo, batchPath string) (ProjectInfo, int, error) {
+	group, label := *pi, batchPath
+	if i := strings.LastIndex(batchPath, "/"); i >= 0 {
+		if !validGroup(batchPath[:i]) {
+			return group, -1, UsageError("invalid group <%s>", batchPath[:i])
+		}
+		group, label = pi.InGroup(batchPath[:i]), batchPath[i+1:]
+	}
+	if number, ok := ParseBatchName(&group, label); ok {
+		return group, number, nil
+	}
+	number, err := ParseBatch(&group, label)
+	return group, number, err
+}
+
+// BatchPath returns the label of a batch prefixed with its group, which
+// ParseBatchPath reads back
+func BatchPath(pi *ProjectInfo, batchNumber int) string {
+	if pi.Group == "" {
+		return BatchLabel(pi, batchNumber)
+	}
+	return pi.Group + "/" + BatchLabel(pi, batchNumber)
+}
+
+// PathBatch returns the group and number of the batch that contains
+// path, with -1 if it isn't inside a batch
+func PathBatch(fsys FileSystem, pi *ProjectInfo, path string) (ProjectInfo, int) {
+	group := pi.InGroup(PathGroup(fsys, pi, filepath.Dir(path)))
+	return group, GetPathBatchNumber(&group, path)
+}
+
+// BatchGroup is a group of batches, along with the groups nested in it
+type BatchGroup struct {
+	Info    ProjectInfo
+	Batches []int
+	Groups  []BatchGroup
+}
+
+// Name returns the name of the group's directory, empty for a project
+func (group *BatchGroup) Name() string {
+	if group.Info.Group == "" {
+		return ""
+	}
+	return path.Base(group.Info.Group)
+}
+
+// Walk calls visit for every batch in the group and its nested groups,
+// in order: the group's own batches first, then each nested group
+func (group *BatchGroup) Walk(visit func(pi *ProjectInfo, batchNumber int) error) error {
+	for _, batchNumber := range group.Batches {
+		if err := visit(&group.Info, batchNumber); err != nil {
+			return err
+		}
+	}
+	for i := range group.Groups {
+		if err := group.Groups[i].Walk(visit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetBatchGroups returns the batches of the group of pi and the groups
+// nested in it. Only directories marked with a knot.zy are groups, and
+// groups without any batch are left out
+func GetBatchGroups(fsys FileSystem, pi *ProjectInfo) (BatchGroup, error) {
+	result := BatchGroup{Info: *pi}
+
+	dir, err := fsys.ReadDir(pi.ContentDir)
+	if err != nil {
+		return result, err
+	}
+	for _, item := range dir {
+		name := item.Name()
+		if !item.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if number, ok := ParseBatchName(pi, name); ok {
+			result.Batches = append(result.Batches, number)
+			continue
+		}
+
+		group := pi.InGroup(path.Join(pi.Group, name))
+		if !isGroup(fsys, &group) {
+			continue
+		}
+		nested, err := GetBatchGroups(fsys, &group)
+		if err != nil {
+			return result, err
+		}
+		if len(nested.Batches) > 0 || len(nested.Groups) > 0 {
+			result.Groups = append(result.Groups, nested)
+		}
+	}
+	sort.Ints(result.Batches)
+	sort.Slice(result.Groups, func(i, j int) bool {
+		return naturalLess(result.Groups[i].Name(), result.Groups[j].Name())
+	})
+	return result, nil
+}
+
+// naturalLess orders names by their text, and by the numbers in them
+// by value, so that unit-2 comes before unit-10
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		aDigits, bDigits := leadingDigits(a), leadingDigits(b)
+		if aDigits != "" && bDigits != "" {
+			aNumber, _ := strconv.Atoi(aDigits)
+			bNumber, _ := strconv.Atoi(bDigits)
+			if aNumber != bNumber {
+				return aNumber < bNumber
+			}
+			a, b = a[len(aDigits):], b[len(bDigits):]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func leadingDigits(s string) string {
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	return s[:end]
+}
+
+// BatchForDate returns the number of the batch of a dated or weekly
+// project that t falls in, or -1 for a numbered project
+func BatchForDate(pi *ProjectInfo, t time.Time) int {
+	switch pi.BatchNaming {
+	case BatchDated:
+		return t.Year()*10000 + int(t.Month())*100 + t.Day()
+	case BatchWeekly:
+		year, week := t.ISOWeek()
+		return year*100 + week
+	}
+	return -1
+}
+
+// FirstBatchNumber returns the number of the batch a new project
+// starts with: 0, or the batch of now
+func FirstBatchNumber(pi *ProjectInfo, now time.Time) int {
+	if pi.BatchNaming == BatchNumbered {
+		return 0
+	}
+	return BatchForDate(pi, now)
 }
 
 func GetBatchDir(pi *ProjectInfo, batchNumber int) string {
@@ -20,90 +265,267 @@ func GetPageName(pageNumber int) string {
 	return fmt.Sprintf("page-%d.kra", pageNumber)
 }
 
+// GetBatchNumbers returns the numbers of the batches of the project
+// in ascending order
+func GetBatchNumbers(fsys FileSystem, pi *ProjectInfo) ([]int, error) {
+	dir, err := fsys.ReadDir(pi.ContentDir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]int, 0, len(dir))
+	for _, item := range dir {
+		if !item.IsDir() {
+			continue
+		}
+		if number, ok := ParseBatchName(pi, item.Name()); ok {
+			result = append(result, number)
+		}
+	}
+	sort.Ints(result)
+
+	return result, nil
+}
+
+// GetPageNumbers returns the numbers of all pages with the given
+// extension in a directory, in ascending order
+func GetPageNumbers(fsys FileSystem, dirPath string, extension string) ([]int, error) {
+	pageRegexp, _ := regexp.Compile(fmt.Sprintf(
+		"^page-([0-9]+)%s$", regexp.QuoteMeta(extension)))
+
+	return numbersOfMatches(fsys, dirPath, pageRegexp)
+}
+
+func numbersOfMatches(fsys FileSystem, dirPath string, re *regexp.Regexp) ([]int, error) {
+	dir, err := fsys.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]int, 0, len(dir))
+	for _, item := range dir {
+		if item.IsDir() {
+			continue
+		}
+		match := re.FindStringSubmatch(item.Name())
+		if match == nil {
+			continue
+		}
+		number, err := strconv.Atoi(match[1])
+		if err != nil {
+			continue
+		}
+		result = append(result, number)
+	}
+	sort.Ints(result)
+
+	return result, nil
+}
+
+// MakeBatch creates a batch from the template, with a copy of the
+// template page as its page-0.kra
 func MakeBatch(templatePath string, si *SystemInfo, pi *ProjectInfo, batchNumber int, open bool) error {
 	templateBatchDir := filepath.Join(templatePath, "batch")
+	templatePage := filepath.Join(templateBatchDir, "page.kra")
+	if _, err := si.FS.Stat(templatePage); err != nil {
+		return &Error{Kind: ErrTemplateNotFound, Path: templatePath, Cause: err}
+	}
 
-	newBatchDir := filepath.Join(
-		pi.ContentDir, GetBatchName(pi, batchNumber))
+	newBatchDir := GetBatchDir(pi, batchNumber)
+	if _, err := si.FS.Stat(newBatchDir); err == nil {
+		return &Error{Kind: ErrBatchExists, Path: newBatchDir}
+	}
+	if err := ensureGroup(si, pi); err != nil {
+		return err
+	}
 
-	if err := CopyDir(templateBatchDir, newBatchDir); err != nil {
+	err := withTransaction(si, pi.ContentDir, func(tx *transaction) error {
+		stagedBatch := tx.staged("batch")
+		if err := si.Actions.CopyDir(templateBatchDir, stagedBatch); err != nil {
+			return err
+		}
+		err := si.Actions.MoveFile(
+			filepath.Join(stagedBatch, "page.kra"),
+			filepath.Join(stagedBatch, GetPageName(0)))
+		if err != nil {
+			return err
+		}
+		return tx.commit("batch", newBatchDir, ErrBatchExists)
+	})
+	if err != nil {
 		return err
 	}
 
-	page0 := filepath.Join(newBatchDir, "page-0.kra")
-	_, err := MoveFile(
-		filepath.Join(newBatchDir, "page.kra"),
-		page0)
+	firstPage := filepath.Join(newBatchDir, GetPageName(0))
+	snapshotCreated(si, pi, "new batch", firstPage)
+	// the batch is there even if its first page doesn't open
+	if err = OpenFile(si, firstPage, open); err != nil {
+		si.Warn(err)
+	}
+	return nil
+}
 
-	OpenFile(si, page0, open)
-	return err
+// ensureGroup creates the directories of the group of pi, each marked
+// as a group with an empty knot.zy
+func ensureGroup(si *SystemInfo, pi *ProjectInfo) error {
+	for _, group := range pi.groups() {
+		if err := EnsureDir(si.FS, si.Actions, group.ContentDir); err != nil {
+			return err
+		}
+		if isGroup(si.FS, &group) {
+			continue
+		}
+		if err := si.Actions.WriteFile(GetGroupConfigFile(&group), nil); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+// CreateProject creates the project directory along with its first
+// batch
 func CreateProject(templatePath string, si *SystemInfo, pi *ProjectInfo, open bool) error {
-	if _, err := os.Stat(pi.ProjectDir); err == nil {
-		fmt.Printf("directory <%s> already exists. Assuming you simply want to register it instead of creating a new project\n", pi.ProjectDir)
-		return nil
-	}
+	_, err := createProject(templatePath, si, pi, FirstBatchNumber(pi, time.Now()), open)
+	return err
+}
 
-	if err := EnsureDirExists(pi.ProjectDir); err != nil {
-		return err
+// createProject tells whether the project was created, rather than
+// found in place
+func createProject(templatePath string, si *SystemInfo, pi *ProjectInfo, firstBatch int, open bool) (bool, error) {
+	if _, err := si.FS.Stat(pi.ProjectDir); err == nil {
+		si.Warn(fmt.Errorf("directory <%s> already exists. Assuming you simply want to register it instead of creating a new project", pi.ProjectDir))
+		return false, nil
 	}
-	if err := EnsureDirExists(pi.ContentDir); err != nil {
-		return err
+
+	if err := si.Actions.MkdirAll(pi.ContentDir); err != nil {
+		return false, err
 	}
 
-	return MakeBatch(templatePath, si, pi, 0, open)
+	if err := MakeBatch(templatePath, si, pi, firstBatch, open); err != nil {
+		si.Actions.RemoveAll(pi.ProjectDir)
+		return false, err
+	}
+	return true, nil
 }
 
-func MakePage(templatePath string, si *SystemInfo, pi *ProjectInfo, batchNumber int, open bool) error {
+// MakePage adds a copy of the template page to a batch, numbered after
+// its last page, and returns its path and number
+func MakePage(templatePath string, si *SystemInfo, pi *ProjectInfo, batchNumber int, open bool) (string, int, error) {
 	batchDir := GetBatchDir(pi, batchNumber)
+	if stat, err := si.FS.Stat(batchDir); err != nil || !stat.IsDir() {
+		return "", 0, &Error{Kind: ErrBatchNotFound, Path: batchDir}
+	}
+
+	templatePage := filepath.Join(templatePath, "batch", "page.kra")
+	if _, err := si.FS.Stat(templatePage); err != nil {
+		return "", 0, &Error{Kind: ErrTemplateNotFound, Path: templatePath, Cause: err}
+	}
+
+	ci := ResolveConfigInfo(si, pi, batchNumber)
+	if err := ci.ConfigErr(); err != nil {
+		return "", 0, err
+	}
 
-	pageNumber, err := NumberOfMatches(
-		batchDir, GetPageRegexp(".kra"))
+	newPage, pageNumber, err := addPage(si, pi, batchNumber, func(staged string) error {
+		return si.Actions.CopyFile(templatePage, staged)
+	})
 	if err != nil {
-		return err
+		return "", 0, err
 	}
-	newPage := filepath.Join(batchDir, GetPageName(pageNumber))
+	snapshotCreated(si, pi, "new page", newPage)
+
+	if open {
+		err = openFileWith(si, &ci, newPage, 1, filepath.Base(pi.Root().ProjectDir))
+	}
+	return newPage, pageNumber, err
+}
 
-	_, err = CopyFile(
-		filepath.Join(templatePath, "batch", "page.kra"),
-		newPage)
+// addPage adds a page numbered after the last page of a batch, whose
+// content is written to the staged path by write, and returns its path
+// and number
+func addPage(si *SystemInfo, pi *ProjectInfo, batchNumber int, write func(staged string) error) (string, int, error) {
+	batchDir := GetBatchDir(pi, batchNumber)
+	pageNumbers, err := GetPageNumbers(si.FS, batchDir, ".kra")
 	if err != nil {
-		return err
+		return "", 0, &Error{Kind: ErrBatchNotFound, Path: batchDir, Cause: err}
 	}
+	pageNumber := nextNumber(pageNumbers)
+	pageName := GetPageName(pageNumber)
+	newPage := filepath.Join(batchDir, pageName)
 
-	OpenFile(si, newPage, open)
+	err = withTransaction(si, pi.ContentDir, func(tx *transaction) error {
+		if err := write(tx.staged(pageName)); err != nil {
+			return err
+		}
+		return tx.commit(pageName, newPage, ErrPageExists)
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	return newPage, pageNumber, nil
+}
 
-	return nil
+// nextNumber returns the number after the largest of numbers, which
+// are sorted, or 0 if there are none
+func nextNumber(numbers []int) int {
+	if len(numbers) == 0 {
+		return 0
+	}
+	return numbers[len(numbers)-1] + 1
 }
 
-func OpenKraFilesInBatch(pi *ProjectInfo, batchNumber int, open bool) error {
+// OpenKraFilesInBatch opens the pages of a batch with the KritaCommand,
+// or only the most recent ones if recent is positive
+func OpenKraFilesInBatch(si *SystemInfo, pi *ProjectInfo, batchNumber int, recent int, open bool) error {
 	if !open {
 		return nil
 	}
 
+	ci := ResolveConfigInfo(si, pi, batchNumber)
+	if err := ci.ConfigErr(); err != nil {
+		return err
+	}
+
 	batchPath := GetBatchDir(pi, batchNumber)
 
-	batchDir, err := os.ReadDir(batchPath)
+	pageNumbers, err := GetPageNumbers(si.FS, batchPath, ".kra")
 	if err != nil {
-		return err
+		return &Error{Kind: ErrBatchNotFound, Path: batchPath}
 	}
 
-	pageRegexp := GetPageRegexp(".kra")
+	if recent > 0 && recent < len(pageNumbers) {
+		modTimes := make(map[int]time.Time)
+		for _, pageNumber := range pageNumbers {
+			pageStat, err := si.FS.Stat(
+				filepath.Join(batchPath, GetPageName(pageNumber)))
+			if err != nil {
+				return err
+			}
+			modTimes[pageNumber] = pageStat.ModTime()
+		}
 
-	pages := make([]string, 0, 10)
+		sort.SliceStable(pageNumbers, func(i, j int) bool {
+			return modTimes[pageNumbers[i]].After(modTimes[pageNumbers[j]])
+		})
+		pageNumbers = pageNumbers[:recent]
+		sort.Ints(pageNumbers)
+	}
 
-	for _, item := range batchDir {
-		if item.IsDir() {
-			continue
-		}
-		itemName := item.Name()
+	pages := make([]string, len(pageNumbers))
+	for i, pageNumber := range pageNumbers {
+		pages[i] = filepath.Join(batchPath, GetPageName(pageNumber))
+	}
 
-		if pageRegexp.Match([]byte(itemName)) {
-			pages = append(pages, filepath.Join(batchPath, itemName))
+	if _, ok := ci.KritaCommand.(*TemplateCommandRunner); ok {
+		projectName := filepath.Base(pi.ProjectDir)
+		for _, page := range pages {
+			ctx := ViewerContext{File: page, Page: 1, Project: projectName}
+			if err := si.Actions.Start(ci.KritaCommand, ctx.inputs()); err != nil {
+				return err
+			}
 		}
+		return nil
 	}
 
-	cmd := exec.Command("krita", pages...)
-	return cmd.Start()
+	return si.Actions.Start(ci.KritaCommand, pages)
 }
@@ -0,0 +1,400 @@
+package knot
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateProject(t *testing.T) {
+	_, mem, pi := newTestProject(t)
+
+	batchDir := GetBatchDir(&pi, 0)
+	assertExists(t, mem, filepath.Join(batchDir, "page-0.kra"))
+	assertNotExists(t, mem, filepath.Join(batchDir, "page.kra"))
+
+	batchNumbers, err := GetBatchNumbers(mem, &pi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(batchNumbers, []int{0}) {
+		t.Errorf("expected batch 0 only, got %v", batchNumbers)
+	}
+}
+
+func TestCreateProjectInExistingDirectory(t *testing.T) {
+	si, mem := newTestSystemInfo(t)
+	pi := testProjectInfo("notes")
+	mustMkdirAll(t, mem, pi.ProjectDir)
+
+	created, err := createProject(testTemplatePath, si, &pi, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the directory is only meant to be registered, so the project init
+	// hook mustn't run either
+	if created {
+		t.Error("expected an existing directory not to count as created")
+	}
+	assertNotExists(t, mem, pi.ContentDir)
+}
+
+func TestMakeBatch(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+
+	if err := MakeBatch(testTemplatePath, si, &pi, 1, false); err != nil {
+		t.Fatal(err)
+	}
+	assertExists(t, mem, filepath.Join(GetBatchDir(&pi, 1), "page-0.kra"))
+
+	batchNumbers, err := GetBatchNumbers(mem, &pi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(batchNumbers, []int{0, 1}) {
+		t.Errorf("expected batches 0 and 1, got %v", batchNumbers)
+	}
+
+	if err = MakeBatch(testTemplatePath, si, &pi, 1, false); !errors.Is(err, ErrBatchExists) {
+		t.Errorf("expected ErrBatchExists, got %v", err)
+	}
+	assertNoStagingLeft(t, mem, &pi)
+}
+
+func TestMakeBatchWithoutTemplate(t *testing.T) {
+	si, mem := newTestSystemInfo(t)
+	pi := testProjectInfo("notes")
+	mustMkdirAll(t, mem, pi.ContentDir)
+
+	err := MakeBatch("/config/templates/missing", si, &pi, 0, false)
+	if !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("expected ErrTemplateNotFound, got %v", err)
+	}
+	assertNotExists(t, mem, GetBatchDir(&pi, 0))
+	assertNoStagingLeft(t, mem, &pi)
+}
+
+func TestCreateProjectRollsBack(t *testing.T) {
+	si, mem := newTestSystemInfo(t)
+	pi := testProjectInfo("notes")
+
+	// a template whose batch can't be copied
+	mustMkdirAll(t, mem, "/config/templates/broken/batch/page.kra")
+
+	err := CreateProject("/config/templates/broken", si, &pi, false)
+	if err == nil {
+		t.Fatal("creating a project from a broken template should fail")
+	}
+	assertNotExists(t, mem, pi.ProjectDir)
+}
+
+func TestMakePage(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+
+	for _, expected := range []string{"page-1.kra", "page-2.kra"} {
+		page, _, err := MakePage(testTemplatePath, si, &pi, 0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page != filepath.Join(GetBatchDir(&pi, 0), expected) {
+			t.Errorf("expected <%s>, got <%s>", expected, page)
+		}
+	}
+
+	pageNumbers, err := GetPageNumbers(mem, GetBatchDir(&pi, 0), ".kra")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pageNumbers, []int{0, 1, 2}) {
+		t.Errorf("expected pages 0 to 2, got %v", pageNumbers)
+	}
+
+	template, _ := mem.ReadFile(filepath.Join(testTemplatePath, "batch", "page.kra"))
+	page, _ := mem.ReadFile(filepath.Join(GetBatchDir(&pi, 0), "page-2.kra"))
+	if !bytes.Equal(template, page) {
+		t.Error("a new page should be a copy of the template page")
+	}
+
+	if _, _, err = MakePage(testTemplatePath, si, &pi, 7, false); !errors.Is(err, ErrBatchNotFound) {
+		t.Errorf("expected ErrBatchNotFound, got %v", err)
+	}
+	assertNoStagingLeft(t, mem, &pi)
+}
+
+func TestMakePageAfterDeletedPage(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+	batchDir := GetBatchDir(&pi, 0)
+	mustWriteFile(t, mem, filepath.Join(batchDir, "page-2.kra"), []byte("drawn"))
+
+	page, _, err := MakePage(testTemplatePath, si, &pi, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page != filepath.Join(batchDir, "page-3.kra") {
+		t.Errorf("expected page-3.kra, got <%s>", page)
+	}
+	if drawn, _ := mem.ReadFile(filepath.Join(batchDir, "page-2.kra")); string(drawn) != "drawn" {
+		t.Error("an existing page must never be overwritten")
+	}
+}
+
+func TestMoveFileKeepsSourceOnFailure(t *testing.T) {
+	mem := NewMemFileSystem()
+	mustMkdirAll(t, mem, "/dir")
+	mustWriteFile(t, mem, "/dir/page.kra", []byte("page"))
+
+	if _, err := MoveFile(mem, "/dir/page.kra", "/missing/page-0.kra"); err == nil {
+		t.Fatal("moving into a missing directory should fail")
+	}
+	assertExists(t, mem, "/dir/page.kra")
+}
+
+func TestCopyDirRefusesExistingDestination(t *testing.T) {
+	mem := NewMemFileSystem()
+	mustMkdirAll(t, mem, "/src")
+	mustMkdirAll(t, mem, "/dst")
+
+	if err := CopyDir(mem, "/src", "/dst"); !os.IsExist(err) {
+		t.Errorf("expected an exist error, got %v", err)
+	}
+	assertNotExists(t, mem, "/dst/src")
+}
+
+// assertNoStagingLeft checks that no transaction left its staging
+// directory behind
+func assertNoStagingLeft(t *testing.T, fsys FileSystem, pi *ProjectInfo) {
+	t.Helper()
+	entries, _ := fsys.ReadDir(pi.ContentDir)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".knot-stage-") {
+			t.Errorf("staging directory <%s> was left behind", entry.Name())
+		}
+	}
+}
+
+func TestBatchNaming(t *testing.T) {
+	pi := testProjectInfo("notes")
+	day := time.Date(2026, 1, 5, 9, 0, 0, 0, time.Local)
+
+	for _, naming := range []string{BatchDated, BatchWeekly} {
+		pi.BatchNaming = naming
+		number := BatchForDate(&pi, day)
+		name := GetBatchName(&pi, number)
+		if !GetBatchRegexp(&pi).MatchString(name) {
+			t.Errorf("expected <%s> to match the batches of %s naming", name, naming)
+		}
+		if parsed, ok := ParseBatchName(&pi, name); !ok || parsed != number {
+			t.Errorf("expected <%s> to be batch %d, got %d", name, number, parsed)
+		}
+		if later := BatchForDate(&pi, day.AddDate(0, 0, 7)); later <= number {
+			t.Errorf("expected a later batch than %d a week later, got %d", number, later)
+		}
+	}
+	if name := GetBatchName(&pi, BatchForDate(&pi, day)); name != "notes-2026-week-02" {
+		t.Errorf("expected notes-2026-week-02, got %s", name)
+	}
+
+	pi.BatchNaming = BatchDated
+	for _, name := range []string{"notes-3", "notes-2026-02-30", "notes-2026-1-5"} {
+		if _, ok := ParseBatchName(&pi, name); ok {
+			t.Errorf("expected <%s> not to name a dated batch", name)
+		}
+	}
+	if _, err := ParseBatch(&pi, "yesterday"); !errors.Is(err, ErrUsage) {
+		t.Errorf("expected ErrUsage, got %v", err)
+	}
+}
+
+func TestDatedBatches(t *testing.T) {
+	si, mem := newTestSystemInfo(t)
+	pi := testProjectInfo("notes")
+	pi.BatchNaming = BatchDated
+	if err := CreateProject(testTemplatePath, si, &pi, false); err != nil {
+		t.Fatal(err)
+	}
+	today := BatchForDate(&pi, time.Now())
+	assertExists(t, mem, filepath.Join(pi.ContentDir, "notes-"+time.Now().Format("2006-01-02")))
+
+	// numbered directories aren't batches of a dated project
+	if err := MakeBatch(testTemplatePath, si, &pi, 20251231, false); err != nil {
+		t.Fatal(err)
+	}
+	mustMkdirAll(t, mem, filepath.Join(pi.ContentDir, "notes-4"))
+	batchNumbers, err := GetBatchNumbers(mem, &pi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(batchNumbers, []int{20251231, today}) {
+		t.Errorf("expected the batches in chronological order, got %v", batchNumbers)
+	}
+}
+
+func TestMigrateBatchNaming(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+	mustWriteFile(t, mem, filepath.Join(GetBatchDir(&pi, 0), GetPageName(0)), testKra(t, 6, 6))
+	mustWriteFile(t, mem, filepath.Join(GetBatchDir(&pi, 0), "notes-0.pdf"), []byte("pdf"))
+	star := func(annotation *Annotation) { annotation.Star = true }
+	if _, err := Annotate(si, &pi, 0, 0, star); err != nil {
+		t.Fatal(err)
+	}
+
+	renames, err := MigrateBatchNaming(si, &pi, BatchDated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dated := pi
+	dated.BatchNaming = BatchDated
+	today := BatchForDate(&dated, time.Now())
+	expected := []BatchRename{{From: "notes-0", To: GetBatchName(&dated, today)}}
+	if !reflect.DeepEqual(renames, expected) {
+		t.Errorf("expected %v, got %v", expected, renames)
+	}
+	assertExists(t, mem, filepath.Join(GetBatchDir(&dated, today), GetBatchName(&dated, today)+".pdf"))
+	assertNotExists(t, mem, GetBatchDir(&pi, 0))
+	if annotations, _ := GetAnnotations(mem, &dated); !annotations.Get(today, 0).Star {
+		t.Errorf("expected the star to move to batch %d, got %v", today, annotations)
+	}
+	versions, err := StoredPageHistory(mem, &dated, filepath.Join(GetBatchDir(&dated, today), GetPageName(0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Message != "before batch naming migration" {
+		t.Errorf("expected the drawn page to be saved before the migration, got %+v", versions)
+	}
+
+	if _, err = MigrateBatchNaming(si, &dated, BatchWeekly); !errors.Is(err, ErrUsage) {
+		t.Errorf("expected ErrUsage for a dated project, got %v", err)
+	}
+}
+
+func TestMigrateBatchNamingCollision(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+	if err := MakeBatch(testTemplatePath, si, &pi, 1, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// both batches were last modified today
+	if _, err := MigrateBatchNaming(si, &pi, BatchWeekly); !errors.Is(err, ErrBatchExists) {
+		t.Errorf("expected ErrBatchExists, got %v", err)
+	}
+	assertExists(t, mem, GetBatchDir(&pi, 0))
+	assertExists(t, mem, GetBatchDir(&pi, 1))
+}
+
+func TestBatchGroups(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+	for _, group := range []string{"unit-10", "unit-2", "unit-2/part-1"} {
+		info := pi.InGroup(group)
+		if err := MakeBatch(testTemplatePath, si, &info, 3, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unit10 := pi.InGroup("unit-10")
+	assertExists(t, mem, GetGroupConfigFile(&unit10))
+	mustMkdirAll(t, mem, filepath.Join(pi.ContentDir, "empty"))
+	// a directory of batches without a knot.zy isn't a group
+	mustMkdirAll(t, mem, filepath.Join(pi.ContentDir, "drafts", "notes-1"))
+
+	groups, err := GetBatchGroups(mem, &pi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var batches []string
+	groups.Walk(func(group *ProjectInfo, batchNumber int) error {
+		batches = append(batches, BatchPath(group, batchNumber))
+		return nil
+	})
+	expected := []string{"0", "unit-2/3", "unit-2/part-1/3", "unit-10/3"}
+	if !reflect.DeepEqual(batches, expected) {
+		t.Errorf("expected %v, got %v", expected, batches)
+	}
+
+	unit := pi.InGroup("unit-2")
+	for _, batchPath := range []string{"unit-2/3", "unit-2/notes-3", "3"} {
+		group, number, err := ParseBatchPath(&unit, batchPath)
+		if err != nil || group.Group != "unit-2" || number != 3 {
+			t.Errorf("<%s>: expected batch 3 of unit-2, got %d of <%s>, %v",
+				batchPath, number, group.Group, err)
+		}
+	}
+	if _, _, err = ParseBatchPath(&pi, "../other/3"); !errors.Is(err, ErrUsage) {
+		t.Errorf("expected ErrUsage for a group outside the project, got %v", err)
+	}
+}
+
+func TestExportProjectOutline(t *testing.T) {
+	si, _, pi := newTestProject(t)
+	unit := pi.InGroup("unit-1")
+	if err := MakeBatch(testTemplatePath, si, &unit, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := MakePage(testTemplatePath, si, &unit, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Annotate(si, &unit, 0, 1, func(annotation *Annotation) {
+		annotation.Bookmark = "Lemma 2"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the batch in the group exports at a lower quality than the rest
+	si.configs = newConfigCache()
+	batchFiles := resolvedConfigFiles(si, &unit, 0)
+	batchCI := LoadLayeredConfigInfo()
+	batchCI.ExportQuality = 60
+	si.configs.entries[strings.Join(batchFiles, "\x00")] = cachedConfig{
+		si: si, modTimes: make([]time.Time, len(batchFiles)), ci: batchCI}
+
+	var runs []string
+	si.Actions = &RecordingActions{Actions: NoActions{}, Record: func(action string) {
+		if strings.HasPrefix(action, "run ") {
+			runs = append(runs, action)
+		}
+	}}
+	output, err := ExportProject(&unit, si)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != filepath.Join(pi.ProjectDir, "notes.pdf") {
+		t.Errorf("expected the pdf in the project directory, got <%s>", output)
+	}
+
+	if len(runs) != 1 {
+		t.Fatalf("expected the export script to run once, got %v", runs)
+	}
+	outline := []string{"--outline=0:0:notes-0", "--outline=0:1:unit-1",
+		"--outline=1:1:notes-0", "--outline=2:2:Lemma 2"}
+	last := -1
+	for _, entry := range outline {
+		i := strings.Index(runs[0], entry)
+		if i <= last {
+			t.Fatalf("expected %v in order in <%s>", outline, runs[0])
+		}
+		last = i
+	}
+	if !strings.Contains(runs[0], "--quality=1:60 --quality=2:60") || strings.Contains(runs[0], "--quality=0:") {
+		t.Errorf("expected only the pages of unit-1 to have their own quality in <%s>", runs[0])
+	}
+}
+
+func TestMakeBatchWarnsIfPageDoesNotOpen(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+	var warnings bytes.Buffer
+	si.Warnings = &warnings
+	si.Actions = failingActions{Actions: si.Actions, err: errors.New("no krita")}
+
+	if err := MakeBatch(testTemplatePath, si, &pi, 1, true); err != nil {
+		t.Fatal(err)
+	}
+	assertExists(t, mem, filepath.Join(GetBatchDir(&pi, 1), "page-0.kra"))
+	if !strings.Contains(warnings.String(), "no krita") {
+		t.Errorf("expected a warning that the page didn't open, got %q", warnings.String())
+	}
+}
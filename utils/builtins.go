@@ -0,0 +1,310 @@
+package knot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/glycerine/zygomys/v6/zygo"
+)
+
+// zygoBuiltins holds the state of the knot functions in zygo. Its
+// SystemInfo is bound by BindZygoBuiltins
+type zygoBuiltins struct {
+	si *SystemInfo
+}
+
+// AddZygoBuiltins registers the knot functions and defcommand in a
+// zygo environment
+func AddZygoBuiltins(zygoEnv *zygo.Zlisp, ci *ConfigInfo) {
+	builtins := &zygoBuiltins{}
+	ci.builtins = builtins
+
+	zygoEnv.AddFunction("defcommand", func(env *zygo.Zlisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+		if len(args) != 2 {
+			return zygo.SexpNull, zygo.WrongNargs
+		}
+		commandName, err := stringFromSexp(name, args[0])
+		if err != nil {
+			return zygo.SexpNull, err
+		}
+		function, ok := args[1].(*zygo.SexpFunction)
+		if !ok {
+			return zygo.SexpNull, fmt.Errorf(
+				"%s: second argument must be a function", name)
+		}
+		ci.Commands[commandName] = function
+		return zygo.SexpNull, nil
+	})
+
+	zygoEnv.AddFunction("defviewer", func(env *zygo.Zlisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+		if len(args) != 2 {
+			return zygo.SexpNull, zygo.WrongNargs
+		}
+		key, err := stringFromSexp(name, args[0])
+		if err == nil {
+			key, err = viewerKey(key)
+		}
+		if err != nil {
+			return zygo.SexpNull, err
+		}
+		viewer, err := CommandRunnerFromSexp(env, args[1])
+		if err != nil {
+			return zygo.SexpNull, fmt.Errorf("%s %s: %w", name, key, err)
+		}
+		ci.Viewers[key] = viewerFromRunner(viewer)
+		ci.Sources[fmt.Sprintf("viewer %s", key)] = ci.loadingFile
+		return zygo.SexpNull, nil
+	})
+
+	zygoEnv.AddFunction("knot-projects", builtins.projects)
+	zygoEnv.AddFunction("knot-current-project", builtins.currentProject)
+	zygoEnv.AddFunction("knot-batches", builtins.batches)
+	zygoEnv.AddFunction("knot-pages", builtins.pages)
+	zygoEnv.AddFunction("knot-export", builtins.export)
+	zygoEnv.AddFunction("knot-open", builtins.open)
+	zygoEnv.AddFunction("knot-new-page", builtins.newPage)
+}
+
+// BindZygoBuiltins makes si available to the knot functions in zygo
+func (si *SystemInfo) BindZygoBuiltins() {
+	if si.builtins != nil {
+		si.builtins.si = si
+	}
+}
+
+// RunCommand calls the custom command with the given name, passing it
+// args as strings
+func RunCommand(si *SystemInfo, name string, args []string) error {
+	command, ok := si.Commands[name]
+	if !ok {
+		searched := make([]string, len(si.ConfigFiles))
+		for i, configFile := range si.ConfigFiles {
+			searched[i] = "<" + configFile + ">"
+		}
+		return UsageError("no command called <%s> is defined in %s",
+			name, strings.Join(searched, ", "))
+	}
+
+	sexpArgs := make([]zygo.Sexp, len(args))
+	for i, arg := range args {
+		sexpArgs[i] = &zygo.SexpStr{S: arg}
+	}
+
+	_, err := si.ZygoEnv.Apply(command, sexpArgs)
+	return err
+}
+
+func (builtins *zygoBuiltins) systemInfo(name string) (*SystemInfo, error) {
+	if builtins.si == nil {
+		return nil, fmt.Errorf(
+			"%s can't be called while config.zy is being loaded", name)
+	}
+	return builtins.si, nil
+}
+
+// workspace returns a workspace over the SystemInfo of the builtins.
+// Its actions already stop with the context of the command being run
+func (builtins *zygoBuiltins) workspace(name string) (*Workspace, error) {
+	si, err := builtins.systemInfo(name)
+	if err != nil {
+		return nil, err
+	}
+	projects, err := GetProjects(si.FS, si.ProjectsFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Workspace{si: si, projects: projects}, nil
+}
+
+func (builtins *zygoBuiltins) project(name string, arg zygo.Sexp) (*Project, error) {
+	w, err := builtins.workspace(name)
+	if err != nil {
+		return nil, err
+	}
+	projectName, err := stringFromSexp(name, arg)
+	if err != nil {
+		return nil, err
+	}
+	return w.Project(projectName)
+}
+
+// batch returns the batch given by its label or its path through the
+// groups, see ParseBatchPath
+func (builtins *zygoBuiltins) batch(name string, projectArg, labelArg zygo.Sexp) (*Batch, error) {
+	project, err := builtins.project(name, projectArg)
+	if err != nil {
+		return nil, err
+	}
+	label, err := labelFromSexp(name, labelArg)
+	if err != nil {
+		return nil, err
+	}
+	return project.BatchLabeled(label)
+}
+
+func (builtins *zygoBuiltins) projects(env *zygo.Zlisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+	if len(args) != 0 {
+		return zygo.SexpNull, zygo.WrongNargs
+	}
+	si, err := builtins.systemInfo(name)
+	if err != nil {
+		return zygo.SexpNull, err
+	}
+	projects, err := GetProjects(si.FS, si.ProjectsFile)
+	if err != nil {
+		return zygo.SexpNull, err
+	}
+
+	names := make([]string, 0, len(projects))
+	for projectName := range projects {
+		names = append(names, projectName)
+	}
+	sort.Strings(names)
+
+	return stringsToSexp(env, names), nil
+}
+
+func (builtins *zygoBuiltins) currentProject(env *zygo.Zlisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+	if len(args) != 0 {
+		return zygo.SexpNull, zygo.WrongNargs
+	}
+	si, err := builtins.systemInfo(name)
+	if err != nil {
+		return zygo.SexpNull, err
+	}
+	projects, err := GetProjects(si.FS, si.ProjectsFile)
+	if err != nil {
+		return zygo.SexpNull, err
+	}
+
+	projectsByDir := ArrangeProjectsByDir(&projects)
+	pi, err := FindFirstParentProjectInfo(si.FS, si.KnotWD, &projects, &projectsByDir)
+	if err != nil {
+		return zygo.SexpNull, nil
+	}
+	return &zygo.SexpStr{S: projectsByDir[pi.ProjectDir]}, nil
+}
+
+func (builtins *zygoBuiltins) batches(env *zygo.Zlisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+	if len(args) != 1 {
+		return zygo.SexpNull, zygo.WrongNargs
+	}
+	project, err := builtins.project(name, args[0])
+	if err != nil {
+		return zygo.SexpNull, err
+	}
+	batches, err := project.AllBatches(context.Background())
+	if err != nil {
+		return zygo.SexpNull, err
+	}
+
+	labels := make([]string, len(batches))
+	for i, batch := range batches {
+		labels[i] = batch.Label()
+	}
+	return stringsToSexp(env, labels), nil
+}
+
+func (builtins *zygoBuiltins) pages(env *zygo.Zlisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+	if len(args) != 2 {
+		return zygo.SexpNull, zygo.WrongNargs
+	}
+	batch, err := builtins.batch(name, args[0], args[1])
+	if err != nil {
+		return zygo.SexpNull, err
+	}
+	pages, err := batch.Pages(context.Background())
+	if err != nil {
+		return zygo.SexpNull, err
+	}
+
+	paths := make([]string, len(pages))
+	for i, page := range pages {
+		paths[i] = page.Path()
+	}
+	return stringsToSexp(env, paths), nil
+}
+
+// export exports a batch the way knot export does, with the
+// configuration and the export hooks of the batch
+func (builtins *zygoBuiltins) export(env *zygo.Zlisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+	if len(args) != 2 {
+		return zygo.SexpNull, zygo.WrongNargs
+	}
+	batch, err := builtins.batch(name, args[0], args[1])
+	if err != nil {
+		return zygo.SexpNull, err
+	}
+
+	output, err := batch.Export(context.Background())
+	if err != nil {
+		return zygo.SexpNull, err
+	}
+	return &zygo.SexpStr{S: output}, nil
+}
+
+func (builtins *zygoBuiltins) open(env *zygo.Zlisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+	if len(args) != 1 {
+		return zygo.SexpNull, zygo.WrongNargs
+	}
+	si, err := builtins.systemInfo(name)
+	if err != nil {
+		return zygo.SexpNull, err
+	}
+	path, err := stringFromSexp(name, args[0])
+	if err != nil {
+		return zygo.SexpNull, err
+	}
+	return zygo.SexpNull, OpenFile(si, path, true)
+}
+
+// newPage adds a page the way knot new page does, with the
+// configuration and the page create hook of the batch
+func (builtins *zygoBuiltins) newPage(env *zygo.Zlisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+	if len(args) != 2 {
+		return zygo.SexpNull, zygo.WrongNargs
+	}
+	batch, err := builtins.batch(name, args[0], args[1])
+	if err != nil {
+		return zygo.SexpNull, err
+	}
+
+	page, err := batch.NewPage(context.Background())
+	if err != nil {
+		return zygo.SexpNull, err
+	}
+	return &zygo.SexpStr{S: page.Path()}, nil
+}
+
+func stringFromSexp(name string, sexp zygo.Sexp) (string, error) {
+	str, ok := sexp.(*zygo.SexpStr)
+	if !ok {
+		return "", fmt.Errorf("%s: expected a string, got %s",
+			name, sexp.SexpString(zygo.NewPrintState()))
+	}
+	return str.S, nil
+}
+
+// labelFromSexp reads a batch label, given as a string or, for the
+// batches named by a number or a date, as an integer
+func labelFromSexp(name string, sexp zygo.Sexp) (string, error) {
+	switch sexp := sexp.(type) {
+	case *zygo.SexpStr:
+		return sexp.S, nil
+	case *zygo.SexpInt:
+		return strconv.FormatInt(sexp.Val, 10), nil
+	}
+	return "", fmt.Errorf("%s: expected a batch label, got %s",
+		name, sexp.SexpString(zygo.NewPrintState()))
+}
+
+func stringsToSexp(env *zygo.Zlisp, strs []string) zygo.Sexp {
+	result := make([]zygo.Sexp, len(strs))
+	for i, str := range strs {
+		result[i] = &zygo.SexpStr{S: str}
+	}
+	return &zygo.SexpArray{Val: result, Env: env}
+}
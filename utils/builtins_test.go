@@ -0,0 +1,59 @@
+package knot
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/glycerine/zygomys/v6/zygo"
+)
+
+func TestZygoBuiltinsInGroup(t *testing.T) {
+	si, mem := newTestSystemInfo(t)
+	w, err := NewWorkspace(si)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.InitProject(context.Background(), "/projects/notes", ProjectOptions{ContentDirName: "content"}); err != nil {
+		t.Fatal(err)
+	}
+	mustMkdirAll(t, mem, "/projects/notes/content/unit-2/notes-3")
+	mustWriteFile(t, mem, "/projects/notes/content/unit-2/knot.zy", nil)
+	mustWriteFile(t, mem, "/projects/notes/content/unit-2/notes-3/page-0.kra", testKra(t, 4, 3))
+
+	builtins := &zygoBuiltins{si: si}
+	project := &zygo.SexpStr{S: "notes"}
+	batches, err := builtins.batches(nil, "knot-batches", []zygo.Sexp{project})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var labels []string
+	for _, label := range batches.(*zygo.SexpArray).Val {
+		labels = append(labels, label.(*zygo.SexpStr).S)
+	}
+	if strings.Join(labels, " ") != "0 unit-2/3" {
+		t.Errorf("expected batches 0 and unit-2/3, got %v", labels)
+	}
+
+	page, err := builtins.newPage(nil, "knot-new-page", []zygo.Sexp{project, &zygo.SexpStr{S: "unit-2/3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path := page.(*zygo.SexpStr).S; path != "/projects/notes/content/unit-2/notes-3/page-1.kra" {
+		t.Errorf("expected a new page in the grouped batch, got <%s>", path)
+	}
+	assertExists(t, mem, "/projects/notes/content/unit-2/notes-3/page-1.kra")
+	assertNotExists(t, mem, "/projects/notes/content/notes-3")
+}
+
+func TestRunCommandNotDefined(t *testing.T) {
+	si := &SystemInfo{ConfigInfo: LoadLayeredConfigInfo("/config/config.zy", "/projects/notes/knot.zy")}
+	err := RunCommand(si, "weekly", nil)
+	if !errors.Is(err, ErrUsage) {
+		t.Fatalf("expected a usage error, got %v", err)
+	}
+	if !strings.HasSuffix(err.Error(), "defined in </config/config.zy>, </projects/notes/knot.zy>") {
+		t.Errorf("expected the error to name both config files, got %q", err)
+	}
+}
@@ -0,0 +1,652 @@
+package knot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/glycerine/zygomys/v6/zygo"
+)
+
+const (
+	SourceDefault     = "default"
+	SourceEnvironment = "environment"
+)
+
+// ConfigError describes a problem with a setting, along with where
+// the setting was made
+type ConfigError struct {
+	File    string
+	Line    int
+	Setting string
+	Err     error
+}
+
+func (err *ConfigError) Error() string {
+	location := err.File
+	if err.Line > 0 {
+		location = fmt.Sprintf("%s:%d", err.File, err.Line)
+	}
+	if err.Setting == "" {
+		return fmt.Sprintf("%s: %v", location, err.Err)
+	}
+	return fmt.Sprintf("%s: %s: %v", location, err.Setting, err.Err)
+}
+
+func (err *ConfigError) Is(target error) bool {
+	return target == ErrConfigInvalid
+}
+
+func (err *ConfigError) Unwrap() error {
+	return err.Err
+}
+
+// ConfigSetting describes a setting and the environment variable that
+// overrides it
+type ConfigSetting struct {
+	Name string
+	Env  string
+	Min  int
+	Max  int
+}
+
+var ConfigSettings = []ConfigSetting{
+	{Name: "PDFReader", Env: "KNOT_PDF_READER"},
+	{Name: "FileExplorer", Env: "KNOT_FILE_EXPLORER"},
+	{Name: "KritaCommand", Env: "KNOT_KRITA_COMMAND"},
+	{Name: "DefaultViewer", Env: "KNOT_DEFAULT_VIEWER"},
+	{Name: "PDFRasterizer", Env: "KNOT_PDF_RASTERIZER"},
+	{Name: "ExportQuality", Env: "KNOT_EXPORT_QUALITY", Min: 1, Max: 100},
+	{Name: "SiteImageWidth", Env: "KNOT_SITE_IMAGE_WIDTH", Min: 1, Max: 1 << 16},
+	{Name: "HistoryKeep", Env: "KNOT_HISTORY_KEEP", Min: 0, Max: 1 << 16},
+	{Name: "HistoryDays", Env: "KNOT_HISTORY_DAYS", Min: 0, Max: 36500},
+	{Name: "HistoryInterval", Env: "KNOT_HISTORY_INTERVAL", Min: 1, Max: 24 * 60},
+	{Name: "StampMarks", Env: "KNOT_STAMP_MARKS"},
+	{Name: "FatalHooks", Env: "KNOT_FATAL_HOOKS"}}
+
+func GetConfigSetting(name string) ConfigSetting {
+	for _, setting := range ConfigSettings {
+		if setting.Name == name {
+			return setting
+		}
+	}
+	return ConfigSetting{Name: name}
+}
+
+func isKnownSetting(name string) bool {
+	for _, setting := range ConfigSettings {
+		if setting.Name == name {
+			return true
+		}
+	}
+	for _, hook := range HookNames {
+		if hook == name {
+			return true
+		}
+	}
+	return false
+}
+
+var zygoDefinitionRegexp = regexp.MustCompile(
+	`\(\s*(set|def|defn)\s+([^\s()\[\]"]+)`)
+
+// scanZygoDefinitions finds the line where each global of a zygo file
+// is first defined
+func scanZygoDefinitions(file string) (map[string]int, map[string]bool, error) {
+	lines := make(map[string]int)
+	variables := make(map[string]bool)
+
+	source, err := os.Open(file)
+	if err != nil {
+		return lines, variables, err
+	}
+	defer source.Close()
+
+	scanner := bufio.NewScanner(source)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		for _, match := range zygoDefinitionRegexp.FindAllStringSubmatch(scanner.Text(), -1) {
+			if _, ok := lines[match[2]]; !ok {
+				lines[match[2]] = lineNumber
+			}
+			if match[1] != "defn" {
+				variables[match[2]] = true
+			}
+		}
+	}
+	return lines, variables, scanner.Err()
+}
+
+// zygoLayer reads the settings of one zygo config file into a
+// ConfigInfo, recording their source and any invalid values
+type zygoLayer struct {
+	ci      *ConfigInfo
+	zygoEnv *zygo.Zlisp
+	file    string
+	lines   map[string]int
+}
+
+func (layer *zygoLayer) source(name string) string {
+	return fmt.Sprintf("%s:%d", layer.file, layer.lines[name])
+}
+
+// find looks up a setting, but only if this layer's file defines it,
+// so that values inherited from earlier layers aren't claimed by it
+func (layer *zygoLayer) find(name string) (zygo.Sexp, bool) {
+	if _, defined := layer.lines[name]; !defined {
+		return nil, false
+	}
+	return layer.zygoEnv.FindObject(name)
+}
+
+func (layer *zygoLayer) fail(name string, err error) {
+	layer.ci.ConfigErrors = append(layer.ci.ConfigErrors, &ConfigError{
+		File: layer.file, Line: layer.lines[name], Setting: name, Err: err})
+}
+
+func (layer *zygoLayer) runner(name string, target *CommandRunner) {
+	sexp, found := layer.find(name)
+	if !found {
+		return
+	}
+
+	runner, err := CommandRunnerFromSexp(layer.zygoEnv, sexp)
+	if err != nil {
+		layer.fail(name, err)
+		return
+	}
+	*target = runner
+	layer.ci.Sources[name] = layer.source(name)
+}
+
+func (layer *zygoLayer) int(name string, target *int) {
+	sexp, found := layer.find(name)
+	if !found {
+		return
+	}
+
+	value, ok := sexp.(*zygo.SexpInt)
+	if !ok {
+		layer.fail(name, fmt.Errorf("expected an integer, got %s",
+			sexp.SexpString(zygo.NewPrintState())))
+		return
+	}
+	if err := checkRange(name, int(value.Val)); err != nil {
+		layer.fail(name, err)
+		return
+	}
+	*target = int(value.Val)
+	layer.ci.Sources[name] = layer.source(name)
+}
+
+func (layer *zygoLayer) bool(name string, target *bool) {
+	sexp, found := layer.find(name)
+	if !found {
+		return
+	}
+
+	value, ok := sexp.(*zygo.SexpBool)
+	if !ok {
+		layer.fail(name, fmt.Errorf("expected true or false, got %s",
+			sexp.SexpString(zygo.NewPrintState())))
+		return
+	}
+	*target = value.Val
+	layer.ci.Sources[name] = layer.source(name)
+}
+
+func (layer *zygoLayer) hooks() {
+	for _, name := range HookNames {
+		var hook CommandRunner
+		layer.runner(name, &hook)
+		if hook != nil {
+			layer.ci.Hooks[name] = hook
+		}
+	}
+}
+
+func checkRange(name string, value int) error {
+	setting := GetConfigSetting(name)
+	if setting.Min == setting.Max {
+		return nil
+	}
+	if value < setting.Min || value > setting.Max {
+		return fmt.Errorf("%d is out of range, must be between %d and %d",
+			value, setting.Min, setting.Max)
+	}
+	return nil
+}
+
+// loadZygoLayer runs a zygo config file and applies the settings it
+// defines on top of ci. A missing file is not an error
+func loadZygoLayer(ci *ConfigInfo, zygoEnv *zygo.Zlisp, file string) {
+	lines, variables, err := scanZygoDefinitions(file)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		ci.ConfigErrors = append(ci.ConfigErrors, &ConfigError{File: file, Err: err})
+		return
+	}
+
+	ci.loadingFile = file
+	defer func() { ci.loadingFile = "" }()
+
+	sexps, err := zygoEnv.ParseFile(file)
+	if err == nil {
+		err = zygoEnv.LoadExpressions(sexps)
+	}
+	if err == nil {
+		_, err = zygoEnv.Run()
+	}
+	if err != nil {
+		ci.ConfigErrors = append(ci.ConfigErrors, &ConfigError{File: file, Err: err})
+		return
+	}
+
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !isKnownSetting(name) {
+			ci.ConfigWarnings = append(ci.ConfigWarnings, fmt.Sprintf(
+				"%s:%d: unknown setting %s", file, lines[name], name))
+		}
+	}
+
+	layer := zygoLayer{ci: ci, zygoEnv: zygoEnv, file: file, lines: lines}
+	layer.runner("PDFReader", &ci.PDFReader)
+	layer.runner("FileExplorer", &ci.FileExplorer)
+	layer.runner("KritaCommand", &ci.KritaCommand)
+	layer.runner("DefaultViewer", &ci.DefaultViewer)
+	layer.runner("PDFRasterizer", &ci.PDFRasterizer)
+	layer.int("ExportQuality", &ci.ExportQuality)
+	layer.int("SiteImageWidth", &ci.SiteImageWidth)
+	layer.int("HistoryKeep", &ci.HistoryKeep)
+	layer.int("HistoryDays", &ci.HistoryDays)
+	layer.int("HistoryInterval", &ci.HistoryInterval)
+	layer.bool("StampMarks", &ci.StampMarks)
+	layer.bool("FatalHooks", &ci.FatalHooks)
+	layer.hooks()
+}
+
+// loadEnvironmentLayer applies the settings overridden by environment
+// variables on top of ci
+func loadEnvironmentLayer(ci *ConfigInfo) {
+	for _, setting := range ConfigSettings {
+		value, ok := os.LookupEnv(setting.Env)
+		if !ok || strings.TrimSpace(value) == "" {
+			continue
+		}
+
+		fail := func(err error) {
+			ci.ConfigErrors = append(ci.ConfigErrors, &ConfigError{
+				File: SourceEnvironment, Setting: setting.Env, Err: err})
+		}
+
+		switch setting.Name {
+		case "PDFReader", "FileExplorer", "KritaCommand", "DefaultViewer", "PDFRasterizer":
+			fields, err := splitShellWords(value)
+			if err == nil && len(fields) == 0 {
+				err = fmt.Errorf("expected a command")
+			}
+			if err != nil {
+				fail(err)
+				continue
+			}
+			runner := NewSimpleCommandRunner(fields[0], fields[1:]...)
+			switch setting.Name {
+			case "PDFReader":
+				ci.PDFReader = runner
+			case "FileExplorer":
+				ci.FileExplorer = runner
+			case "DefaultViewer":
+				ci.DefaultViewer = runner
+			case "PDFRasterizer":
+				ci.PDFRasterizer = runner
+			default:
+				ci.KritaCommand = runner
+			}
+		case "ExportQuality", "SiteImageWidth",
+			"HistoryKeep", "HistoryDays", "HistoryInterval":
+			number, err := strconv.Atoi(value)
+			if err != nil {
+				fail(fmt.Errorf("expected an integer, got %q", value))
+				continue
+			}
+			if err = checkRange(setting.Name, number); err != nil {
+				fail(err)
+				continue
+			}
+			switch setting.Name {
+			case "ExportQuality":
+				ci.ExportQuality = number
+			case "SiteImageWidth":
+				ci.SiteImageWidth = number
+			case "HistoryKeep":
+				ci.HistoryKeep = number
+			case "HistoryDays":
+				ci.HistoryDays = number
+			default:
+				ci.HistoryInterval = number
+			}
+		case "StampMarks", "FatalHooks":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				fail(fmt.Errorf("expected true or false, got %q", value))
+				continue
+			}
+			if setting.Name == "StampMarks" {
+				ci.StampMarks = enabled
+			} else {
+				ci.FatalHooks = enabled
+			}
+		}
+		ci.Sources[setting.Name] = fmt.Sprintf(
+			"%s (%s)", SourceEnvironment, setting.Env)
+	}
+}
+
+func LoadConfigInfo(configFile string) ConfigInfo {
+	return LoadLayeredConfigInfo(configFile)
+}
+
+// LoadLayeredConfigInfo applies the config files in order over the
+// defaults, and the environment variables last
+func LoadLayeredConfigInfo(configFiles ...string) ConfigInfo {
+	var configInfo ConfigInfo
+	configInfo.PDFReader = NewSimpleCommandRunner("evince")
+	configInfo.FileExplorer = NewSimpleCommandRunner("nautilus")
+	configInfo.KritaCommand = NewSimpleCommandRunner("krita")
+	configInfo.DefaultViewer = NewSimpleCommandRunner("xdg-open")
+	configInfo.PDFRasterizer = NewSimpleCommandRunner("pdftoppm", "-png", "-r", "150")
+	configInfo.Viewers = make(map[string]CommandRunner)
+	configInfo.ExportQuality = 100
+	configInfo.SiteImageWidth = 1200
+	configInfo.HistoryKeep = 20
+	configInfo.HistoryInterval = 10
+	configInfo.Hooks = make(map[string]CommandRunner)
+	configInfo.Commands = make(map[string]*zygo.SexpFunction)
+	configInfo.Sources = make(map[string]string)
+	for _, setting := range ConfigSettings {
+		configInfo.Sources[setting.Name] = SourceDefault
+	}
+
+	configInfo.ConfigFiles = configFiles
+
+	zygoEnv := zygo.NewZlisp()
+	configInfo.ZygoEnv = zygoEnv
+	AddZygoBuiltins(zygoEnv, &configInfo)
+
+	for _, configFile := range configFiles {
+		loadZygoLayer(&configInfo, zygoEnv, configFile)
+	}
+	loadEnvironmentLayer(&configInfo)
+
+	return configInfo
+}
+
+// splitShellWords splits a command line with shell quoting, but no
+// expansions
+func splitShellWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			if quote == '"' && !strings.ContainsRune("\\\"$`", r) {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if escaped || quote != 0 {
+		return nil, fmt.Errorf("unterminated quote or escape in %q", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+func GetProjectConfigFile(pi *ProjectInfo) string {
+	return filepath.Join(pi.ProjectDir, "knot.zy")
+}
+
+func GetBatchConfigFile(pi *ProjectInfo, batchNumber int) string {
+	return filepath.Join(GetBatchDir(pi, batchNumber), "knot.zy")
+}
+
+// GetGroupConfigFile returns the knot.zy that marks the directory of a
+// group as one
+func GetGroupConfigFile(pi *ProjectInfo) string {
+	return filepath.Join(pi.ContentDir, "knot.zy")
+}
+
+// ResolveConfigInfo layers the files of resolvedConfigFiles over each
+// other, with the knot functions in zygo bound to si
+func ResolveConfigInfo(si *SystemInfo, pi *ProjectInfo, batchNumber int) ConfigInfo {
+	return si.configs.load(si, resolvedConfigFiles(si, pi, batchNumber))
+}
+
+// resolvedConfigFiles returns config.zy followed by the knot.zy of the
+// project, of its groups from the outside in and, unless batchNumber is
+// negative, of the batch
+func resolvedConfigFiles(si *SystemInfo, pi *ProjectInfo, batchNumber int) []string {
+	configFiles := []string{si.ConfigFile}
+	if pi.ProjectDir != "" {
+		configFiles = append(configFiles, GetProjectConfigFile(pi))
+		for _, group := range pi.groups() {
+			configFiles = append(configFiles, GetGroupConfigFile(&group))
+		}
+		if batchNumber >= 0 {
+			configFiles = append(configFiles, GetBatchConfigFile(pi, batchNumber))
+		}
+	}
+	return configFiles
+}
+
+// configCache keeps resolved configurations until one of their files
+// changes. Each one has its own zygo environment, bound to the
+// SystemInfo it was resolved for, and is only handed back to that one
+type configCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedConfig
+}
+
+type cachedConfig struct {
+	si       *SystemInfo
+	modTimes []time.Time
+	ci       ConfigInfo
+}
+
+func newConfigCache() *configCache {
+	return &configCache{entries: make(map[string]cachedConfig)}
+}
+
+// load returns the configuration layered from configFiles for si, see
+// LoadLayeredConfigInfo. A nil cache loads it every time
+func (cache *configCache) load(si *SystemInfo, configFiles []string) ConfigInfo {
+	if cache == nil {
+		return loadBoundConfigInfo(si, configFiles)
+	}
+
+	modTimes := make([]time.Time, len(configFiles))
+	for i, file := range configFiles {
+		if stat, err := os.Stat(file); err == nil {
+			modTimes[i] = stat.ModTime()
+		}
+	}
+	key := strings.Join(configFiles, "\x00")
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cached, ok := cache.entries[key]; ok && cached.si == si && sameTimes(cached.modTimes, modTimes) {
+		return cached.ci
+	}
+	ci := loadBoundConfigInfo(si, configFiles)
+	cache.entries[key] = cachedConfig{si: si, modTimes: modTimes, ci: ci}
+	return ci
+}
+
+// loadBoundConfigInfo loads a new configuration whose knot functions
+// in zygo act on si
+func loadBoundConfigInfo(si *SystemInfo, configFiles []string) ConfigInfo {
+	ci := LoadLayeredConfigInfo(configFiles...)
+	ci.builtins.si = si
+	return ci
+}
+
+func sameTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ResolvePathConfigInfo resolves the configuration of the project and
+// batch a file belongs to
+func ResolvePathConfigInfo(si *SystemInfo, path string) ConfigInfo {
+	pi, _, _ := findPathProject(si, path)
+	absPath, _ := filepath.Abs(path)
+	return ResolveConfigInfo(si, &pi, GetPathBatchNumber(&pi, absPath))
+}
+
+// findPathProject returns the registered project that contains path
+// and its name, if there is one
+func findPathProject(si *SystemInfo, path string) (ProjectInfo, string, bool) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return ProjectInfo{}, "", false
+	}
+
+	projects, err := GetProjects(si.FS, si.ProjectsFile)
+	if err != nil {
+		return ProjectInfo{}, "", false
+	}
+	projectsByDir := ArrangeProjectsByDir(&projects)
+	pi, err := FindFirstParentProjectInfo(si.FS, absPath, &projects, &projectsByDir)
+	if err != nil {
+		return ProjectInfo{}, "", false
+	}
+
+	return pi, projectsByDir[pi.ProjectDir], true
+}
+
+// GetPathBatchNumber returns the number of the batch that contains
+// path, or -1 if it isn't inside a batch
+func GetPathBatchNumber(pi *ProjectInfo, path string) int {
+	if pi.ContentDir == "" {
+		return -1
+	}
+	rel, err := filepath.Rel(pi.ContentDir, path)
+	if err != nil || outsideDir(rel) {
+		return -1
+	}
+
+	batchName := strings.Split(filepath.ToSlash(rel), "/")[0]
+	batchNumber, ok := ParseBatchName(pi, batchName)
+	if !ok {
+		return -1
+	}
+	return batchNumber
+}
+
+// ConfigErr returns the first error found in the configuration, if any
+func (ci *ConfigInfo) ConfigErr() error {
+	if len(ci.ConfigErrors) == 0 {
+		return nil
+	}
+	return ci.ConfigErrors[0]
+}
+
+// PrintConfig writes the effective value of every setting and where
+// it came from, followed by any problems found in the configuration
+func PrintConfig(w io.Writer, ci *ConfigInfo) error {
+	table := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	values := map[string]string{
+		"PDFReader":       fmt.Sprint(ci.PDFReader),
+		"FileExplorer":    fmt.Sprint(ci.FileExplorer),
+		"KritaCommand":    fmt.Sprint(ci.KritaCommand),
+		"DefaultViewer":   fmt.Sprint(ci.DefaultViewer),
+		"PDFRasterizer":   fmt.Sprint(ci.PDFRasterizer),
+		"ExportQuality":   fmt.Sprint(ci.ExportQuality),
+		"SiteImageWidth":  fmt.Sprint(ci.SiteImageWidth),
+		"HistoryKeep":     fmt.Sprint(ci.HistoryKeep),
+		"HistoryDays":     fmt.Sprint(ci.HistoryDays),
+		"HistoryInterval": fmt.Sprint(ci.HistoryInterval),
+		"StampMarks":      fmt.Sprint(ci.StampMarks),
+		"FatalHooks":      fmt.Sprint(ci.FatalHooks)}
+
+	fmt.Fprintln(table, "setting\tvalue\tsource")
+	for _, setting := range ConfigSettings {
+		fmt.Fprintf(table, "%s\t%s\t%s\n",
+			setting.Name, values[setting.Name], ci.Sources[setting.Name])
+	}
+	for _, name := range HookNames {
+		if hook, ok := ci.Hooks[name]; ok {
+			fmt.Fprintf(table, "%s\t%v\t%s\n", name, hook, ci.Sources[name])
+		}
+	}
+	viewerKeys := make([]string, 0, len(ci.Viewers))
+	for key := range ci.Viewers {
+		viewerKeys = append(viewerKeys, key)
+	}
+	sort.Strings(viewerKeys)
+	for _, key := range viewerKeys {
+		name := fmt.Sprintf("viewer %s", key)
+		fmt.Fprintf(table, "%s\t%v\t%s\n", name, ci.Viewers[key], ci.Sources[name])
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	for _, warning := range ci.ConfigWarnings {
+		fmt.Fprintf(w, "warning: %s\n", warning)
+	}
+	for _, err := range ci.ConfigErrors {
+		fmt.Fprintf(w, "error: %v\n", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package knot
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigCache(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.zy")
+	if err := os.WriteFile(configFile, []byte("(set ExportQuality 50)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache := newConfigCache()
+	files := []string{configFile, filepath.Join(t.TempDir(), "knot.zy")}
+	si, other := &SystemInfo{}, &SystemInfo{}
+
+	first := cache.load(si, files)
+	if again := cache.load(si, files); again.builtins != first.builtins {
+		t.Error("expected the configuration to be loaded only once")
+	}
+
+	// another SystemInfo gets its own zygo environment, and leaves the
+	// one of the first alone
+	if own := cache.load(other, files); own.builtins == first.builtins || own.builtins.si != other {
+		t.Error("expected another SystemInfo to get its own configuration")
+	}
+	if first.builtins.si != si {
+		t.Error("expected the first configuration to stay bound to its SystemInfo")
+	}
+
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(configFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if changed := cache.load(si, files); changed.builtins == first.builtins {
+		t.Error("expected a changed config file to be loaded again")
+	}
+}
+
+func TestEnvironmentCommand(t *testing.T) {
+	t.Setenv("KNOT_KRITA_COMMAND", `flatpak run org.kde.krita --workspace="Canvas Only" it\'s`)
+	ci := LoadLayeredConfigInfo()
+	runner, ok := ci.KritaCommand.(*SimpleCommandRunner)
+	if !ok {
+		t.Fatalf("expected a simple command, got %T", ci.KritaCommand)
+	}
+	args := runner.arguments([]string{"page-1.kra"})
+	expected := []string{"run", "org.kde.krita", "--workspace=Canvas Only", "it's", "page-1.kra"}
+	if runner.commandName != "flatpak" || strings.Join(args, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected flatpak %q, got %s %q", expected, runner.commandName, args)
+	}
+
+	t.Setenv("KNOT_KRITA_COMMAND", `krita "unterminated`)
+	if ci = LoadLayeredConfigInfo(); len(ci.ConfigErrors) != 1 {
+		t.Errorf("expected an unterminated quote to be an error, got %v", ci.ConfigErrors)
+	}
+}
+
+func TestResolvedConfigFilesInGroup(t *testing.T) {
+	si, _ := newTestSystemInfo(t)
+	si.ConfigFile = "/config/config.zy"
+	pi := testProjectInfo("notes")
+	part := pi.InGroup("unit-2/part-1")
+
+	expected := []string{
+		"/config/config.zy",
+		"/projects/notes/knot.zy",
+		"/projects/notes/content/unit-2/knot.zy",
+		"/projects/notes/content/unit-2/part-1/knot.zy",
+		"/projects/notes/content/unit-2/part-1/notes-3/knot.zy"}
+	if files := resolvedConfigFiles(si, &part, 3); strings.Join(files, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected %v, got %v", expected, files)
+	}
+}
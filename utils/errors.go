@@ -0,0 +1,164 @@
+package knot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// the kinds of errors knot reports, usually wrapped in an Error
+var (
+	ErrUsage            = errors.New("usage")
+	ErrNotInProject     = errors.New("not in a project")
+	ErrProjectNotFound  = errors.New("no such project in project list")
+	ErrBatchExists      = errors.New("batch already exists")
+	ErrBatchNotFound    = errors.New("batch doesn't exist")
+	ErrPageExists       = errors.New("page already exists")
+	ErrTemplateNotFound = errors.New("template doesn't exist")
+	ErrExportFailed     = errors.New("export failed")
+	ErrConfigInvalid    = errors.New("invalid configuration")
+	ErrGitFailed        = errors.New("git failed")
+	ErrVersionNotFound  = errors.New("version doesn't exist")
+	ErrProjectExists    = errors.New("project already exists")
+	ErrPackInvalid      = errors.New("invalid knotpack")
+	ErrBackupInvalid    = errors.New("invalid backup")
+	ErrPageNotFound     = errors.New("page doesn't exist")
+	ErrImportFailed     = errors.New("import failed")
+)
+
+// Error is one of the kinds of errors above, along with the project
+// and path it concerns and the error that caused it, where they apply
+type Error struct {
+	Kind    error
+	Project string
+	Path    string
+	Cause   error
+}
+
+func (err *Error) Error() string {
+	parts := []string{err.Kind.Error()}
+	if err.Project != "" {
+		parts = append(parts, fmt.Sprintf("project <%s>", err.Project))
+	}
+	if err.Path != "" {
+		parts = append(parts, fmt.Sprintf("<%s>", err.Path))
+	}
+	if err.Cause != nil {
+		parts = append(parts, err.Cause.Error())
+	}
+	return strings.Join(parts, ": ")
+}
+
+func (err *Error) Is(target error) bool {
+	return target == err.Kind
+}
+
+func (err *Error) Unwrap() error {
+	return err.Cause
+}
+
+// UsageError reports a command used the wrong way
+func UsageError(format string, args ...any) error {
+	return &Error{Kind: ErrUsage, Cause: fmt.Errorf(format, args...)}
+}
+
+// exit codes of the knot command. Errors that aren't of any known kind
+// exit with ExitFailure
+const (
+	ExitFailure          = 1
+	ExitUsage            = 2
+	ExitNotInProject     = 3
+	ExitProjectNotFound  = 4
+	ExitBatchExists      = 5
+	ExitBatchNotFound    = 6
+	ExitPageExists       = 7
+	ExitTemplateNotFound = 8
+	ExitExportFailed     = 9
+	ExitConfigInvalid    = 10
+	ExitGitFailed        = 11
+	ExitVersionNotFound  = 12
+	ExitProjectExists    = 13
+	ExitPackInvalid      = 14
+	ExitBackupInvalid    = 15
+	ExitPageNotFound     = 16
+	ExitImportFailed     = 17
+)
+
+var errorKinds = []struct {
+	kind error
+	name string
+	code int
+}{
+	{ErrUsage, "usage", ExitUsage},
+	{ErrNotInProject, "not_in_project", ExitNotInProject},
+	{ErrProjectNotFound, "project_not_found", ExitProjectNotFound},
+	{ErrBatchExists, "batch_exists", ExitBatchExists},
+	{ErrBatchNotFound, "batch_not_found", ExitBatchNotFound},
+	{ErrPageExists, "page_exists", ExitPageExists},
+	{ErrTemplateNotFound, "template_not_found", ExitTemplateNotFound},
+	{ErrExportFailed, "export_failed", ExitExportFailed},
+	{ErrConfigInvalid, "config_invalid", ExitConfigInvalid},
+	{ErrGitFailed, "git_failed", ExitGitFailed},
+	{ErrVersionNotFound, "version_not_found", ExitVersionNotFound},
+	{ErrProjectExists, "project_exists", ExitProjectExists},
+	{ErrPackInvalid, "pack_invalid", ExitPackInvalid},
+	{ErrBackupInvalid, "backup_invalid", ExitBackupInvalid},
+	{ErrPageNotFound, "page_not_found", ExitPageNotFound},
+	{ErrImportFailed, "import_failed", ExitImportFailed}}
+
+// ExitCode returns the exit code for an error
+func ExitCode(err error) int {
+	for _, errorKind := range errorKinds {
+		if errors.Is(err, errorKind.kind) {
+			return errorKind.code
+		}
+	}
+	return ExitFailure
+}
+
+// JSONError is how an error is reported on stderr with -json-errors
+type JSONError struct {
+	Error   string `json:"error"`
+	Kind    string `json:"kind"`
+	Code    int    `json:"code"`
+	Project string `json:"project,omitempty"`
+	Path    string `json:"path,omitempty"`
+}
+
+// NewJSONError describes err for scripts. Its kind is "failure" if it
+// isn't one of the known kinds
+func NewJSONError(err error) JSONError {
+	result := JSONError{Error: err.Error(), Kind: "failure", Code: ExitFailure}
+	for _, errorKind := range errorKinds {
+		if errors.Is(err, errorKind.kind) {
+			result.Kind, result.Code = errorKind.name, errorKind.code
+			break
+		}
+	}
+
+	var knotErr *Error
+	var configErr *ConfigError
+	if errors.As(err, &knotErr) {
+		result.Project, result.Path = knotErr.Project, knotErr.Path
+	} else if errors.As(err, &configErr) {
+		result.Path = configErr.File
+	}
+	return result
+}
+
+// ReportError writes err to w, either as a line of text or as a JSON
+// object
+func ReportError(w io.Writer, err error, asJSON bool) {
+	if !asJSON {
+		fmt.Fprintf(w, "knot: %v\n", err)
+		return
+	}
+	errBytes, errMarshal := json.Marshal(NewJSONError(err))
+	if errMarshal != nil {
+		fmt.Fprintf(w, "knot: %v\n", err)
+		return
+	}
+	fmt.Fprintf(w, "%s\n", errBytes)
+}
@@ -0,0 +1,46 @@
+package knot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{UsageError("knot complete kind"), ExitUsage},
+		{&Error{Kind: ErrBatchExists, Path: "/projects/notes/content/notes-1"}, ExitBatchExists},
+		{fmt.Errorf("export: %w", &Error{Kind: ErrExportFailed}), ExitExportFailed},
+		{&ConfigError{File: "/config/config.zy", Err: errors.New("bad")}, ExitConfigInvalid},
+		{errors.New("something else"), ExitFailure}}
+
+	for _, c := range cases {
+		if code := ExitCode(c.err); code != c.code {
+			t.Errorf("<%v>: expected exit code %d, got %d", c.err, c.code, code)
+		}
+	}
+}
+
+func TestReportErrorAsJSON(t *testing.T) {
+	var out bytes.Buffer
+	ReportError(&out, &Error{
+		Kind: ErrProjectNotFound, Project: "notes"}, true)
+
+	var reported JSONError
+	if err := json.Unmarshal(out.Bytes(), &reported); err != nil {
+		t.Fatal(err)
+	}
+	expected := JSONError{
+		Error:   "no such project in project list: project <notes>",
+		Kind:    "project_not_found",
+		Code:    ExitProjectNotFound,
+		Project: "notes"}
+	if reported != expected {
+		t.Errorf("expected %+v, got %+v", expected, reported)
+	}
+}
@@ -1,11 +1,16 @@
 import argparse
-from PIL import Image
+import io
+from PIL import Image, PdfParser
 
 
 parser = argparse.ArgumentParser()
 
 parser.add_argument('-o')
 parser.add_argument('-q')
+parser.add_argument('--bookmark', action='append', default=[])
+parser.add_argument('--outline', action='append', default=[])
+parser.add_argument('--mark', action='append', type=int, default=[])
+parser.add_argument('--quality', action='append', default=[])
 parser.add_argument('images', type=str, nargs='+')
 
 args = parser.parse_args()
@@ -24,13 +29,142 @@ def convert_to_rgb(img_rgba):
         return img_rgba
 
 
+def encode_jpeg(img, quality):
+    if img.mode not in ('RGB', 'L'):
+        img = img.convert('RGB')
+    buffer = io.BytesIO()
+    img.save(buffer, 'JPEG', quality = quality)
+    buffer.seek(0)
+    return Image.open(buffer)
+
+
+def add_markers(pdf, pages):
+    # a small triangle over the top right corner of each page, as an
+    # annotation so the page itself is left untouched. pages maps the
+    # reference of each page to its dictionary
+    for page_ref, page in pages.items():
+        _, _, width, height = (float(value) for value in page[b'MediaBox'])
+        size = max(12, width / 40)
+        rect = [width - size, height - size, width, height]
+        vertices = [width - size, height, width, height, width, height - size]
+
+        appearance_ref = pdf.next_object_id(0)
+        pdf.write_obj(
+            appearance_ref, Type = PdfParser.PdfName('XObject'),
+            Subtype = PdfParser.PdfName('Form'), BBox = rect,
+            stream = b'0.9 0.35 0.16 rg %f %f m %f %f l %f %f l h f' % tuple(vertices)
+        )
+        marker_ref = pdf.next_object_id(0)
+        pdf.write_obj(
+            marker_ref, Type = PdfParser.PdfName('Annot'),
+            Subtype = PdfParser.PdfName('Polygon'), Rect = rect, Vertices = vertices,
+            IC = [0.9, 0.35, 0.16], C = [0.9, 0.35, 0.16], F = 4,
+            AP = {'N': appearance_ref}
+        )
+
+        page = dict(page)
+        page[b'Annots'] = list(page.get(b'Annots', [])) + [marker_ref]
+        pdf.write_obj(page_ref, page)
+
+
+def add_outline(pdf, entries):
+    # the catalog is redefined to point to the outline. Entries are given
+    # as (depth, page, title) in order, and each is nested under the
+    # last entry before it that is less deep
+    outline_ref = pdf.next_object_id(0)
+    item_refs = [pdf.next_object_id(0) for _ in entries]
+
+    children = {None: []}
+    stack = []
+    parents = []
+    for i, (depth, _, _) in enumerate(entries):
+        del stack[depth:]
+        parent = stack[-1] if stack else None
+        parents.append(parent)
+        children.setdefault(parent, []).append(i)
+        children[i] = []
+        stack.append(i)
+
+    # every item is open, so it counts all the items below it
+    descendants = [0] * len(entries)
+    for i in reversed(range(len(entries))):
+        descendants[i] = sum(1 + descendants[child] for child in children[i])
+
+    for i, (_, page, title) in enumerate(entries):
+        parent = parents[i]
+        siblings = children[parent]
+        position = siblings.index(i)
+        item = {
+            'Title': PdfParser.encode_text(title),
+            'Parent': outline_ref if parent is None else item_refs[parent],
+            'Dest': [pdf.pages[page], PdfParser.PdfName('Fit')],
+        }
+        if position > 0:
+            item['Prev'] = item_refs[siblings[position - 1]]
+        if position < len(siblings) - 1:
+            item['Next'] = item_refs[siblings[position + 1]]
+        if children[i]:
+            item['First'] = item_refs[children[i][0]]
+            item['Last'] = item_refs[children[i][-1]]
+            item['Count'] = descendants[i]
+        pdf.write_obj(item_refs[i], **item)
+
+    top = children[None]
+    pdf.write_obj(
+        outline_ref, Type = PdfParser.PdfName('Outlines'),
+        First = item_refs[top[0]], Last = item_refs[top[-1]], Count = len(entries)
+    )
+
+    catalog = dict(pdf.root)
+    catalog[b'Outlines'] = outline_ref
+    catalog[b'PageMode'] = PdfParser.PdfName('UseOutlines')
+    pdf.write_obj(pdf.root_ref, catalog)
+
+
+def update_pdf(pdf_path, entries, marks):
+    # the markers and the outline are appended to the pdf as a single
+    # incremental update
+    pdf = PdfParser.PdfParser(pdf_path, mode = 'r+b')
+    try:
+        pages = {pdf.pages[index]: pdf.read_indirect(pdf.pages[index]) for index in marks}
+        pdf.start_writing()
+        add_markers(pdf, pages)
+        if entries:
+            add_outline(pdf, entries)
+        pdf.write_xref_and_trailer()
+    finally:
+        pdf.close()
+
+
 images = [
     convert_to_rgb(Image.open(image))
     for image in args.images
 ]
 
+qualities = [int(args.q)] * len(images)
+for entry in args.quality:
+    index, quality = entry.split(':', 1)
+    qualities[int(index)] = int(quality)
+
+quality = qualities[0]
+if len(set(qualities)) > 1:
+    # the pdf is saved at a single quality, so each page is encoded at
+    # its own first and keeps its quantization tables
+    images = [encode_jpeg(img, q) for img, q in zip(images, qualities)]
+    quality = 'keep'
 
 images[0].save(
-    args.o, 'PDF', optimize = True, quality = int(args.q),
+    args.o, 'PDF', optimize = True, quality = quality,
     save_all = True, append_images = images[1:]
-)
\ No newline at end of file
+)
+
+entries = []
+for bookmark in args.bookmark:
+    index, title = bookmark.split(':', 1)
+    entries.append((0, int(index), title))
+for entry in args.outline:
+    depth, index, title = entry.split(':', 2)
+    entries.append((int(depth), int(index), title))
+
+if entries or args.mark:
+    update_pdf(args.o, entries, args.mark)
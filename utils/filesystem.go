@@ -0,0 +1,302 @@
+package knot
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FileSystem is what knot reads and writes projects through. Paths are
+// the same as for the os package
+type FileSystem interface {
+	Stat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	Create(name string) (io.WriteCloser, error)
+	MkdirAll(name string, perm fs.FileMode) error
+	Rename(oldName, newName string) error
+	Remove(name string) error
+	RemoveAll(name string) error
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// OSFileSystem is the real filesystem
+type OSFileSystem struct{}
+
+func (OSFileSystem) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OSFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (OSFileSystem) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (OSFileSystem) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+
+func (OSFileSystem) Create(name string) (io.WriteCloser, error) {
+	return os.Create(name)
+}
+
+func (OSFileSystem) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+func (OSFileSystem) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+func (OSFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFileSystem) RemoveAll(name string) error {
+	return os.RemoveAll(name)
+}
+
+func (OSFileSystem) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+// MemFileSystem keeps a whole filesystem in memory. Modification times
+// never repeat
+type MemFileSystem struct {
+	entries map[string]*memEntry
+	lastMod time.Time
+}
+
+type memEntry struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func NewMemFileSystem() *MemFileSystem {
+	return &MemFileSystem{entries: make(map[string]*memEntry)}
+}
+
+var errNotDir = errors.New("not a directory")
+var errIsDir = errors.New("is a directory")
+var errDirNotEmpty = errors.New("directory not empty")
+
+func memPath(name string) string {
+	if abs, err := filepath.Abs(name); err == nil {
+		return abs
+	}
+	return filepath.Clean(name)
+}
+
+func (mem *MemFileSystem) now() time.Time {
+	now := time.Now()
+	if !now.After(mem.lastMod) {
+		now = mem.lastMod.Add(time.Nanosecond)
+	}
+	mem.lastMod = now
+	return now
+}
+
+func (mem *MemFileSystem) lookup(name string) (*memEntry, bool) {
+	if name == filepath.Dir(name) { // the root always exists
+		return &memEntry{mode: fs.ModeDir | 0755}, true
+	}
+	entry, ok := mem.entries[name]
+	return entry, ok
+}
+
+// children returns the paths of everything below dir, at any depth
+func (mem *MemFileSystem) children(dir string) []string {
+	prefix := dir + string(filepath.Separator)
+	if dir == filepath.Dir(dir) {
+		prefix = dir
+	}
+
+	result := make([]string, 0)
+	for name := range mem.entries {
+		if strings.HasPrefix(name, prefix) {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (mem *MemFileSystem) Stat(name string) (fs.FileInfo, error) {
+	name = memPath(name)
+	entry, ok := mem.lookup(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return &memFileInfo{name: filepath.Base(name), entry: entry}, nil
+}
+
+func (mem *MemFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	name = memPath(name)
+	entry, ok := mem.lookup(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if !entry.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdirent", Path: name, Err: errNotDir}
+	}
+
+	result := make([]fs.DirEntry, 0)
+	for _, child := range mem.children(name) {
+		if filepath.Dir(child) != name {
+			continue
+		}
+		result = append(result, fs.FileInfoToDirEntry(&memFileInfo{
+			name: filepath.Base(child), entry: mem.entries[child]}))
+	}
+	return result, nil
+}
+
+func (mem *MemFileSystem) ReadFile(name string) ([]byte, error) {
+	name = memPath(name)
+	entry, ok := mem.lookup(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if entry.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errIsDir}
+	}
+	return append([]byte{}, entry.data...), nil
+}
+
+func (mem *MemFileSystem) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	name = memPath(name)
+	if parent, ok := mem.lookup(filepath.Dir(name)); !ok {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	} else if !parent.mode.IsDir() {
+		return &fs.PathError{Op: "open", Path: name, Err: errNotDir}
+	}
+	if entry, ok := mem.lookup(name); ok && entry.mode.IsDir() {
+		return &fs.PathError{Op: "open", Path: name, Err: errIsDir}
+	}
+
+	mem.entries[name] = &memEntry{
+		data:    append([]byte{}, data...),
+		mode:    perm.Perm(),
+		modTime: mem.now()}
+	return nil
+}
+
+// Create returns a writer whose content is written to name once it is
+// closed
+func (mem *MemFileSystem) Create(name string) (io.WriteCloser, error) {
+	if err := mem.WriteFile(name, nil, 0644); err != nil {
+		return nil, err
+	}
+	return &memWriter{mem: mem, name: name}, nil
+}
+
+type memWriter struct {
+	bytes.Buffer
+	mem  *MemFileSystem
+	name string
+}
+
+func (w *memWriter) Close() error {
+	return w.mem.WriteFile(w.name, w.Bytes(), 0644)
+}
+
+func (mem *MemFileSystem) MkdirAll(name string, perm fs.FileMode) error {
+	name = memPath(name)
+	if entry, ok := mem.lookup(name); ok {
+		if !entry.mode.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: errNotDir}
+		}
+		return nil
+	}
+
+	if err := mem.MkdirAll(filepath.Dir(name), perm); err != nil {
+		return err
+	}
+	mem.entries[name] = &memEntry{mode: fs.ModeDir | perm.Perm(), modTime: mem.now()}
+	return nil
+}
+
+func (mem *MemFileSystem) Rename(oldName, newName string) error {
+	oldName, newName = memPath(oldName), memPath(newName)
+	entry, ok := mem.lookup(oldName)
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: fs.ErrNotExist}
+	}
+	if parent, ok := mem.lookup(filepath.Dir(newName)); !ok || !parent.mode.IsDir() {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: fs.ErrNotExist}
+	}
+	if existing, ok := mem.lookup(newName); ok {
+		if existing.mode.IsDir() != entry.mode.IsDir() {
+			return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: errIsDir}
+		}
+		if existing.mode.IsDir() && len(mem.children(newName)) > 0 {
+			return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: errDirNotEmpty}
+		}
+	}
+	if oldName == newName {
+		return nil
+	}
+
+	for _, child := range mem.children(oldName) {
+		mem.entries[newName+strings.TrimPrefix(child, oldName)] = mem.entries[child]
+		delete(mem.entries, child)
+	}
+	mem.entries[newName] = entry
+	delete(mem.entries, oldName)
+	return nil
+}
+
+func (mem *MemFileSystem) Remove(name string) error {
+	name = memPath(name)
+	if _, ok := mem.entries[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	if len(mem.children(name)) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: errDirNotEmpty}
+	}
+	delete(mem.entries, name)
+	return nil
+}
+
+func (mem *MemFileSystem) RemoveAll(name string) error {
+	name = memPath(name)
+	for _, child := range mem.children(name) {
+		delete(mem.entries, child)
+	}
+	delete(mem.entries, name)
+	return nil
+}
+
+// Chtimes sets the modification time of name. Access times aren't kept
+func (mem *MemFileSystem) Chtimes(name string, atime, mtime time.Time) error {
+	name = memPath(name)
+	entry, ok := mem.entries[name]
+	if !ok {
+		return &fs.PathError{Op: "chtimes", Path: name, Err: fs.ErrNotExist}
+	}
+	entry.modTime = mtime
+	return nil
+}
+
+type memFileInfo struct {
+	name  string
+	entry *memEntry
+}
+
+func (info *memFileInfo) Name() string       { return info.name }
+func (info *memFileInfo) Size() int64        { return int64(len(info.entry.data)) }
+func (info *memFileInfo) Mode() fs.FileMode  { return info.entry.mode }
+func (info *memFileInfo) ModTime() time.Time { return info.entry.modTime }
+func (info *memFileInfo) IsDir() bool        { return info.entry.mode.IsDir() }
+func (info *memFileInfo) Sys() any           { return nil }
@@ -0,0 +1,213 @@
+package knot
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testTemplatePath = "/config/templates/default"
+
+// newTestSystemInfo returns a SystemInfo working on an empty in-memory
+// filesystem that only holds the default template
+func newTestSystemInfo(t *testing.T) (*SystemInfo, *MemFileSystem) {
+	t.Helper()
+
+	mem := NewMemFileSystem()
+	si := &SystemInfo{
+		ConfigDir:      "/config",
+		ProjectsFile:   "/config/projects.json",
+		TemplateDir:    "/config/templates",
+		TempConfigFile: "/state/knotconfig.json",
+		SessionsDir:    "/state/sessions",
+		SessionFile:    "/state/sessions/test.json",
+		SessionKey:     "test",
+		FS:             mem,
+		Actions:        SystemActions{FS: mem}}
+
+	mustMkdirAll(t, mem, filepath.Join(testTemplatePath, "batch"))
+	mustWriteFile(t, mem, filepath.Join(testTemplatePath, "batch", "page.kra"),
+		testKra(t, 4, 3))
+	mustMkdirAll(t, mem, "/state")
+	return si, mem
+}
+
+// newTestProject returns a SystemInfo like newTestSystemInfo with the
+// project notes created in it
+func newTestProject(t *testing.T) (*SystemInfo, *MemFileSystem, ProjectInfo) {
+	t.Helper()
+
+	si, mem := newTestSystemInfo(t)
+	pi := testProjectInfo("notes")
+	if err := CreateProject(testTemplatePath, si, &pi, false); err != nil {
+		t.Fatal(err)
+	}
+	return si, mem, pi
+}
+
+func testProjectInfo(name string) ProjectInfo {
+	return ProjectInfo{
+		ProjectDir:    filepath.Join("/projects", name),
+		ContentDir:    filepath.Join("/projects", name, "content"),
+		ContentName:   name,
+		ExportDirName: "export",
+		TemplateName:  "default"}
+}
+
+// testKra returns a .kra file whose merged image is blank
+func testKra(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	var kra bytes.Buffer
+	archive := zip.NewWriter(&kra)
+	merged, err := archive.Create("mergedimage.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = merged.Write(testPNG(t, width, height)); err != nil {
+		t.Fatal(err)
+	}
+	if err = archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return kra.Bytes()
+}
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	var result bytes.Buffer
+	if err := png.Encode(&result, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return result.Bytes()
+}
+
+func mustMkdirAll(t *testing.T, fsys FileSystem, dir string) {
+	t.Helper()
+	if err := fsys.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustWriteFile(t *testing.T, fsys FileSystem, file string, data []byte) {
+	t.Helper()
+	if err := fsys.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertExists(t *testing.T, fsys FileSystem, path string) {
+	t.Helper()
+	if _, err := fsys.Stat(path); err != nil {
+		t.Errorf("expected <%s> to exist: %v", path, err)
+	}
+}
+
+func assertNotExists(t *testing.T, fsys FileSystem, path string) {
+	t.Helper()
+	if _, err := fsys.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected <%s> not to exist, got %v", path, err)
+	}
+}
+
+func TestMemFileSystemReadDir(t *testing.T) {
+	mem := NewMemFileSystem()
+	mustMkdirAll(t, mem, "/a/b/c")
+	mustWriteFile(t, mem, "/a/z.txt", []byte("z"))
+	mustWriteFile(t, mem, "/a/b/y.txt", []byte("y"))
+
+	entries, err := mem.ReadDir("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Name() != "b" || entries[1].Name() != "z.txt" {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+	if !entries[0].IsDir() || entries[1].IsDir() {
+		t.Errorf("wrong entry types %v", entries)
+	}
+
+	if _, err = mem.ReadDir("/a/z.txt"); err == nil {
+		t.Error("reading a file as a directory should fail")
+	}
+	if _, err = mem.ReadDir("/missing"); !os.IsNotExist(err) {
+		t.Errorf("expected a not exist error, got %v", err)
+	}
+}
+
+func TestMemFileSystemWriteFile(t *testing.T) {
+	mem := NewMemFileSystem()
+
+	if err := mem.WriteFile("/missing/file", nil, 0644); !os.IsNotExist(err) {
+		t.Errorf("expected a not exist error, got %v", err)
+	}
+
+	mustMkdirAll(t, mem, "/dir")
+	mustWriteFile(t, mem, "/dir/file", []byte("first"))
+	first, _ := mem.Stat("/dir/file")
+	mustWriteFile(t, mem, "/dir/file", []byte("second"))
+	second, _ := mem.Stat("/dir/file")
+
+	data, err := mem.ReadFile("/dir/file")
+	if err != nil || string(data) != "second" {
+		t.Errorf("read <%s>, %v", data, err)
+	}
+	if !second.ModTime().After(first.ModTime()) {
+		t.Error("modification times should increase with every write")
+	}
+	if err = mem.WriteFile("/dir", nil, 0644); err == nil {
+		t.Error("writing over a directory should fail")
+	}
+}
+
+func TestMemFileSystemRename(t *testing.T) {
+	mem := NewMemFileSystem()
+	mustMkdirAll(t, mem, "/old/sub")
+	mustWriteFile(t, mem, "/old/sub/file", []byte("data"))
+
+	if err := mem.Rename("/old", "/new"); err != nil {
+		t.Fatal(err)
+	}
+	assertNotExists(t, mem, "/old")
+	assertNotExists(t, mem, "/old/sub/file")
+
+	data, err := mem.ReadFile("/new/sub/file")
+	if err != nil || string(data) != "data" {
+		t.Errorf("read <%s>, %v", data, err)
+	}
+
+	mustMkdirAll(t, mem, "/other")
+	mustWriteFile(t, mem, "/other/file", nil)
+	if err = mem.Rename("/new", "/other"); err == nil {
+		t.Error("renaming over a directory that isn't empty should fail")
+	}
+}
+
+func TestMemFileSystemRemove(t *testing.T) {
+	mem := NewMemFileSystem()
+	mustMkdirAll(t, mem, "/dir/sub")
+	mustWriteFile(t, mem, "/dir/sub/file", nil)
+	mustWriteFile(t, mem, "/dirfile", nil)
+
+	if err := mem.Remove("/dir"); err == nil {
+		t.Error("removing a directory that isn't empty should fail")
+	}
+	if err := mem.RemoveAll("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	assertNotExists(t, mem, "/dir")
+	assertNotExists(t, mem, "/dir/sub/file")
+	assertExists(t, mem, "/dirfile")
+
+	if err := mem.Remove("/dirfile"); err != nil {
+		t.Error(err)
+	}
+	if err := mem.Remove("/dirfile"); !os.IsNotExist(err) {
+		t.Errorf("expected a not exist error, got %v", err)
+	}
+}
@@ -0,0 +1,285 @@
+package knot
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gitRunner runs git in a project directory
+type gitRunner struct {
+	dir string
+}
+
+func projectGit(pi *ProjectInfo) gitRunner {
+	return gitRunner{dir: pi.ProjectDir}
+}
+
+// Revision is a commit that changed a page
+type Revision struct {
+	Hash    string
+	Date    time.Time
+	Message string
+}
+
+var gitPageRegexp = regexp.MustCompile(`^page-([0-9]+)\.kra$`)
+
+func (git gitRunner) runner() CommandRunner {
+	return NewSimpleCommandRunner("git", "-C", git.dir)
+}
+
+// run runs a git command that changes the repository
+func (git gitRunner) run(si *SystemInfo, args ...string) error {
+	output, err := si.Actions.Run(git.runner(), args)
+	if err != nil {
+		return gitError(git.dir, args, err, output)
+	}
+	return nil
+}
+
+// output runs a git command that only reads the repository, and
+// returns what it wrote to stdout
+func (git gitRunner) output(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", git.dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, gitError(git.dir, args, err, stderr.String())
+	}
+	return output, nil
+}
+
+func gitError(dir string, args []string, err error, output string) error {
+	err = fmt.Errorf("git %s: %w", args[0], err)
+	if output = strings.TrimSpace(output); output != "" {
+		err = fmt.Errorf("%w\n%s", err, output)
+	}
+	return &Error{Kind: ErrGitFailed, Path: dir, Cause: err}
+}
+
+// gitIgnored are the patterns InitGitRepo puts in .gitignore
+func gitIgnored(pi *ProjectInfo) []string {
+	return []string{pi.ExportDirName + "/", "*.kra~", ".knot-stage-*/", ".knot/history/"}
+}
+
+// InitGitRepo makes the project directory a git repository that
+// ignores exports and knot's own files
+func InitGitRepo(si *SystemInfo, pi *ProjectInfo) error {
+	if _, err := si.FS.Stat(filepath.Join(pi.ProjectDir, ".git")); err != nil {
+		if err = projectGit(pi).run(si, "init", "--quiet"); err != nil {
+			return err
+		}
+	}
+
+	gitignore := filepath.Join(pi.ProjectDir, ".gitignore")
+	content, _ := si.FS.ReadFile(gitignore)
+	existing := make(map[string]bool)
+	for _, line := range strings.Split(string(content), "\n") {
+		existing[strings.TrimSpace(line)] = true
+	}
+
+	var missing []string
+	for _, pattern := range gitIgnored(pi) {
+		if !existing[pattern] {
+			missing = append(missing, pattern)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if len(content) > 0 && !bytes.HasSuffix(content, []byte("\n")) {
+		content = append(content, '\n')
+	}
+	content = append(content, []byte(strings.Join(missing, "\n")+"\n")...)
+	return si.Actions.WriteFile(gitignore, content)
+}
+
+// pageChange is a page that git status reports as changed
+type pageChange struct {
+	path        string
+	group       string
+	batchNumber int
+	pageNumber  int
+	change      string
+}
+
+// changedPages returns the .kra pages of the project that differ from
+// its last commit
+func changedPages(fsys FileSystem, pi *ProjectInfo) ([]pageChange, error) {
+	output, err := projectGit(pi).output(
+		"status", "--porcelain", "-z", "--untracked-files=all", "--", pi.ContentDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []pageChange
+	entries := strings.Split(string(output), "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		status, path := entry[:2], entry[3:]
+		if status[0] == 'R' || status[0] == 'C' {
+			i++ // the path it was renamed or copied from
+		}
+
+		absPath := filepath.Join(pi.ProjectDir, filepath.FromSlash(path))
+		match := gitPageRegexp.FindStringSubmatch(filepath.Base(absPath))
+		group, batchNumber := PathBatch(fsys, pi, absPath)
+		if match == nil || batchNumber < 0 ||
+			filepath.Dir(absPath) != GetBatchDir(&group, batchNumber) {
+			continue
+		}
+		pageNumber, _ := strconv.Atoi(match[1])
+
+		change := "modified"
+		switch {
+		case strings.Contains(status, "?") || strings.Contains(status, "A"):
+			change = "added"
+		case strings.Contains(status, "D"):
+			change = "deleted"
+		}
+		result = append(result, pageChange{path: path, group: group.Group,
+			batchNumber: batchNumber, pageNumber: pageNumber, change: change})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].group != result[j].group {
+			return naturalLess(result[i].group, result[j].group)
+		}
+		if result[i].batchNumber != result[j].batchNumber {
+			return result[i].batchNumber < result[j].batchNumber
+		}
+		return result[i].pageNumber < result[j].pageNumber
+	})
+	return result, nil
+}
+
+// snapshotMessage describes changes like "notes-4: pages 2,3 modified",
+// with one such part per batch, whose group prefixes it if it has one
+func snapshotMessage(pi *ProjectInfo, changes []pageChange) string {
+	var batches []string
+	for i := 0; i < len(changes); {
+		group, batchNumber := changes[i].group, changes[i].batchNumber
+		var kinds []string
+		pagesByKind := make(map[string][]string)
+		for ; i < len(changes) && changes[i].group == group && changes[i].batchNumber == batchNumber; i++ {
+			change := changes[i].change
+			if _, ok := pagesByKind[change]; !ok {
+				kinds = append(kinds, change)
+			}
+			pagesByKind[change] = append(
+				pagesByKind[change], strconv.Itoa(changes[i].pageNumber))
+		}
+
+		var parts []string
+		for _, kind := range kinds {
+			pages := pagesByKind[kind]
+			noun := "page"
+			if len(pages) > 1 {
+				noun = "pages"
+			}
+			parts = append(parts, fmt.Sprintf("%s %s %s", noun, strings.Join(pages, ","), kind))
+		}
+		info := pi.InGroup(group)
+		batch := path.Join(group, GetBatchName(&info, batchNumber))
+		batches = append(batches, fmt.Sprintf("%s: %s", batch, strings.Join(parts, ", ")))
+	}
+	return strings.Join(batches, "; ")
+}
+
+// Snapshot commits the changed pages of the project and returns the
+// commit message, which is empty if nothing changed
+func Snapshot(si *SystemInfo, pi *ProjectInfo) (string, error) {
+	changes, err := changedPages(si.FS, pi)
+	if err != nil || len(changes) == 0 {
+		return "", err
+	}
+
+	paths := make([]string, len(changes))
+	for i, change := range changes {
+		paths[i] = change.path
+	}
+	message := snapshotMessage(pi, changes)
+
+	if err = projectGit(pi).run(si, append([]string{"add", "--all", "--"}, paths...)...); err != nil {
+		return "", err
+	}
+	commit := append([]string{"commit", "--quiet", "-m", message, "--"}, paths...)
+	if err = projectGit(pi).run(si, commit...); err != nil {
+		return "", err
+	}
+	return message, nil
+}
+
+// relativePage returns the path of a page relative to the repository
+func relativePage(pi *ProjectInfo, page string) (string, error) {
+	rel, err := filepath.Rel(pi.ProjectDir, page)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// PageHistory returns the commits that changed a page, latest first
+func PageHistory(pi *ProjectInfo, page string) ([]Revision, error) {
+	rel, err := relativePage(pi, page)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := projectGit(pi).output(
+		"log", "--format=%H%x09%aI%x09%s", "--follow", "--", rel)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Revision
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		date, _ := time.Parse(time.RFC3339, fields[1])
+		result = append(result, Revision{
+			Hash: fields[0], Date: date, Message: fields[2]})
+	}
+	return result, nil
+}
+
+// RestorePage adds the version of a page from a revision to its batch
+// as a new page, and returns its path and number
+func RestorePage(si *SystemInfo, pi *ProjectInfo, page string, revision string) (string, int, error) {
+	batchNumber := GetPathBatchNumber(pi, page)
+	if batchNumber < 0 {
+		return "", 0, &Error{Kind: ErrBatchNotFound, Path: page}
+	}
+
+	rel, err := relativePage(pi, page)
+	if err != nil {
+		return "", 0, err
+	}
+	content, err := projectGit(pi).output("show", fmt.Sprintf("%s:%s", revision, rel))
+	if err != nil {
+		return "", 0, err
+	}
+
+	newPage, pageNumber, err := addPage(si, pi, batchNumber, func(staged string) error {
+		return si.Actions.WriteFile(staged, content)
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	snapshotCreated(si, pi, "restored from "+revision, newPage)
+	return newPage, pageNumber, nil
+}
@@ -0,0 +1,110 @@
+package knot
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotMessage(t *testing.T) {
+	changes := []pageChange{
+		{batchNumber: 4, pageNumber: 2, change: "modified"},
+		{batchNumber: 4, pageNumber: 3, change: "modified"},
+		{batchNumber: 4, pageNumber: 5, change: "added"},
+		{batchNumber: 6, pageNumber: 0, change: "deleted"},
+		{group: "unit-2", batchNumber: 6, pageNumber: 1, change: "added"}}
+
+	pi := testProjectInfo("notes")
+	expected := "notes-4: pages 2,3 modified, page 5 added; notes-6: page 0 deleted; unit-2/notes-6: page 1 added"
+	if message := snapshotMessage(&pi, changes); message != expected {
+		t.Errorf("expected <%s>, got <%s>", expected, message)
+	}
+
+	pi.BatchNaming = BatchDated
+	dated := []pageChange{{batchNumber: 20261018, pageNumber: 0, change: "added"}}
+	if message := snapshotMessage(&pi, dated); message != "notes-2026-10-18: page 0 added" {
+		t.Errorf("expected the dated name of the batch, got <%s>", message)
+	}
+}
+
+func TestGitSnapshotAndRestore(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+	for _, name := range []string{"GIT_AUTHOR", "GIT_COMMITTER"} {
+		t.Setenv(name+"_NAME", "knot")
+		t.Setenv(name+"_EMAIL", "knot@example.com")
+	}
+
+	fsys := OSFileSystem{}
+	si := &SystemInfo{FS: fsys, Actions: SystemActions{FS: fsys}}
+	pi := NewProjectInfo(filepath.Join(t.TempDir(), "notes"), &ProjectOptions{})
+	batchDir := GetBatchDir(&pi, 0)
+	page := filepath.Join(batchDir, GetPageName(0))
+	if err := os.MkdirAll(filepath.Join(batchDir, pi.ExportDirName), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(page, []byte("first"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(batchDir, pi.ExportDirName, "page-0.png"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// only a directory marked with a knot.zy is a group
+	unit := pi.InGroup("unit-2")
+	for _, group := range []string{"unit-2", "scratch"} {
+		info := pi.InGroup(group)
+		groupPage := filepath.Join(GetBatchDir(&info, 0), GetPageName(0))
+		if err := os.MkdirAll(filepath.Dir(groupPage), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(groupPage, []byte("first"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(GetGroupConfigFile(&unit), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InitGitRepo(si, &pi); err != nil {
+		t.Fatal(err)
+	}
+	message, err := Snapshot(si, &pi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message != "notes-0: page 0 added; unit-2/notes-0: page 0 added" {
+		t.Errorf("unexpected first snapshot <%s>", message)
+	}
+
+	if err = os.WriteFile(page, []byte("second"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if message, err = Snapshot(si, &pi); err != nil || message != "notes-0: page 0 modified" {
+		t.Errorf("unexpected second snapshot <%s>, %v", message, err)
+	}
+	if message, err = Snapshot(si, &pi); err != nil || message != "" {
+		t.Errorf("nothing should be left to snapshot, got <%s>, %v", message, err)
+	}
+
+	revisions, err := PageHistory(&pi, page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 {
+		t.Fatalf("expected 2 revisions, got %v", revisions)
+	}
+
+	restored, _, err := RestorePage(si, &pi, page, revisions[1].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(restored); string(content) != "first" {
+		t.Errorf("expected the first version in <%s>, got <%s>", restored, content)
+	}
+	if content, _ := os.ReadFile(page); string(content) != "second" {
+		t.Error("restoring must not overwrite the current page")
+	}
+}
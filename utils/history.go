@@ -0,0 +1,393 @@
+package knot
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The history store keeps versions of pages in .knot/history, named
+// after their sha256 and listed in index.json
+
+// the width of each side of a version preview
+const previewWidth = 600
+
+type historyIndex struct {
+	// Pages maps the path of each page to its versions, oldest first
+	Pages map[string][]Revision
+}
+
+func historyDir(pi *ProjectInfo) string {
+	return filepath.Join(pi.ProjectDir, ".knot", "history")
+}
+
+func historyIndexFile(pi *ProjectInfo) string {
+	return filepath.Join(historyDir(pi), "index.json")
+}
+
+func historyObject(pi *ProjectInfo, hash string) string {
+	return filepath.Join(historyDir(pi), "objects", hash[:2], hash)
+}
+
+func readHistoryIndex(fsys FileSystem, pi *ProjectInfo) (historyIndex, error) {
+	index := historyIndex{Pages: make(map[string][]Revision)}
+
+	indexBytes, err := fsys.ReadFile(historyIndexFile(pi))
+	if errors.Is(err, fs.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return index, err
+	}
+	if err = json.Unmarshal(indexBytes, &index); err != nil {
+		return index, fmt.Errorf("<%s>: %w", historyIndexFile(pi), err)
+	}
+	if index.Pages == nil {
+		index.Pages = make(map[string][]Revision)
+	}
+	return index, nil
+}
+
+func (index *historyIndex) save(si *SystemInfo, pi *ProjectInfo) error {
+	indexBytes, err := json.MarshalIndent(index, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = EnsureDir(si.FS, si.Actions, historyDir(pi)); err != nil {
+		return err
+	}
+	return si.Actions.WriteFile(historyIndexFile(pi), indexBytes)
+}
+
+// prune drops the versions past the keep latest ones or older than
+// days, but never the latest, and returns how many it dropped
+func (index *historyIndex) prune(keep, days int, now time.Time) int {
+	dropped := 0
+	for page, versions := range index.Pages {
+		start := 0
+		if keep > 0 && len(versions) > keep {
+			start = len(versions) - keep
+		}
+		if days > 0 {
+			cutoff := now.AddDate(0, 0, -days)
+			for start < len(versions)-1 && versions[start].Date.Before(cutoff) {
+				start++
+			}
+		}
+		index.Pages[page] = versions[start:]
+		dropped += start
+	}
+	return dropped
+}
+
+// removeUnusedObjects removes the objects that no version refers to
+func (index *historyIndex) removeUnusedObjects(si *SystemInfo, pi *ProjectInfo) error {
+	used := make(map[string]bool)
+	for _, versions := range index.Pages {
+		for _, version := range versions {
+			used[version.Hash] = true
+		}
+	}
+
+	objectsDir := filepath.Join(historyDir(pi), "objects")
+	prefixes, err := si.FS.ReadDir(objectsDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, prefix := range prefixes {
+		objects, err := si.FS.ReadDir(filepath.Join(objectsDir, prefix.Name()))
+		if err != nil {
+			return err
+		}
+		for _, object := range objects {
+			if !used[object.Name()] {
+				err = si.Actions.RemoveAll(
+					filepath.Join(objectsDir, prefix.Name(), object.Name()))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// projectPages returns the paths of every .kra page of a project,
+// including those of its groups
+func projectPages(fsys FileSystem, pi *ProjectInfo) ([]string, error) {
+	groups, err := GetBatchGroups(fsys, pi)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	err = groups.Walk(func(group *ProjectInfo, batchNumber int) error {
+		batchDir := GetBatchDir(group, batchNumber)
+		pageNumbers, err := GetPageNumbers(fsys, batchDir, ".kra")
+		if err != nil {
+			return err
+		}
+		for _, pageNumber := range pageNumbers {
+			result = append(result, filepath.Join(batchDir, GetPageName(pageNumber)))
+		}
+		return nil
+	})
+	return result, err
+}
+
+// SnapshotPages stores the changed pages, or every page if none are
+// given, in the history store and returns those that were stored
+func SnapshotPages(si *SystemInfo, pi *ProjectInfo, reason string, pages ...string) ([]string, error) {
+	var err error
+	if len(pages) == 0 {
+		if pages, err = projectPages(si.FS, pi); err != nil {
+			return nil, err
+		}
+	}
+
+	index, err := readHistoryIndex(si.FS, pi)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var result []string
+	for _, page := range pages {
+		content, err := si.FS.ReadFile(page)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		rel, err := relativePage(pi, page)
+		if err != nil {
+			return nil, err
+		}
+
+		sum := sha256.Sum256(content)
+		hash := hex.EncodeToString(sum[:])
+		versions := index.Pages[rel]
+		if len(versions) > 0 && versions[len(versions)-1].Hash == hash {
+			continue
+		}
+
+		object := historyObject(pi, hash)
+		if _, err = si.FS.Stat(object); err != nil {
+			if err = EnsureDir(si.FS, si.Actions, filepath.Dir(object)); err != nil {
+				return nil, err
+			}
+			if err = si.Actions.WriteFile(object, content); err != nil {
+				return nil, err
+			}
+		}
+		index.Pages[rel] = append(versions, Revision{Hash: hash, Date: now, Message: reason})
+		result = append(result, page)
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+
+	index.prune(si.HistoryKeep, si.HistoryDays, now)
+	if err = index.save(si, pi); err != nil {
+		return nil, err
+	}
+	return result, index.removeUnusedObjects(si, pi)
+}
+
+// snapshotCreated stores pages knot has just made in the history store.
+// Failing to is only a warning, since the pages are already in place
+func snapshotCreated(si *SystemInfo, pi *ProjectInfo, reason string, pages ...string) {
+	if len(pages) == 0 {
+		return
+	}
+	root := pi.Root()
+	if _, err := SnapshotPages(si, &root, reason, pages...); err != nil {
+		si.Warn(err)
+	}
+}
+
+// PruneHistory applies the retention policy to the history store and
+// returns how many versions it dropped
+func PruneHistory(si *SystemInfo, pi *ProjectInfo) (int, error) {
+	index, err := readHistoryIndex(si.FS, pi)
+	if err != nil {
+		return 0, err
+	}
+
+	dropped := index.prune(si.HistoryKeep, si.HistoryDays, time.Now())
+	if dropped > 0 {
+		if err = index.save(si, pi); err != nil {
+			return 0, err
+		}
+	}
+	return dropped, index.removeUnusedObjects(si, pi)
+}
+
+// StoredPageHistory returns the versions of a page in the history
+// store, latest first
+func StoredPageHistory(fsys FileSystem, pi *ProjectInfo, page string) ([]Revision, error) {
+	index, err := readHistoryIndex(fsys, pi)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := relativePage(pi, page)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := index.Pages[rel]
+	result := make([]Revision, len(versions))
+	for i, version := range versions {
+		result[len(versions)-1-i] = version
+	}
+	return result, nil
+}
+
+// findVersion returns the version of a page whose hash starts with
+// revision
+func findVersion(fsys FileSystem, pi *ProjectInfo, page string, revision string) (Revision, error) {
+	versions, err := StoredPageHistory(fsys, pi, page)
+	if err != nil {
+		return Revision{}, err
+	}
+
+	var matches []Revision
+	for _, version := range versions {
+		if revision != "" && strings.HasPrefix(version.Hash, revision) {
+			matches = append(matches, version)
+		}
+	}
+	switch {
+	case len(matches) == 0:
+		return Revision{}, &Error{Kind: ErrVersionNotFound, Path: page,
+			Cause: fmt.Errorf("no version <%s>", revision)}
+	case len(matches) > 1 && matches[0].Hash != matches[len(matches)-1].Hash:
+		return Revision{}, &Error{Kind: ErrVersionNotFound, Path: page,
+			Cause: fmt.Errorf("<%s> matches more than one version", revision)}
+	}
+	return matches[0], nil
+}
+
+// PageVersion returns the content of a version of a page from the
+// history store, or its current content if revision is empty
+func PageVersion(fsys FileSystem, pi *ProjectInfo, page string, revision string) ([]byte, error) {
+	if revision == "" {
+		return fsys.ReadFile(page)
+	}
+	version, err := findVersion(fsys, pi, page, revision)
+	if err != nil {
+		return nil, err
+	}
+	return fsys.ReadFile(historyObject(pi, version.Hash))
+}
+
+// RestoreStoredPage replaces a page with one of its stored versions
+func RestoreStoredPage(si *SystemInfo, pi *ProjectInfo, page string, revision string) error {
+	content, err := PageVersion(si.FS, pi, page, revision)
+	if err != nil {
+		return err
+	}
+
+	if _, err = SnapshotPages(si, pi, "before restore", page); err != nil {
+		return err
+	}
+
+	return withTransaction(si, filepath.Dir(page), func(tx *transaction) error {
+		staged := tx.staged(filepath.Base(page))
+		if err := si.Actions.WriteFile(staged, content); err != nil {
+			return err
+		}
+		return si.Actions.Rename(staged, page)
+	})
+}
+
+// VersionPreview writes two versions of a page side by side and
+// returns its path
+func VersionPreview(si *SystemInfo, pi *ProjectInfo, page string, from, to string) (string, error) {
+	var images [2]image.Image
+	var names [2]string
+	for i, revision := range [2]string{from, to} {
+		content, err := PageVersion(si.FS, pi, page, revision)
+		if err != nil {
+			return "", err
+		}
+		merged, err := MergedImage(content)
+		if err != nil {
+			return "", err
+		}
+		img, err := png.Decode(bytes.NewReader(merged))
+		if err != nil {
+			return "", err
+		}
+		if img.Bounds().Dx() > previewWidth {
+			img = scaleImage(img, previewWidth)
+		}
+		images[i] = img
+
+		names[i] = "current"
+		if revision != "" {
+			version, _ := findVersion(si.FS, pi, page, revision)
+			names[i] = version.Hash[:10]
+		}
+	}
+
+	const gap = 16
+	left, right := images[0].Bounds(), images[1].Bounds()
+	height := left.Dy()
+	if right.Dy() > height {
+		height = right.Dy()
+	}
+	preview := image.NewNRGBA(image.Rect(0, 0, left.Dx()+gap+right.Dx(), height))
+	draw.Draw(preview, preview.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(preview, image.Rect(0, 0, left.Dx(), left.Dy()),
+		images[0], left.Min, draw.Over)
+	draw.Draw(preview, image.Rect(left.Dx()+gap, 0, left.Dx()+gap+right.Dx(), right.Dy()),
+		images[1], right.Min, draw.Over)
+
+	var previewBytes bytes.Buffer
+	if err := png.Encode(&previewBytes, preview); err != nil {
+		return "", err
+	}
+
+	rel, err := relativePage(pi, page)
+	if err != nil {
+		return "", err
+	}
+	previewFile := filepath.Join(historyDir(pi), "previews", fmt.Sprintf("%s-%s-%s.png",
+		strings.ReplaceAll(FileWithoutExt(rel), "/", "-"), names[0], names[1]))
+	if err = EnsureDir(si.FS, si.Actions, filepath.Dir(previewFile)); err != nil {
+		return "", err
+	}
+	return previewFile, si.Actions.WriteFile(previewFile, previewBytes.Bytes())
+}
+
+// WatchHistory snapshots the project every interval until ctx is done
+func WatchHistory(ctx context.Context, si *SystemInfo, pi *ProjectInfo, interval time.Duration, report func(pages []string, err error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		report(SnapshotPages(si, pi, "watch"))
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package knot
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotPagesAndRestore(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+	page := filepath.Join(GetBatchDir(&pi, 0), GetPageName(0))
+	original, _ := mem.ReadFile(page)
+
+	if versions, _ := StoredPageHistory(mem, &pi, page); len(versions) != 1 {
+		t.Errorf("expected a version of <%s> once it was created, got %v", page, versions)
+	}
+	if saved, _ := SnapshotPages(si, &pi, "snapshot"); len(saved) != 0 {
+		t.Errorf("an unchanged page shouldn't get a new version, got %v", saved)
+	}
+
+	mustWriteFile(t, mem, page, testKra(t, 8, 6))
+	if _, err := SnapshotPages(si, &pi, "snapshot"); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := StoredPageHistory(mem, &pi, page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("expected 2 versions, got %d", len(versions))
+	}
+
+	preview, err := VersionPreview(si, &pi, page, versions[1].Hash[:8], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertExists(t, mem, preview)
+
+	mustWriteFile(t, mem, page, testKra(t, 2, 2))
+	if err = RestoreStoredPage(si, &pi, page, versions[1].Hash[:8]); err != nil {
+		t.Fatal(err)
+	}
+	if restored, _ := mem.ReadFile(page); !bytes.Equal(restored, original) {
+		t.Error("expected the page to be back to its first version")
+	}
+	if versions, _ = StoredPageHistory(mem, &pi, page); len(versions) != 3 ||
+		versions[0].Message != "before restore" {
+		t.Errorf("expected the page to be saved before the restore, got %v", versions)
+	}
+
+	err = RestoreStoredPage(si, &pi, page, "ffffffff")
+	if !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("expected ErrVersionNotFound, got %v", err)
+	}
+	assertNoStagingLeft(t, mem, &pi)
+}
+
+func TestPruneHistory(t *testing.T) {
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	versions := func() []Revision {
+		return []Revision{
+			{Hash: "a", Date: now.AddDate(0, 0, -40)},
+			{Hash: "b", Date: now.AddDate(0, 0, -20)},
+			{Hash: "c", Date: now.AddDate(0, 0, -10)},
+			{Hash: "d", Date: now.AddDate(0, 0, -50)}}
+	}
+
+	for _, test := range []struct {
+		keep, days int
+		expected   string
+	}{{0, 0, "abcd"}, {2, 0, "cd"}, {0, 30, "bcd"}, {2, 15, "cd"}, {0, 1, "d"}} {
+		index := historyIndex{Pages: map[string][]Revision{"page": versions()}}
+		index.prune(test.keep, test.days, now)
+
+		kept := ""
+		for _, version := range index.Pages["page"] {
+			kept += version.Hash
+		}
+		if kept != test.expected {
+			t.Errorf("keep %d, days %d: expected %s, got %s",
+				test.keep, test.days, test.expected, kept)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package knot
+
+import (
+	"fmt"
+	"strings"
+)
+
+// names of the lifecycle hooks, called with the project, batch and
+// page paths
+const (
+	HookProjectInit  = "on-project-init"
+	HookBatchCreate  = "on-batch-create"
+	HookPageCreate   = "on-page-create"
+	HookBeforeExport = "before-export"
+	HookAfterExport  = "after-export"
+)
+
+var HookNames = []string{
+	HookProjectInit,
+	HookBatchCreate,
+	HookPageCreate,
+	HookBeforeExport,
+	HookAfterExport}
+
+// RunHook calls a hook if it is defined. Its errors are warnings
+// unless FatalHooks is set
+func RunHook(si *SystemInfo, name string, projectPath, batchPath, pagePath string) error {
+	hook, ok := si.Hooks[name]
+	if !ok {
+		return nil
+	}
+
+	output, err := si.Actions.Run(hook, []string{projectPath, batchPath, pagePath})
+	if err == nil {
+		return nil
+	}
+
+	err = fmt.Errorf("hook <%s> failed: %w", name, err)
+	if output = strings.TrimSpace(output); output != "" {
+		err = fmt.Errorf("%w\n%s", err, output)
+	}
+
+	if si.FatalHooks {
+		return err
+	}
+	si.Warn(err)
+	return nil
+}
@@ -0,0 +1,197 @@
+package knot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// importExtensions are the extensions of the files ImportPages takes
+var importExtensions = []string{".png", ".jpg", ".jpeg", ".pdf"}
+
+// IsImportable tells whether file is an image or a pdf ImportPages
+// can make pages of
+func IsImportable(file string) bool {
+	extension := strings.ToLower(filepath.Ext(file))
+	for _, importable := range importExtensions {
+		if extension == importable {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportPages adds a page to a batch for each image and pdf page in
+// files, scaled to the template's canvas if fit is set, and returns
+// their numbers
+func ImportPages(templatePath string, si *SystemInfo, pi *ProjectInfo, batchNumber int, files []string, fit bool) ([]int, error) {
+	for _, file := range files {
+		if !IsImportable(file) {
+			return nil, UsageError("cannot import <%s>, expected png, jpeg or pdf", file)
+		}
+	}
+
+	batchDir := GetBatchDir(pi, batchNumber)
+	pageNumbers, err := GetPageNumbers(si.FS, batchDir, ".kra")
+	if err != nil {
+		return nil, &Error{Kind: ErrBatchNotFound, Path: batchDir, Cause: err}
+	}
+
+	var canvas image.Rectangle
+	if fit {
+		templatePage := filepath.Join(templatePath, "batch", "page.kra")
+		kra, err := si.FS.ReadFile(templatePage)
+		if err != nil {
+			return nil, &Error{Kind: ErrTemplateNotFound, Path: templatePath, Cause: err}
+		}
+		width, height, err := KraSize(kra)
+		if err != nil {
+			return nil, &Error{Kind: ErrTemplateNotFound, Path: templatePage, Cause: err}
+		}
+		canvas = image.Rect(0, 0, width, height)
+	}
+
+	var pages []int
+	next := nextNumber(pageNumbers)
+	err = withTransaction(si, pi.ContentDir, func(tx *transaction) error {
+		add := func(img image.Image) error {
+			if fit {
+				img = fitImage(img, canvas)
+			}
+			pageNumber := next + len(pages)
+			pageName := GetPageName(pageNumber)
+			kra, err := NewKra(img, strings.TrimSuffix(pageName, ".kra"))
+			if err != nil {
+				return &Error{Kind: ErrImportFailed, Cause: err}
+			}
+			if err = si.Actions.WriteFile(tx.staged(pageName), kra); err != nil {
+				return err
+			}
+			if err = tx.commit(pageName, filepath.Join(batchDir, pageName), ErrPageExists); err != nil {
+				return err
+			}
+			pages = append(pages, pageNumber)
+			return nil
+		}
+
+		for i, file := range files {
+			if strings.ToLower(filepath.Ext(file)) != ".pdf" {
+				img, err := readImage(si.FS, file)
+				if err != nil {
+					return err
+				}
+				if err = add(img); err != nil {
+					return err
+				}
+				continue
+			}
+
+			err := rasterizePDF(si, file, tx.staged(fmt.Sprintf("pdf-%d", i)), add)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, len(pages))
+	for i, pageNumber := range pages {
+		paths[i] = filepath.Join(batchDir, GetPageName(pageNumber))
+	}
+	snapshotCreated(si, pi, "import", paths...)
+	return pages, nil
+}
+
+// readImage decodes the png or jpeg in file
+func readImage(fsys FileSystem, file string) (image.Image, error) {
+	data, err := fsys.ReadFile(file)
+	if err != nil {
+		return nil, &Error{Kind: ErrImportFailed, Path: file, Cause: err}
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, &Error{Kind: ErrImportFailed, Path: file, Cause: err}
+	}
+	return img, nil
+}
+
+// rasterizePDF runs the PDFRasterizer on pdf, writing its pages as pngs
+// into dir, and passes each of them to add in order
+func rasterizePDF(si *SystemInfo, pdf string, dir string, add func(img image.Image) error) error {
+	if err := si.Actions.MkdirAll(dir); err != nil {
+		return err
+	}
+	output, err := si.Actions.Run(si.PDFRasterizer, []string{pdf, filepath.Join(dir, "page")})
+	if err != nil {
+		if output = strings.TrimSpace(output); output != "" {
+			err = fmt.Errorf("%w\n%s", err, output)
+		}
+		return &Error{Kind: ErrImportFailed, Path: pdf, Cause: err}
+	}
+
+	entries, err := si.FS.ReadDir(dir)
+	if os.IsNotExist(err) {
+		// in a dry run neither the directory nor the pngs are made
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var pngs []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.ToLower(filepath.Ext(entry.Name())) == ".png" {
+			pngs = append(pngs, entry.Name())
+		}
+	}
+	if len(pngs) == 0 {
+		return &Error{Kind: ErrImportFailed, Path: pdf,
+			Cause: errors.New("the pdf rasterizer made no pngs")}
+	}
+	sort.Slice(pngs, func(i, j int) bool { return naturalLess(pngs[i], pngs[j]) })
+
+	for _, name := range pngs {
+		img, err := readImage(si.FS, filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if err = add(img); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fitImage scales img to fit canvas, keeping its proportions, and
+// centres it on a white background the size of canvas
+func fitImage(img image.Image, canvas image.Rectangle) image.Image {
+	bounds := img.Bounds()
+	width := canvas.Dx()
+	if bounds.Dx()*canvas.Dy() < bounds.Dy()*canvas.Dx() {
+		width = bounds.Dx() * canvas.Dy() / bounds.Dy()
+	}
+	if width < 1 {
+		width = 1
+	}
+	scaled := img
+	if width != bounds.Dx() {
+		scaled = scaleImage(img, width)
+	}
+
+	result := image.NewNRGBA(canvas)
+	draw.Draw(result, canvas, image.NewUniform(color.White), image.Point{}, draw.Src)
+	size := scaled.Bounds().Size()
+	offset := image.Pt((canvas.Dx()-size.X)/2, (canvas.Dy()-size.Y)/2)
+	draw.Draw(result, image.Rectangle{offset, offset.Add(size)}, scaled, scaled.Bounds().Min, draw.Over)
+	return result
+}
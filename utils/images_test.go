@@ -0,0 +1,159 @@
+package knot
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testRasterizer stands in for the PDFRasterizer, writing a png per
+// page next to the prefix it is given, the way pdftoppm names them
+type testRasterizer struct {
+	fsys  FileSystem
+	pages [][]byte
+}
+
+func (rasterizer *testRasterizer) Run(inputs []string) (string, error) {
+	for i, page := range rasterizer.pages {
+		name := fmt.Sprintf("%s-%02d.png", inputs[1], i+1)
+		if err := rasterizer.fsys.WriteFile(name, page, 0644); err != nil {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+func (rasterizer *testRasterizer) Start(inputs []string) error {
+	_, err := rasterizer.Run(inputs)
+	return err
+}
+
+func readKraEntry(t *testing.T, kra []byte, name string) []byte {
+	t.Helper()
+	archive, err := zip.NewReader(bytes.NewReader(kra), int64(len(kra)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := archive.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	data, err := io.ReadAll(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestImportPages(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+	mustMkdirAll(t, mem, "/slides")
+	mustWriteFile(t, mem, "/slides/title.png", testPNG(t, 70, 10))
+
+	pages, err := ImportPages(testTemplatePath, si, &pi, 0, []string{"/slides/title.png"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 || pages[0] != 1 {
+		t.Fatalf("expected page 1, got %v", pages)
+	}
+
+	kra, err := mem.ReadFile(filepath.Join(GetBatchDir(&pi, 0), "page-1.kra"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width, height, err := KraSize(kra); err != nil || width != 70 || height != 10 {
+		t.Errorf("expected a 70x10 canvas, got %dx%d, %v", width, height, err)
+	}
+	if mimetype := readKraEntry(t, kra, "mimetype"); string(mimetype) != "application/x-krita" {
+		t.Errorf("expected the krita mimetype, got %q", mimetype)
+	}
+
+	var document kraImageDocument
+	if err = xml.Unmarshal(readKraEntry(t, kra, "maindoc.xml"), &document); err != nil {
+		t.Fatal(err)
+	}
+	layers := document.Image.Layers
+	if len(layers) != 2 || layers[0].Locked != 0 || layers[1].Locked != 1 {
+		t.Fatalf("expected a paint layer over a locked background, got %+v", layers)
+	}
+	background := readKraEntry(t, kra, "page-1/layers/"+layers[1].Filename)
+	if !bytes.HasPrefix(background, []byte("VERSION 2\nTILEWIDTH 64\nTILEHEIGHT 64\nPIXELSIZE 4\nDATA 2\n")) {
+		t.Errorf("expected the background to take 2 tiles, got %q", background[:60])
+	}
+	empty := readKraEntry(t, kra, "page-1/layers/"+layers[0].Filename)
+	if !strings.HasSuffix(string(empty), "DATA 0\n") {
+		t.Errorf("expected the paint layer to be empty, got %q", empty)
+	}
+}
+
+func TestImportPagesFit(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+	mustMkdirAll(t, mem, "/slides")
+	mustWriteFile(t, mem, "/slides/wide.png", testPNG(t, 80, 20))
+
+	pages, err := ImportPages(testTemplatePath, si, &pi, 0, []string{"/slides/wide.png"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kra, err := mem.ReadFile(filepath.Join(GetBatchDir(&pi, 0), GetPageName(pages[0])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := png.Decode(bytes.NewReader(readKraEntry(t, kra, "mergedimage.png")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := merged.Bounds().Size(); size.X != 4 || size.Y != 3 {
+		t.Errorf("expected the image to fit the 4x3 template, got %v", size)
+	}
+	// the image is 4x1 once scaled, so the top row is the white canvas
+	if r, g, b, _ := merged.At(0, 0).RGBA(); r != 0xffff || g != 0xffff || b != 0xffff {
+		t.Errorf("expected a white margin above the image, got %v", merged.At(0, 0))
+	}
+}
+
+func TestImportPagesFromPDF(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+	mustMkdirAll(t, mem, "/slides")
+	mustWriteFile(t, mem, "/slides/deck.pdf", []byte("%PDF-1.4"))
+	si.PDFRasterizer = &testRasterizer{fsys: mem,
+		pages: [][]byte{testPNG(t, 5, 5), testPNG(t, 6, 6)}}
+
+	pages, err := ImportPages(testTemplatePath, si, &pi, 0, []string{"/slides/deck.pdf"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 {
+		t.Fatalf("expected a page per pdf page, got %v", pages)
+	}
+	for i, size := range []int{5, 6} {
+		kra, err := mem.ReadFile(filepath.Join(GetBatchDir(&pi, 0), GetPageName(pages[i])))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if width, _, _ := KraSize(kra); width != size {
+			t.Errorf("expected page %d to be %d wide, got %d", i, size, width)
+		}
+	}
+
+	si.PDFRasterizer = &testRasterizer{fsys: mem}
+	_, err = ImportPages(testTemplatePath, si, &pi, 0, []string{"/slides/deck.pdf"}, false)
+	if !errors.Is(err, ErrImportFailed) {
+		t.Errorf("expected ErrImportFailed without any pngs, got %v", err)
+	}
+	if _, err = ImportPages(testTemplatePath, si, &pi, 0, []string{"/slides/deck.odp"}, false); !errors.Is(err, ErrUsage) {
+		t.Errorf("expected ErrUsage for an odp, got %v", err)
+	}
+	if numbers, _ := GetPageNumbers(mem, GetBatchDir(&pi, 0), ".kra"); len(numbers) != 3 {
+		t.Errorf("expected failed imports to add no pages, got %v", numbers)
+	}
+}
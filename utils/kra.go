@@ -0,0 +1,239 @@
+package knot
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// krita stores paint layers in tiles of kraTileSize pixels square
+const kraTileSize = 64
+
+// kraLayer is a paint layer of a .kra file, listed in maindoc.xml and
+// stored in <name>/layers/<filename>. A nil image is an empty layer
+type kraLayer struct {
+	name     string
+	filename string
+	locked   bool
+	image    image.Image
+}
+
+type kraFile struct {
+	name string
+	data []byte
+}
+
+// NewKra makes a .kra file with img as a locked background layer
+func NewKra(img image.Image, name string) ([]byte, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil, fmt.Errorf("the image is empty")
+	}
+
+	// layers are listed from the top down
+	layers := []kraLayer{
+		{name: "Annotations", filename: "layer2"},
+		{name: "Background", filename: "layer1", locked: true, image: img}}
+
+	maindoc, err := kraMaindoc(name, width, height, layers)
+	if err != nil {
+		return nil, err
+	}
+
+	var merged bytes.Buffer
+	if err = png.Encode(&merged, img); err != nil {
+		return nil, err
+	}
+	preview := img
+	if width > 256 {
+		preview = scaleImage(img, 256)
+	}
+	var previewPNG bytes.Buffer
+	if err = png.Encode(&previewPNG, preview); err != nil {
+		return nil, err
+	}
+
+	var kra bytes.Buffer
+	archive := zip.NewWriter(&kra)
+
+	// the mimetype comes first, uncompressed
+	mimetype, err := archive.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.WriteString(mimetype, "application/x-krita"); err != nil {
+		return nil, err
+	}
+
+	files := []kraFile{
+		{"maindoc.xml", maindoc},
+		{"documentinfo.xml", []byte(kraDocumentInfo)},
+		{"mergedimage.png", merged.Bytes()},
+		{"preview.png", previewPNG.Bytes()}}
+	for _, layer := range layers {
+		files = append(files,
+			kraFile{name + "/layers/" + layer.filename, kraTiles(layer.image)},
+			kraFile{name + "/layers/" + layer.filename + ".defaultpixel", make([]byte, 4)})
+	}
+	for _, file := range files {
+		w, err := archive.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(file.data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = archive.Close(); err != nil {
+		return nil, err
+	}
+	return kra.Bytes(), nil
+}
+
+const kraDocumentInfo = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE document-info PUBLIC '-//KDE//DTD document-info 1.1//EN' 'http://www.calligra.org/DTD/document-info-1.1.dtd'>
+<document-info xmlns="http://www.calligra.org/DTD/document-info">
+ <about>
+  <editing-cycles>1</editing-cycles>
+ </about>
+</document-info>
+`
+
+type kraImageLayer struct {
+	Name           string `xml:"name,attr"`
+	Filename       string `xml:"filename,attr"`
+	NodeType       string `xml:"nodetype,attr"`
+	Visible        int    `xml:"visible,attr"`
+	Locked         int    `xml:"locked,attr"`
+	Opacity        int    `xml:"opacity,attr"`
+	CompositeOp    string `xml:"compositeop,attr"`
+	ColorSpaceName string `xml:"colorspacename,attr"`
+	X              int    `xml:"x,attr"`
+	Y              int    `xml:"y,attr"`
+	UUID           string `xml:"uuid,attr"`
+}
+
+// kraImageDocument is the maindoc.xml of a .kra file NewKra makes
+type kraImageDocument struct {
+	XMLName       xml.Name `xml:"DOC"`
+	Xmlns         string   `xml:"xmlns,attr"`
+	SyntaxVersion string   `xml:"syntaxVersion,attr"`
+	Editor        string   `xml:"editor,attr"`
+	Image         struct {
+		Mime           string          `xml:"mime,attr"`
+		Name           string          `xml:"name,attr"`
+		Width          int             `xml:"width,attr"`
+		Height         int             `xml:"height,attr"`
+		ColorSpaceName string          `xml:"colorspacename,attr"`
+		XRes           int             `xml:"x-res,attr"`
+		YRes           int             `xml:"y-res,attr"`
+		Layers         []kraImageLayer `xml:"layers>layer"`
+	} `xml:"IMAGE"`
+}
+
+// kraMaindoc returns the maindoc.xml of a .kra file, which describes
+// its canvas and layers
+func kraMaindoc(name string, width, height int, layers []kraLayer) ([]byte, error) {
+	document := kraImageDocument{
+		Xmlns:         "http://www.calligra.org/DTD/krita",
+		SyntaxVersion: "2.0",
+		Editor:        "Krita"}
+	document.Image.Mime = "application/x-kra"
+	document.Image.Name = name
+	document.Image.Width = width
+	document.Image.Height = height
+	document.Image.ColorSpaceName = "RGBA"
+	document.Image.XRes = 300
+	document.Image.YRes = 300
+
+	for _, layer := range layers {
+		uuid, err := newUUID()
+		if err != nil {
+			return nil, err
+		}
+		locked := 0
+		if layer.locked {
+			locked = 1
+		}
+		document.Image.Layers = append(document.Image.Layers, kraImageLayer{
+			Name:           layer.name,
+			Filename:       layer.filename,
+			NodeType:       "paintlayer",
+			Visible:        1,
+			Locked:         locked,
+			Opacity:        255,
+			CompositeOp:    "normal",
+			ColorSpaceName: "RGBA",
+			UUID:           uuid})
+	}
+
+	var result bytes.Buffer
+	result.WriteString(xml.Header)
+	result.WriteString("<!DOCTYPE DOC PUBLIC '-//KDE//DTD krita 2.0//EN' 'http://www.calligra.org/DTD/krita-2.0.dtd'>\n")
+	encoder := xml.NewEncoder(&result)
+	encoder.Indent("", " ")
+	if err := encoder.Encode(document); err != nil {
+		return nil, err
+	}
+	result.WriteString("\n")
+	return result.Bytes(), nil
+}
+
+// kraTiles returns a layer in krita's tiled format, uncompressed
+func kraTiles(img image.Image) []byte {
+	var columns, rows int
+	if img != nil {
+		bounds := img.Bounds()
+		columns = (bounds.Dx() + kraTileSize - 1) / kraTileSize
+		rows = (bounds.Dy() + kraTileSize - 1) / kraTileSize
+	}
+
+	var result bytes.Buffer
+	fmt.Fprintf(&result, "VERSION 2\nTILEWIDTH %d\nTILEHEIGHT %d\nPIXELSIZE 4\nDATA %d\n",
+		kraTileSize, kraTileSize, columns*rows)
+	for row := 0; row < rows; row++ {
+		for column := 0; column < columns; column++ {
+			x, y := column*kraTileSize, row*kraTileSize
+			tile := kraTile(img, x, y)
+			fmt.Fprintf(&result, "%d,%d,LZF,%d\n", x, y, len(tile)+1)
+			result.WriteByte(0)
+			result.Write(tile)
+		}
+	}
+	return result.Bytes()
+}
+
+// kraTile returns the pixels of the tile of img at x, y, transparent
+// where it goes past the edge of img
+func kraTile(img image.Image, x, y int) []byte {
+	bounds := img.Bounds()
+	tile := make([]byte, kraTileSize*kraTileSize*4)
+	for ty := 0; ty < kraTileSize && y+ty < bounds.Dy(); ty++ {
+		for tx := 0; tx < kraTileSize && x+tx < bounds.Dx(); tx++ {
+			c := color.NRGBAModel.Convert(
+				img.At(bounds.Min.X+x+tx, bounds.Min.Y+y+ty)).(color.NRGBA)
+			i := (ty*kraTileSize + tx) * 4
+			tile[i], tile[i+1], tile[i+2], tile[i+3] = c.B, c.G, c.R, c.A
+		}
+	}
+	return tile
+}
+
+// newUUID returns a random uuid in braces, the way krita writes them
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("{%x-%x-%x-%x-%x}", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
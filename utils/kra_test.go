@@ -0,0 +1,95 @@
+package knot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"testing"
+)
+
+// decodeKraTiles reads a paint layer the way krita's tile reader does,
+// independently of kraTiles: a header of key value lines, then for
+// each tile a "x,y,LZF,size" line and size bytes, the first of which
+// is 0 for raw BGRA pixels
+func decodeKraTiles(data []byte, width, height int) (*image.NRGBA, error) {
+	reader := bufio.NewReader(bytes.NewReader(data))
+	header := make(map[string]int)
+	for _, key := range []string{"VERSION", "TILEWIDTH", "TILEHEIGHT", "PIXELSIZE", "DATA"} {
+		var value int
+		if _, err := fmt.Fscanf(reader, key+" %d\n", &value); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", key, err)
+		}
+		header[key] = value
+	}
+	if header["VERSION"] != 2 || header["PIXELSIZE"] != 4 {
+		return nil, fmt.Errorf("unexpected header %v", header)
+	}
+	tileWidth, tileHeight := header["TILEWIDTH"], header["TILEHEIGHT"]
+
+	result := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for tile := 0; tile < header["DATA"]; tile++ {
+		var x, y, size int
+		if _, err := fmt.Fscanf(reader, "%d,%d,LZF,%d\n", &x, &y, &size); err != nil {
+			return nil, fmt.Errorf("reading tile %d: %w", tile, err)
+		}
+		pixels := make([]byte, size)
+		if _, err := io.ReadFull(reader, pixels); err != nil {
+			return nil, err
+		}
+		if pixels[0] != 0 || len(pixels)-1 != tileWidth*tileHeight*4 {
+			return nil, fmt.Errorf("tile %d,%d isn't raw, or is %d bytes", x, y, len(pixels))
+		}
+		pixels = pixels[1:]
+		for ty := 0; ty < tileHeight; ty++ {
+			for tx := 0; tx < tileWidth; tx++ {
+				i := (ty*tileWidth + tx) * 4
+				c := color.NRGBA{B: pixels[i], G: pixels[i+1], R: pixels[i+2], A: pixels[i+3]}
+				if x+tx >= width || y+ty >= height {
+					if c != (color.NRGBA{}) {
+						return nil, fmt.Errorf("pixel %d,%d past the canvas is %v", x+tx, y+ty, c)
+					}
+					continue
+				}
+				result.SetNRGBA(x+tx, y+ty, c)
+			}
+		}
+	}
+	if rest, _ := io.ReadAll(reader); len(rest) != 0 {
+		return nil, fmt.Errorf("%d bytes after the last tile", len(rest))
+	}
+	return result, nil
+}
+
+func TestKraTilesRoundTrip(t *testing.T) {
+	// wider than a tile, with a different colour and alpha everywhere
+	src := image.NewNRGBA(image.Rect(0, 0, 70, 66))
+	for y := 0; y < 66; y++ {
+		for x := 0; x < 70; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: uint8(x + y), A: uint8(255 - x)})
+		}
+	}
+
+	kra, err := NewKra(src, "page-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document kraImageDocument
+	if err = xml.Unmarshal(readKraEntry(t, kra, "maindoc.xml"), &document); err != nil {
+		t.Fatal(err)
+	}
+	background := document.Image.Layers[1]
+	decoded, err := decodeKraTiles(readKraEntry(t, kra, "page-0/layers/"+background.Filename), 70, 66)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.Pix, src.Pix) {
+		t.Error("expected the background layer to decode to the image")
+	}
+	if defaultPixel := readKraEntry(t, kra, "page-0/layers/"+background.Filename+".defaultpixel"); !bytes.Equal(defaultPixel, make([]byte, 4)) {
+		t.Errorf("expected a transparent default pixel, got %v", defaultPixel)
+	}
+}
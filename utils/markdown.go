@@ -0,0 +1,314 @@
+package knot
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	markdownBegin = "<!-- knot:begin -->"
+	markdownEnd   = "<!-- knot:end -->"
+)
+
+// MarkdownOptions controls where markdown notes are written and how
+// the links inside them are resolved
+type MarkdownOptions struct {
+	// OutputDir is the directory the notes are written to
+	OutputDir string
+	// VaultRoot, if set, makes every link relative to it
+	VaultRoot string
+	// Tags are added to the front matter of every note
+	Tags []string
+}
+
+type markdownField struct {
+	key   string
+	value string
+}
+
+// ExportMarkdown writes a note for every batch and an index note for
+// the project, and returns the path to the index. The notes of the
+// batches of groups go in the directories of their groups
+func ExportMarkdown(opts *MarkdownOptions, pi *ProjectInfo, si *SystemInfo) (string, error) {
+	groups, err := GetBatchGroups(si.FS, pi)
+	if err != nil {
+		return "", err
+	}
+
+	if err = EnsureDir(si.FS, si.Actions, opts.OutputDir); err != nil {
+		return "", err
+	}
+
+	projectName := filepath.Base(pi.ProjectDir)
+	projectCreated, projectModified := time.Time{}, time.Time{}
+
+	var index strings.Builder
+	batches := 0
+	err = groups.Walk(func(group *ProjectInfo, batchNumber int) error {
+		created, modified, err := exportMarkdownBatch(opts, batchNumber, group, si)
+		if err != nil {
+			return err
+		}
+		batches++
+
+		if projectCreated.IsZero() || created.Before(projectCreated) {
+			projectCreated = created
+		}
+		if modified.After(projectModified) {
+			projectModified = modified
+		}
+
+		name := path.Join(group.Group, GetBatchName(group, batchNumber))
+		fmt.Fprintf(&index, "- [%s](%s)\n", name, markdownLink(
+			opts, opts.OutputDir, markdownNoteFile(opts, group, batchNumber)))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	indexFile := filepath.Join(
+		opts.OutputDir, fmt.Sprintf("%s.md", projectName))
+
+	fields := []markdownField{
+		{"project", projectName},
+		{"batches", fmt.Sprintf("%d", batches)},
+		{"tags", markdownTags(opts.Tags, "knot", projectName)},
+		{"created", markdownDate(projectCreated)},
+		{"modified", markdownDate(projectModified)}}
+
+	return indexFile, writeMarkdownNote(
+		si, indexFile, fields, fmt.Sprintf("# %s\n\n", projectName), index.String())
+}
+
+func exportMarkdownBatch(opts *MarkdownOptions, batchNumber int, pi *ProjectInfo, si *SystemInfo) (time.Time, time.Time, error) {
+	batchName := GetBatchName(pi, batchNumber)
+	batchPath := GetBatchDir(pi, batchNumber)
+	exportPath := filepath.Join(batchPath, pi.ExportDirName)
+	noteFile := markdownNoteFile(opts, pi, batchNumber)
+	noteDir := filepath.Dir(noteFile)
+
+	var created, modified time.Time
+
+	if err := EnsureDir(si.FS, si.Actions, exportPath); err != nil {
+		return created, modified, err
+	}
+	if err := EnsureDir(si.FS, si.Actions, noteDir); err != nil {
+		return created, modified, err
+	}
+
+	pageNumbers, err := GetPageNumbers(si.FS, batchPath, ".kra")
+	if err != nil {
+		return created, modified, err
+	}
+
+	var body strings.Builder
+	for _, pageNumber := range pageNumbers {
+		page := filepath.Join(batchPath, GetPageName(pageNumber))
+		image := filepath.Join(
+			exportPath, ChangeFileExt(GetPageName(pageNumber), "png"))
+
+		pageStat, err := si.FS.Stat(page)
+		if err != nil {
+			return created, modified, err
+		}
+		if created.IsZero() || pageStat.ModTime().Before(created) {
+			created = pageStat.ModTime()
+		}
+		if pageStat.ModTime().After(modified) {
+			modified = pageStat.ModTime()
+		}
+
+		if err = ExportToPNG(si, page, image); err != nil {
+			return created, modified, err
+		}
+
+		fmt.Fprintf(&body, "![%s](%s)\n\n",
+			FileWithoutExt(GetPageName(pageNumber)),
+			markdownLink(opts, noteDir, image))
+	}
+
+	pdf := filepath.Join(batchPath, fmt.Sprintf("%s.pdf", batchName))
+	if _, err := si.FS.Stat(pdf); err == nil {
+		fmt.Fprintf(&body, "[%s.pdf](%s)\n",
+			batchName, markdownLink(opts, noteDir, pdf))
+	}
+
+	fields := []markdownField{
+		{"project", filepath.Base(pi.ProjectDir)},
+		{"batch", BatchPath(pi, batchNumber)},
+		{"pages", fmt.Sprintf("%d", len(pageNumbers))},
+		{"tags", markdownTags(opts.Tags, "knot", filepath.Base(pi.ProjectDir))},
+		{"created", markdownDate(created)},
+		{"modified", markdownDate(modified)}}
+
+	return created, modified, writeMarkdownNote(
+		si, noteFile, fields, fmt.Sprintf("# %s\n\n", batchName), body.String())
+}
+
+// markdownLink returns the link to target as seen from a note in
+// noteDir, wrapped in angle brackets so that spaces survive
+// markdownNoteFile returns the note of a batch, in the directories of
+// its groups
+func markdownNoteFile(opts *MarkdownOptions, pi *ProjectInfo, batchNumber int) string {
+	return filepath.Join(opts.OutputDir, filepath.FromSlash(pi.Group),
+		fmt.Sprintf("%s.md", GetBatchName(pi, batchNumber)))
+}
+
+func markdownLink(opts *MarkdownOptions, noteDir string, target string) string {
+	base := noteDir
+	if opts.VaultRoot != "" {
+		base = opts.VaultRoot
+	}
+
+	rel, err := filepath.Rel(base, target)
+	if err != nil || outsideDir(rel) {
+		return fmt.Sprintf("<file://%s>", filepath.ToSlash(target))
+	}
+	return fmt.Sprintf("<%s>", filepath.ToSlash(rel))
+}
+
+func markdownTags(tags []string, defaults ...string) string {
+	all := append(append([]string{}, defaults...), tags...)
+	seen := make(map[string]bool)
+	unique := make([]string, 0, len(all))
+	for _, tag := range all {
+		tag = strings.ReplaceAll(strings.TrimSpace(tag), " ", "-")
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		unique = append(unique, tag)
+	}
+	return fmt.Sprintf("[%s]", strings.Join(unique, ", "))
+}
+
+func markdownDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// writeMarkdownNote creates a note, or updates only the knot fields
+// and markers of an existing one
+func writeMarkdownNote(si *SystemInfo, file string, fields []markdownField, heading string, body string) error {
+	generated := fmt.Sprintf("%s\n%s%s\n", markdownBegin, body, markdownEnd)
+
+	existing, err := si.FS.ReadFile(file)
+	if err != nil {
+		content := fmt.Sprintf("%s\n%s%s",
+			mergeFrontMatter(nil, fields), heading, generated)
+		return si.Actions.WriteFile(file, []byte(content))
+	}
+
+	frontMatter, rest := splitFrontMatter(string(existing))
+
+	begin := strings.Index(rest, markdownBegin)
+	end := strings.Index(rest, markdownEnd)
+	if begin >= 0 && end > begin {
+		after := rest[end+len(markdownEnd):]
+		after = strings.TrimPrefix(after, "\n")
+		rest = rest[:begin] + generated + after
+	} else {
+		rest = strings.TrimRight(rest, "\n") + "\n\n" + generated
+	}
+
+	content := fmt.Sprintf("%s\n%s", mergeFrontMatter(frontMatter, fields), rest)
+	return si.Actions.WriteFile(file, []byte(content))
+}
+
+// frontMatterContinues tells whether a line of a front matter belongs
+// to the value of the key above it
+func frontMatterContinues(line string) bool {
+	return strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") ||
+		line == "-" || strings.HasPrefix(line, "- ")
+}
+
+// splitFrontMatter separates the lines of a yaml front matter block
+// from the rest of a note. The closing fence may end the note
+func splitFrontMatter(note string) ([]string, string) {
+	if !strings.HasPrefix(note, "---\n") {
+		return nil, note
+	}
+	offset := len("---\n")
+	for _, line := range strings.SplitAfter(note[offset:], "\n") {
+		if strings.TrimSuffix(line, "\n") == "---" {
+			block := strings.TrimSuffix(note[len("---\n"):offset], "\n")
+			if block == "" {
+				return nil, note[offset+len(line):]
+			}
+			return strings.Split(block, "\n"), note[offset+len(line):]
+		}
+		offset += len(line)
+	}
+	return nil, note
+}
+
+// mergeFrontMatter sets fields in a front matter, replacing the whole
+// value of those it has. The tags already there are kept, ahead of
+// those of the field
+func mergeFrontMatter(lines []string, fields []markdownField) string {
+	values := make(map[string]string)
+	for _, field := range fields {
+		values[field.key] = field.value
+	}
+
+	written := make(map[string]bool)
+	result := []string{"---"}
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		key, inline, found := strings.Cut(line, ":")
+		key = strings.TrimSpace(key)
+		value, ours := values[key]
+		if !found || !ours || frontMatterContinues(line) {
+			result = append(result, line)
+			continue
+		}
+
+		var block []string
+		for i+1 < len(lines) && frontMatterContinues(lines[i+1]) {
+			i++
+			block = append(block, lines[i])
+		}
+		if key == "tags" {
+			value = markdownTags(frontMatterList(value), frontMatterList(inline, block...)...)
+		}
+		result = append(result, fmt.Sprintf("%s: %s", key, value))
+		written[key] = true
+	}
+	for _, field := range fields {
+		if !written[field.key] {
+			result = append(result, fmt.Sprintf("%s: %s", field.key, field.value))
+		}
+	}
+	result = append(result, "---")
+
+	return strings.Join(result, "\n")
+}
+
+// frontMatterList reads a yaml list written inline, as in [a, b] or a
+// single value, or as a block of "- a" lines
+func frontMatterList(inline string, block ...string) []string {
+	var items []string
+	add := func(item string) {
+		if item = strings.Trim(strings.TrimSpace(item), `"'`); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	inline = strings.TrimSpace(inline)
+	inline = strings.TrimSuffix(strings.TrimPrefix(inline, "["), "]")
+	for _, item := range strings.Split(inline, ",") {
+		add(item)
+	}
+	for _, line := range block {
+		if item := strings.TrimSpace(line); strings.HasPrefix(item, "-") {
+			add(item[1:])
+		}
+	}
+	return items
+}
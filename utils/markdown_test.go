@@ -0,0 +1,86 @@
+package knot
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergeFrontMatter(t *testing.T) {
+	lines := []string{"title: Limits", "tags:", "  - old", "- older", "aliases:", "  - limits"}
+	fields := []markdownField{{"tags", "[knot, calculus]"}, {"pages", "3"}}
+
+	merged := mergeFrontMatter(lines, fields)
+	expected := strings.Join([]string{"---", "title: Limits", "tags: [old, older, knot, calculus]",
+		"aliases:", "  - limits", "pages: 3", "---"}, "\n")
+	if merged != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, merged)
+	}
+
+	// tags written inline keep their order, and those in both only once
+	merged = mergeFrontMatter([]string{`tags: [exam, "knot"]`}, fields)
+	if expected = "---\ntags: [exam, knot, calculus]\npages: 3\n---"; merged != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, merged)
+	}
+}
+
+func TestSplitFrontMatter(t *testing.T) {
+	for _, test := range []struct {
+		note  string
+		lines []string
+		rest  string
+	}{
+		{note: "---\ntags: [exam]\n---\n# Limits\n", lines: []string{"tags: [exam]"}, rest: "# Limits\n"},
+		{note: "---\ntags: [exam]\n---", lines: []string{"tags: [exam]"}, rest: ""},
+		{note: "---\n---\n# Limits\n", rest: "# Limits\n"},
+		{note: "---\ntags: [exam]\n# Limits\n", rest: "---\ntags: [exam]\n# Limits\n"},
+		{note: "# Limits\n---\n", rest: "# Limits\n---\n"},
+	} {
+		lines, rest := splitFrontMatter(test.note)
+		if strings.Join(lines, "|") != strings.Join(test.lines, "|") || rest != test.rest {
+			t.Errorf("%q: expected %q and %q, got %q and %q", test.note, test.lines, test.rest, lines, rest)
+		}
+	}
+}
+
+func TestMarkdownLink(t *testing.T) {
+	opts := &MarkdownOptions{}
+	inside := filepath.Join("/vault", "..notes", "notes-0.pdf")
+	if link := markdownLink(opts, "/vault", inside); link != "<..notes/notes-0.pdf>" {
+		t.Errorf("expected a relative link to a directory named ..notes, got %s", link)
+	}
+	if link := markdownLink(opts, "/vault", "/projects/notes-0.pdf"); link != "<file:///projects/notes-0.pdf>" {
+		t.Errorf("expected a file link outside of the vault, got %s", link)
+	}
+}
+
+func TestExportMarkdownInGroup(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+	unit := pi.InGroup("unit-2")
+	if err := MakeBatch(testTemplatePath, si, &unit, 3, false); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := ExportMarkdown(&MarkdownOptions{OutputDir: "/projects/notes/md", VaultRoot: "/projects/notes"}, &pi, si)
+	if err != nil {
+		t.Fatal(err)
+	}
+	note, err := mem.ReadFile("/projects/notes/md/unit-2/notes-3.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(note), "batch: unit-2/3\n") ||
+		!strings.Contains(string(note), "(<content/unit-2/notes-3/export/page-0.png>)") {
+		t.Errorf("expected the note of the grouped batch to link its pages, got\n%s", note)
+	}
+
+	contents, err := mem.ReadFile(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []string{"- [notes-0](<md/notes-0.md>)", "- [unit-2/notes-3](<md/unit-2/notes-3.md>)", "batches: 2"} {
+		if !strings.Contains(string(contents), entry) {
+			t.Errorf("expected %q in the index, got\n%s", entry, contents)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package knot
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+)
+
+// legacyMigratedFile is left in the state directory once the legacy
+// files were migrated, so that they aren't looked for again
+const legacyMigratedFile = "legacy-migrated"
+
+// MigrateLegacyFiles moves the files of older versions of knot, once,
+// and returns the errors of those it failed to move
+func MigrateLegacyFiles(fsys FileSystem, actions Actions, platformDirs *PlatformDirs, knotDirs *KnotDirs) []error {
+	marker := filepath.Join(knotDirs.StateDir, legacyMigratedFile)
+	if _, err := fsys.Stat(marker); err == nil {
+		return nil
+	}
+	legacyConfigDir := filepath.Join(platformDirs.LegacyConfigDir, "knot")
+
+	moves := [][2]string{
+		{filepath.Join(platformDirs.LegacyTempDir, "knotconfig.json"),
+			filepath.Join(knotDirs.StateDir, "knotconfig.json")},
+		{filepath.Join(legacyConfigDir, "projects.json"),
+			filepath.Join(knotDirs.ConfigDir, "projects.json")},
+		{filepath.Join(legacyConfigDir, "config.zy"),
+			filepath.Join(knotDirs.ConfigDir, "config.zy")},
+		{filepath.Join(legacyConfigDir, "templates"),
+			filepath.Join(knotDirs.ConfigDir, "templates")},
+		{filepath.Join(legacyConfigDir, "export.py"),
+			filepath.Join(knotDirs.DataDir, "export.py")}}
+
+	var errs []error
+	for _, move := range moves {
+		if err := migrateLegacyFile(fsys, actions, move[0], move[1]); err != nil {
+			errs = append(errs, fmt.Errorf(
+				"unable to migrate <%s> to <%s>: %w", move[0], move[1], err))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	err := EnsureDir(fsys, actions, knotDirs.StateDir)
+	if err == nil {
+		err = actions.WriteFile(marker, nil)
+	}
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+func migrateLegacyFile(fsys FileSystem, actions Actions, src, dst string) error {
+	if src == dst || src == "" {
+		return nil
+	}
+	srcStat, err := fsys.Stat(src)
+	if err != nil || !OwnedByCurrentUser(srcStat) {
+		return nil
+	}
+	if _, err = fsys.Stat(dst); err == nil {
+		return nil
+	}
+
+	if err = EnsureDir(fsys, actions, filepath.Dir(dst)); err != nil {
+		return err
+	}
+
+	if err = actions.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	if srcStat.IsDir() {
+		err = actions.CopyDir(src, dst)
+	} else {
+		err = actions.CopyFile(src, dst)
+	}
+	if err != nil {
+		return err
+	}
+	return actions.RemoveAll(src)
+}
+
+// BatchRename is a batch renamed by MigrateBatchNaming, by the paths
+// of its directory relative to the content directory of the project
+type BatchRename struct {
+	From string
+	To   string
+}
+
+// MigrateBatchNaming renames the numbered batches of a project after
+// the day or week they were last modified, and returns them
+func MigrateBatchNaming(si *SystemInfo, pi *ProjectInfo, naming string) ([]BatchRename, error) {
+	return migrateBatchNaming(si, pi, naming, func() error { return nil })
+}
+
+// migrateBatchNaming is MigrateBatchNaming, calling commit once every
+// batch is renamed. If commit fails, the batches are renamed back
+func migrateBatchNaming(si *SystemInfo, pi *ProjectInfo, naming string, commit func() error) ([]BatchRename, error) {
+	if naming == BatchNumbered || !ValidBatchNaming(naming) {
+		return nil, UsageError("unknown batch naming <%s>, use date or week", naming)
+	}
+	if pi.BatchNaming != BatchNumbered {
+		return nil, UsageError("the batches of <%s> are already named by %s",
+			pi.ProjectDir, pi.BatchNaming)
+	}
+	root := pi.Root()
+	groups, err := GetBatchGroups(si.FS, &root)
+	if err != nil {
+		return nil, err
+	}
+
+	// the new number of each batch by group
+	numbers := make(map[string]map[int]int)
+	owners := make(map[string]map[int]int)
+	var renames []BatchRename
+	err = groups.Walk(func(group *ProjectInfo, from int) error {
+		target := *group
+		target.BatchNaming = naming
+		if numbers[group.Group] == nil {
+			numbers[group.Group] = make(map[int]int)
+			owners[group.Group] = make(map[int]int)
+		}
+
+		stat, err := si.FS.Stat(GetBatchDir(group, from))
+		if err != nil {
+			return err
+		}
+		to := BatchForDate(&target, stat.ModTime())
+		toDir := GetBatchDir(&target, to)
+		if other, ok := owners[group.Group][to]; ok {
+			return &Error{Kind: ErrBatchExists, Path: toDir, Cause: fmt.Errorf(
+				"batches %s and %s were both last modified in %s",
+				BatchPath(group, other), BatchPath(group, from), BatchLabel(&target, to))}
+		}
+		if _, err = si.FS.Stat(toDir); err == nil {
+			return &Error{Kind: ErrBatchExists, Path: toDir}
+		}
+		owners[group.Group][to] = from
+		numbers[group.Group][from] = to
+		renames = append(renames, BatchRename{
+			From: path.Join(group.Group, GetBatchName(group, from)),
+			To:   path.Join(group.Group, GetBatchName(&target, to))})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err = SnapshotPages(si, &root, "before batch naming migration"); err != nil {
+		return nil, err
+	}
+
+	var moved []BatchRename
+	rollback := func() {
+		for i := len(moved) - 1; i >= 0; i-- {
+			moveBatch(si, root.ContentDir, moved[i].To, moved[i].From)
+		}
+	}
+	for _, rename := range renames {
+		if err = moveBatch(si, root.ContentDir, rename.From, rename.To); err != nil {
+			rollback()
+			return nil, err
+		}
+		moved = append(moved, rename)
+	}
+
+	undo, err := migrateBatchMetadata(si, &root, naming, numbers)
+	if err != nil {
+		rollback()
+		return nil, err
+	}
+	if err = commit(); err != nil {
+		undo()
+		rollback()
+		return nil, err
+	}
+	return renames, nil
+}
+
+// moveBatch renames the directory of a batch, given relative to
+// contentDir, along with the pdf it was exported to
+func moveBatch(si *SystemInfo, contentDir, from, to string) error {
+	fromDir := filepath.Join(contentDir, filepath.FromSlash(from))
+	toDir := filepath.Join(contentDir, filepath.FromSlash(to))
+	if err := si.Actions.Rename(fromDir, toDir); err != nil {
+		return err
+	}
+
+	pdf := filepath.Join(toDir, path.Base(from)+".pdf")
+	if _, err := si.FS.Stat(pdf); err != nil {
+		return nil
+	}
+	if err := si.Actions.Rename(pdf, filepath.Join(toDir, path.Base(to)+".pdf")); err != nil {
+		si.Actions.Rename(toDir, fromDir)
+		return err
+	}
+	return nil
+}
+
+// migrateBatchMetadata moves annotations and saved versions to the
+// new batch numbers, and returns a function that puts them back
+func migrateBatchMetadata(si *SystemInfo, pi *ProjectInfo, naming string, numbers map[string]map[int]int) (func(), error) {
+	// the annotations as they were, to put back on failure
+	saved := make(map[string]Annotations)
+	restore := func() {
+		for group, annotations := range saved {
+			info := pi.InGroup(group)
+			annotations.Save(si, &info)
+		}
+	}
+
+	for group, groupNumbers := range numbers {
+		info := pi.InGroup(group)
+		annotations, err := GetAnnotations(si.FS, &info)
+		if err != nil {
+			restore()
+			return nil, err
+		}
+		if len(annotations) == 0 {
+			continue
+		}
+		migrated := make(Annotations)
+		for batchNumber, pages := range annotations {
+			if to, ok := groupNumbers[batchNumber]; ok {
+				batchNumber = to
+			}
+			migrated[batchNumber] = pages
+		}
+		if err = migrated.Save(si, &info); err != nil {
+			restore()
+			return nil, err
+		}
+		saved[group] = annotations
+	}
+
+	index, err := readHistoryIndex(si.FS, pi)
+	if err != nil {
+		restore()
+		return nil, err
+	}
+	if len(index.Pages) == 0 {
+		return restore, nil
+	}
+	pages := make(map[string][]Revision)
+	for rel, versions := range index.Pages {
+		page := filepath.Join(pi.ProjectDir, filepath.FromSlash(rel))
+		group, batchNumber := PathBatch(si.FS, pi, page)
+		if to, ok := numbers[group.Group][batchNumber]; ok {
+			target := group
+			target.BatchNaming = naming
+			moved := filepath.Join(GetBatchDir(&target, to), filepath.Base(page))
+			if rel, err = relativePage(pi, moved); err != nil {
+				restore()
+				return nil, err
+			}
+		}
+		pages[rel] = versions
+	}
+	previous := index.Pages
+	index.Pages = pages
+	if err = index.save(si, pi); err != nil {
+		restore()
+		return nil, err
+	}
+	return func() {
+		index.Pages = previous
+		index.save(si, pi)
+		restore()
+	}, nil
+}
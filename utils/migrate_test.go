@@ -0,0 +1,32 @@
+package knot
+
+import (
+	"testing"
+)
+
+func TestMigrateLegacyFilesOnce(t *testing.T) {
+	mem := NewMemFileSystem()
+	actions := SystemActions{FS: mem}
+	platformDirs := PlatformDirs{LegacyConfigDir: "/home/.config", LegacyTempDir: "/tmp"}
+	knotDirs := KnotDirs{ConfigDir: "/config", StateDir: "/state", DataDir: "/data"}
+	mustMkdirAll(t, mem, "/tmp")
+	mustWriteFile(t, mem, "/tmp/knotconfig.json", []byte(`{"KnotWD": "/notes"}`))
+
+	if errs := MigrateLegacyFiles(mem, actions, &platformDirs, &knotDirs); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if state, err := mem.ReadFile("/state/knotconfig.json"); err != nil || string(state) != `{"KnotWD": "/notes"}` {
+		t.Errorf("expected the state file to be moved, got %q, %v", state, err)
+	}
+	assertNotExists(t, mem, "/tmp/knotconfig.json")
+
+	// once migrated, legacy files that turn up later are left alone
+	mustWriteFile(t, mem, "/tmp/knotconfig.json", []byte(`{"KnotWD": "/other"}`))
+	if err := mem.Remove("/state/knotconfig.json"); err != nil {
+		t.Fatal(err)
+	}
+	if errs := MigrateLegacyFiles(mem, actions, &platformDirs, &knotDirs); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	assertNotExists(t, mem, "/state/knotconfig.json")
+}
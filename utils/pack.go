@@ -0,0 +1,387 @@
+package knot
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A .knotpack is a zip of a project's files and a manifest.json with
+// their hashes
+
+const (
+	packFormat       = "knotpack"
+	packVersion      = 1
+	packManifestName = "manifest.json"
+	packFilesDir     = "files/"
+	PackExtension    = ".knotpack"
+)
+
+// PackManifest describes the project in a .knotpack
+type PackManifest struct {
+	Format  string
+	Version int
+	Created time.Time
+	// Name is the name the project was registered under
+	Name string
+	// ContentDir is relative to the project directory
+	ContentDir    string
+	ContentName   string
+	ExportDirName string
+	TemplateName  string
+	BatchNaming   string `json:",omitempty"`
+	// Groups are the groups of batches, whose knot.zy markers are left
+	// out of the pack like every other knot.zy
+	Groups []string `json:",omitempty"`
+	// Exports tells whether the export directories were packed
+	Exports bool
+	Files   []PackFile
+}
+
+// PackFile is a file of a .knotpack or a backup
+type PackFile struct {
+	Path   string
+	Size   int64
+	SHA256 string
+}
+
+// fileHash returns the sha256 of content, as the manifests have it
+func fileHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// packConfigName is the name of the configuration files left out of
+// packs, since they can run any command
+const packConfigName = "knot.zy"
+
+// packSkipped tells whether a file of a project is left out of its
+// pack
+func packSkipped(fsys FileSystem, pi *ProjectInfo, path string, isDir bool, exports bool) bool {
+	name := filepath.Base(path)
+	switch {
+	case name == ".git" || path == historyDir(pi):
+		return true
+	case !isDir && name == packConfigName:
+		return true
+	case !isDir && strings.HasSuffix(name, PackExtension):
+		return true
+	case isDir && !exports && name == pi.ExportDirName:
+		_, batchNumber := PathBatch(fsys, pi, filepath.Dir(path))
+		return batchNumber >= 0
+	}
+	return transientFile(path)
+}
+
+// transientFile tells whether a file is a staging directory or a
+// krita backup
+func transientFile(path string) bool {
+	name := filepath.Base(path)
+	return strings.HasPrefix(name, ".knot-stage-") || strings.HasSuffix(name, ".kra~")
+}
+
+// projectFiles returns the files under dir that aren't skipped,
+// relative to the project directory and sorted
+func projectFiles(fsys FileSystem, pi *ProjectInfo, dir string, skipped func(path string, isDir bool) bool) ([]string, error) {
+	entries, err := fsys.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if skipped(path, entry.IsDir()) {
+			continue
+		}
+		if entry.IsDir() {
+			files, err := projectFiles(fsys, pi, path, skipped)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, files...)
+		} else if entry.Type().IsRegular() {
+			rel, err := filepath.Rel(pi.ProjectDir, path)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, filepath.ToSlash(rel))
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// ArchiveProject writes a project to a .knotpack at dst and returns
+// its manifest
+func ArchiveProject(si *SystemInfo, name string, pi *ProjectInfo, dst string, exports bool) (PackManifest, error) {
+	contentDir, err := filepath.Rel(pi.ProjectDir, pi.ContentDir)
+	if err != nil {
+		return PackManifest{}, err
+	}
+	manifest := PackManifest{
+		Format:        packFormat,
+		Version:       packVersion,
+		Created:       time.Now().UTC(),
+		Name:          name,
+		ContentDir:    filepath.ToSlash(contentDir),
+		ContentName:   pi.ContentName,
+		ExportDirName: pi.ExportDirName,
+		TemplateName:  pi.TemplateName,
+		BatchNaming:   pi.BatchNaming,
+		Exports:       exports}
+
+	files, err := projectFiles(si.FS, pi, pi.ProjectDir, func(path string, isDir bool) bool {
+		return path == dst || packSkipped(si.FS, pi, path, isDir, exports)
+	})
+	if err != nil {
+		return PackManifest{}, err
+	}
+
+	root := pi.Root()
+	groups, err := GetBatchGroups(si.FS, &root)
+	if err != nil {
+		return PackManifest{}, err
+	}
+	var addGroups func(group *BatchGroup)
+	addGroups = func(group *BatchGroup) {
+		for i := range group.Groups {
+			manifest.Groups = append(manifest.Groups, group.Groups[i].Info.Group)
+			addGroups(&group.Groups[i])
+		}
+	}
+	addGroups(&groups)
+
+	if err = EnsureDir(si.FS, si.Actions, filepath.Dir(dst)); err != nil {
+		return PackManifest{}, err
+	}
+	// the pack is written as it is read, one file at a time
+	err = si.Actions.StreamFile(dst, func(w io.Writer) error {
+		archive := zip.NewWriter(w)
+		for _, file := range files {
+			content, err := si.FS.ReadFile(filepath.Join(pi.ProjectDir, filepath.FromSlash(file)))
+			if err != nil {
+				return err
+			}
+			writer, err := archive.Create(packFilesDir + file)
+			if err != nil {
+				return err
+			}
+			if _, err = writer.Write(content); err != nil {
+				return err
+			}
+
+			manifest.Files = append(manifest.Files, PackFile{
+				Path: file, Size: int64(len(content)), SHA256: fileHash(content)})
+		}
+
+		manifestBytes, err := json.MarshalIndent(manifest, "", "\t")
+		if err != nil {
+			return err
+		}
+		writer, err := archive.Create(packManifestName)
+		if err != nil {
+			return err
+		}
+		if _, err = writer.Write(manifestBytes); err != nil {
+			return err
+		}
+		return archive.Close()
+	})
+	if err != nil {
+		si.Actions.RemoveAll(dst)
+		return PackManifest{}, err
+	}
+	return manifest, nil
+}
+
+func packError(src string, format string, args ...any) error {
+	return &Error{Kind: ErrPackInvalid, Path: src, Cause: fmt.Errorf(format, args...)}
+}
+
+// localPackPath tells whether a path in a pack stays inside the
+// directory it is unpacked to
+func localPackPath(p string) bool {
+	if p == "" || strings.HasPrefix(p, "/") || strings.Contains(p, "\\") {
+		return false
+	}
+	clean := path.Clean(p)
+	return clean == p && clean != ".." && !strings.HasPrefix(clean, "../")
+}
+
+// readPack reads and verifies the .knotpack at src. It returns its
+// manifest and the content of its files, by path
+func readPack(fsys FileSystem, src string) (PackManifest, map[string][]byte, error) {
+	var manifest PackManifest
+
+	packBytes, err := fsys.ReadFile(src)
+	if err != nil {
+		return manifest, nil, err
+	}
+	archive, err := zip.NewReader(bytes.NewReader(packBytes), int64(len(packBytes)))
+	if err != nil {
+		return manifest, nil, packError(src, "not a zip file: %w", err)
+	}
+
+	contents := make(map[string][]byte)
+	manifestFound := false
+	for _, file := range archive.File {
+		if strings.HasSuffix(file.Name, "/") {
+			continue
+		}
+		if file.Name != packManifestName && !strings.HasPrefix(file.Name, packFilesDir) {
+			return manifest, nil, packError(src, "unexpected file <%s>", file.Name)
+		}
+
+		reader, err := file.Open()
+		if err != nil {
+			return manifest, nil, packError(src, "<%s>: %w", file.Name, err)
+		}
+		// an entry never yields more than the size it declares
+		content, err := io.ReadAll(io.LimitReader(reader, int64(file.UncompressedSize64)+1))
+		reader.Close()
+		if err != nil {
+			return manifest, nil, packError(src, "<%s>: %w", file.Name, err)
+		}
+		if uint64(len(content)) > file.UncompressedSize64 {
+			return manifest, nil, packError(src, "<%s> is larger than it declares", file.Name)
+		}
+
+		if file.Name == packManifestName {
+			if err = json.Unmarshal(content, &manifest); err != nil {
+				return manifest, nil, packError(src, "invalid manifest: %w", err)
+			}
+			manifestFound = true
+		} else {
+			contents[strings.TrimPrefix(file.Name, packFilesDir)] = content
+		}
+	}
+
+	switch {
+	case !manifestFound:
+		return manifest, nil, packError(src, "no %s", packManifestName)
+	case manifest.Format != packFormat:
+		return manifest, nil, packError(src, "not a %s", packFormat)
+	case manifest.Version > packVersion:
+		return manifest, nil, packError(src,
+			"made by a newer knot, version %d", manifest.Version)
+	case manifest.Name == "" || strings.ContainsAny(manifest.Name, "/\\"):
+		return manifest, nil, packError(src, "invalid project name <%s>", manifest.Name)
+	case manifest.ContentDir != "." && !localPackPath(manifest.ContentDir):
+		return manifest, nil, packError(src,
+			"content directory <%s> is outside the project", manifest.ContentDir)
+	}
+
+	for _, group := range manifest.Groups {
+		if !localPackPath(group) || !validGroup(group) {
+			return manifest, nil, packError(src, "invalid group <%s>", group)
+		}
+	}
+	listed := make(map[string]bool)
+	for _, file := range manifest.Files {
+		if !localPackPath(file.Path) {
+			return manifest, nil, packError(src, "<%s> is outside the project", file.Path)
+		}
+		if path.Base(file.Path) == packConfigName {
+			return manifest, nil, packError(src, "<%s> is a configuration file", file.Path)
+		}
+		content, ok := contents[file.Path]
+		if !ok {
+			return manifest, nil, packError(src, "<%s> is missing", file.Path)
+		}
+		if int64(len(content)) != file.Size || fileHash(content) != file.SHA256 {
+			return manifest, nil, packError(src, "<%s> doesn't match its hash", file.Path)
+		}
+		listed[file.Path] = true
+	}
+	for file := range contents {
+		if !listed[file] {
+			return manifest, nil, packError(src, "<%s> isn't in the manifest", file)
+		}
+	}
+	return manifest, contents, nil
+}
+
+// VerifyPack checks that every file of the .knotpack at src is the one
+// its manifest lists, and returns the manifest
+func VerifyPack(fsys FileSystem, src string) (PackManifest, error) {
+	manifest, _, err := readPack(fsys, src)
+	return manifest, err
+}
+
+// ImportPack verifies the .knotpack at src, unpacks it into dir and
+// registers the project under the name of dir. dir must not exist yet
+func ImportPack(si *SystemInfo, projects *Projects, src string, dir string) (ProjectInfo, error) {
+	manifest, contents, err := readPack(si.FS, src)
+	if err != nil {
+		return ProjectInfo{}, err
+	}
+
+	name := filepath.Base(dir)
+	if _, ok := (*projects)[name]; ok {
+		return ProjectInfo{}, &Error{Kind: ErrProjectExists, Project: name}
+	}
+	pi := ProjectInfo{
+		ProjectDir:    dir,
+		ContentDir:    filepath.Join(dir, filepath.FromSlash(manifest.ContentDir)),
+		ContentName:   manifest.ContentName,
+		ExportDirName: manifest.ExportDirName,
+		TemplateName:  manifest.TemplateName,
+		BatchNaming:   manifest.BatchNaming}
+
+	parent := filepath.Dir(dir)
+	if err = EnsureDir(si.FS, si.Actions, parent); err != nil {
+		return ProjectInfo{}, err
+	}
+	err = withTransaction(si, parent, func(tx *transaction) error {
+		stagedProject := tx.staged("project")
+		if err := si.Actions.MkdirAll(stagedProject); err != nil {
+			return err
+		}
+		for _, file := range manifest.Files {
+			staged := filepath.Join(stagedProject, filepath.FromSlash(file.Path))
+			if err := EnsureDir(si.FS, si.Actions, filepath.Dir(staged)); err != nil {
+				return err
+			}
+			if err := si.Actions.WriteFile(staged, contents[file.Path]); err != nil {
+				return err
+			}
+		}
+		if err := EnsureDir(si.FS, si.Actions,
+			filepath.Join(stagedProject, filepath.FromSlash(manifest.ContentDir))); err != nil {
+			return err
+		}
+		staged := pi
+		staged.ProjectDir = stagedProject
+		staged.ContentDir = filepath.Join(stagedProject, filepath.FromSlash(manifest.ContentDir))
+		for _, group := range manifest.Groups {
+			info := staged.InGroup(group)
+			if err := ensureGroup(si, &info); err != nil {
+				return err
+			}
+		}
+		if err := tx.commit("project", dir, ErrProjectExists); err != nil {
+			return err
+		}
+
+		(*projects)[name] = pi
+		if err := projects.Save(si, si.ProjectsFile); err != nil {
+			delete(*projects, name)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return ProjectInfo{}, err
+	}
+	return pi, nil
+}
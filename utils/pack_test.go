@@ -0,0 +1,188 @@
+package knot
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveAndImportProject(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+	exportDir := filepath.Join(GetBatchDir(&pi, 0), pi.ExportDirName)
+	mustMkdirAll(t, mem, exportDir)
+	mustWriteFile(t, mem, filepath.Join(exportDir, "page-0.png"), testPNG(t, 4, 3))
+
+	manifest, err := ArchiveProject(si, "notes", &pi, "/packs/notes.knotpack", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Files) != 1 || manifest.Files[0].Path != "content/notes-0/page-0.kra" {
+		t.Errorf("expected only the page in the pack, got %v", manifest.Files)
+	}
+	if _, err = VerifyPack(mem, "/packs/notes.knotpack"); err != nil {
+		t.Fatal(err)
+	}
+
+	projects := Projects{"notes": pi}
+	imported, err := ImportPack(si, &projects, "/packs/notes.knotpack", "/shared/notebook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.ContentDir != "/shared/notebook/content" || imported.ContentName != "notes" {
+		t.Errorf("expected the content in </shared/notebook/content>, got %+v", imported)
+	}
+	if registered, ok := projects["notebook"]; !ok || registered != imported {
+		t.Error("expected the imported project to be registered as notebook")
+	}
+	original, _ := mem.ReadFile(filepath.Join(GetBatchDir(&pi, 0), "page-0.kra"))
+	copied, _ := mem.ReadFile(filepath.Join(GetBatchDir(&imported, 0), "page-0.kra"))
+	if !bytes.Equal(original, copied) {
+		t.Error("expected the imported page to match the original")
+	}
+
+	_, err = ImportPack(si, &projects, "/packs/notes.knotpack", "/shared/notebook")
+	if !errors.Is(err, ErrProjectExists) {
+		t.Errorf("expected ErrProjectExists, got %v", err)
+	}
+}
+
+func TestArchiveSkipsPacks(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+	mustWriteFile(t, mem, filepath.Join(pi.ProjectDir, "old.knotpack"), []byte("pack"))
+	dst := filepath.Join(pi.ProjectDir, "notes.zip")
+	for i := 0; i < 2; i++ {
+		manifest, err := ArchiveProject(si, "notes", &pi, dst, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(manifest.Files) != 1 {
+			t.Errorf("expected the packs to be left out, got %v", manifest.Files)
+		}
+	}
+}
+
+func TestVerifyPackDetectsTampering(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+	if _, err := ArchiveProject(si, "notes", &pi, "/packs/notes.knotpack", false); err != nil {
+		t.Fatal(err)
+	}
+
+	packBytes, _ := mem.ReadFile("/packs/notes.knotpack")
+	archive, err := zip.NewReader(bytes.NewReader(packBytes), int64(len(packBytes)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tampered bytes.Buffer
+	writer := zip.NewWriter(&tampered)
+	for _, file := range archive.File {
+		if err = writer.Copy(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	extra, _ := writer.Create("files/../../evil")
+	extra.Write([]byte("evil"))
+	writer.Close()
+	mustWriteFile(t, mem, "/packs/tampered.knotpack", tampered.Bytes())
+
+	if _, err = VerifyPack(mem, "/packs/tampered.knotpack"); !errors.Is(err, ErrPackInvalid) {
+		t.Errorf("expected ErrPackInvalid, got %v", err)
+	}
+	projects := Projects{}
+	_, err = ImportPack(si, &projects, "/packs/tampered.knotpack", "/shared/notes")
+	if !errors.Is(err, ErrPackInvalid) {
+		t.Errorf("expected ErrPackInvalid, got %v", err)
+	}
+	assertNotExists(t, mem, "/shared/notes")
+}
+
+func TestArchiveLeavesOutConfig(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+	mustWriteFile(t, mem, GetProjectConfigFile(&pi), []byte(`(defhook "on-page-create" "rm")`))
+	unit := pi.InGroup("unit-2")
+	if err := MakeBatch(testTemplatePath, si, &unit, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	mustWriteFile(t, mem, GetGroupConfigFile(&unit), []byte(`(set KritaCommand "rm")`))
+
+	manifest, err := ArchiveProject(si, "notes", &pi, "/packs/notes.knotpack", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range manifest.Files {
+		if filepath.Base(file.Path) == "knot.zy" {
+			t.Errorf("expected no knot.zy in the pack, got <%s>", file.Path)
+		}
+	}
+
+	projects := Projects{}
+	imported, err := ImportPack(si, &projects, "/packs/notes.knotpack", "/shared/notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNotExists(t, mem, GetProjectConfigFile(&imported))
+	// the group is marked again, with an empty knot.zy
+	importedUnit := imported.InGroup("unit-2")
+	if marker, err := mem.ReadFile(GetGroupConfigFile(&importedUnit)); err != nil || len(marker) != 0 {
+		t.Errorf("expected an empty group marker, got %q, %v", marker, err)
+	}
+}
+
+func TestVerifyPackRejectsConfigAndOversizedEntries(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+	manifest, err := ArchiveProject(si, "notes", &pi, "/packs/notes.knotpack", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packBytes, _ := mem.ReadFile("/packs/notes.knotpack")
+	archive, err := zip.NewReader(bytes.NewReader(packBytes), int64(len(packBytes)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rewrite packs the files of the archive along with extra, listed in
+	// the manifest as they are
+	rewrite := func(name string, extra func(writer *zip.Writer) PackFile) {
+		var result bytes.Buffer
+		writer := zip.NewWriter(&result)
+		for _, file := range archive.File {
+			if file.Name == packManifestName {
+				continue
+			}
+			if err := writer.Copy(file); err != nil {
+				t.Fatal(err)
+			}
+		}
+		listed := manifest
+		listed.Files = append(append([]PackFile{}, manifest.Files...), extra(writer))
+		manifestBytes, _ := json.Marshal(listed)
+		entry, _ := writer.Create(packManifestName)
+		entry.Write(manifestBytes)
+		writer.Close()
+		mustWriteFile(t, mem, name, result.Bytes())
+	}
+	rewrite("/packs/config.knotpack", func(writer *zip.Writer) PackFile {
+		config := []byte(`(set KritaCommand "rm")`)
+		entry, _ := writer.Create("files/knot.zy")
+		entry.Write(config)
+		return PackFile{Path: "knot.zy", Size: int64(len(config)), SHA256: fileHash(config)}
+	})
+	rewrite("/packs/oversized.knotpack", func(writer *zip.Writer) PackFile {
+		data := []byte("much more than declared")
+		entry, err := writer.CreateRaw(&zip.FileHeader{Name: "files/big", Method: zip.Store,
+			CompressedSize64: uint64(len(data)), UncompressedSize64: 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Write(data)
+		return PackFile{Path: "big", Size: 4}
+	})
+
+	for _, name := range []string{"/packs/config.knotpack", "/packs/oversized.knotpack"} {
+		if _, err = VerifyPack(mem, name); !errors.Is(err, ErrPackInvalid) {
+			t.Errorf("<%s>: expected ErrPackInvalid, got %v", name, err)
+		}
+	}
+}
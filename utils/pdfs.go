@@ -2,17 +2,20 @@ package knot
 
 import (
 	"archive/zip"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 	// "github.com/signintech/gopdf"
 )
 
-func ExportToPNG(src, dst string) error {
-	srcStat, err := os.Stat(src)
+func ExportToPNG(si *SystemInfo, src, dst string) error {
+	srcStat, err := si.FS.Stat(src)
 	if err != nil {
 		return err
 	}
@@ -21,7 +24,7 @@ func ExportToPNG(src, dst string) error {
 	localLoc, _ := time.LoadLocation("Local")
 	dstModTime := time.Date(0, time.January, 0, 0, 0, 0, 0, localLoc)
 
-	dstStat, err := os.Stat(dst)
+	dstStat, err := si.FS.Stat(dst)
 	if err == nil {
 		dstModTime = dstStat.ModTime()
 	}
@@ -30,42 +33,138 @@ func ExportToPNG(src, dst string) error {
 		return nil
 	}
 
-	srcReader, err := zip.OpenReader(src)
+	pngBytes, err := ReadMergedImage(si.FS, src)
 	if err != nil {
 		return err
 	}
-	defer srcReader.Close()
+
+	return si.Actions.WriteFile(dst, pngBytes)
+}
+
+// ReadMergedImage returns the png that krita stores in a .kra file
+// with all its layers merged
+func ReadMergedImage(fsys FileSystem, src string) ([]byte, error) {
+	srcBytes, err := fsys.ReadFile(src)
+	if err != nil {
+		return nil, err
+	}
+	return MergedImage(srcBytes)
+}
+
+// MergedImage returns the merged image of the .kra file in kra
+func MergedImage(kra []byte) ([]byte, error) {
+	srcReader, err := zip.NewReader(bytes.NewReader(kra), int64(len(kra)))
+	if err != nil {
+		return nil, err
+	}
 
 	pngData, err := srcReader.Open("mergedimage.png")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer pngData.Close()
 
-	pngFile, err := os.Create(dst)
+	return io.ReadAll(pngData)
+}
+
+func ExportBatch(batchNumber int, pi *ProjectInfo, si *SystemInfo) (string, error) {
+	ci := ResolveConfigInfo(si, pi, batchNumber)
+	if err := ci.ConfigErr(); err != nil {
+		return "", err
+	}
+
+	exported, err := exportBatchPages(si, pi, batchNumber)
+	if err != nil {
+		return "", err
+	}
+
+	batchPath := GetBatchDir(pi, batchNumber)
+	outputPath := filepath.Join(
+		batchPath, fmt.Sprintf("%s.pdf", filepath.Base(batchPath)))
+
+	var outline []string
+	for _, i := range exported.markedIndices() {
+		outline = append(outline, fmt.Sprintf("--bookmark=%d:%s", i, exported.marked[i].outlineTitle()))
+		if ci.StampMarks {
+			outline = append(outline, fmt.Sprintf("--mark=%d", i))
+		}
+	}
+	if err = runExportScript(si, &ci, outputPath, outline, exported.pngs); err != nil {
+		return "", err
+	}
+	return outputPath, nil
+}
+
+// OpenExportedPage opens the pdf of a batch at one of its pages, as
+// the last export of the batch placed it
+func OpenExportedPage(si *SystemInfo, pi *ProjectInfo, batchNumber int, pageNumber int) error {
+	batchPath := GetBatchDir(pi, batchNumber)
+	pdf := filepath.Join(batchPath, fmt.Sprintf("%s.pdf", filepath.Base(batchPath)))
+	if _, err := si.FS.Stat(pdf); err != nil {
+		return &Error{Kind: ErrExportFailed, Path: pdf,
+			Cause: errors.New("the batch hasn't been exported")}
+	}
+
+	// the pdf has the exported pngs in the order exportBatchPages gives
+	exportPath := filepath.Join(batchPath, pi.ExportDirName)
+	exportDir, err := si.FS.ReadDir(exportPath)
 	if err != nil {
 		return err
 	}
-	defer pngFile.Close()
+	pageRegexp := GetPageRegexp(".png")
+	var pngNames []string
+	for _, item := range exportDir {
+		if !item.IsDir() && pageRegexp.MatchString(item.Name()) {
+			pngNames = append(pngNames, item.Name())
+		}
+	}
+	sort.Strings(pngNames)
 
-	_, err = io.Copy(pngFile, pngData)
-	return err
+	pngName := ChangeFileExt(GetPageName(pageNumber), "png")
+	for i, name := range pngNames {
+		if name == pngName {
+			return OpenFileAt(si, pdf, i+1, true)
+		}
+	}
+	return &Error{Kind: ErrPageNotFound, Path: filepath.Join(exportPath, pngName),
+		Cause: fmt.Errorf("page %d isn't in <%s>", pageNumber, pdf)}
 }
 
-func ExportBatch(batchNumber int, pi *ProjectInfo, si *SystemInfo) (string, error) {
-	batchPath := filepath.Join(
-		pi.ContentDir, GetBatchName(pi, batchNumber))
+// exportedBatch is a batch whose pages were exported to pngs
+type exportedBatch struct {
+	pngs []string
+	// marked are the marked pages, by their index in pngs
+	marked map[int]AnnotatedPage
+}
 
-	batchDir, err := os.ReadDir(batchPath)
+func (exported *exportedBatch) markedIndices() []int {
+	result := make([]int, 0, len(exported.marked))
+	for i := range exported.marked {
+		result = append(result, i)
+	}
+	sort.Ints(result)
+	return result
+}
+
+// exportBatchPages exports the pages of a batch that changed since
+// their last export to pngs, and returns all of its pngs in order
+func exportBatchPages(si *SystemInfo, pi *ProjectInfo, batchNumber int) (exportedBatch, error) {
+	exported := exportedBatch{marked: make(map[int]AnnotatedPage)}
+	batchPath := GetBatchDir(pi, batchNumber)
+
+	batchDir, err := si.FS.ReadDir(batchPath)
 	if err != nil {
-		return "", err
+		return exported, &Error{Kind: ErrBatchNotFound, Path: batchPath}
 	}
 
 	exportPath := filepath.Join(batchPath, pi.ExportDirName)
-	if EnsureDirExists(exportPath) != nil {
-		return "", err
+	if err = EnsureDir(si.FS, si.Actions, exportPath); err != nil {
+		return exported, err
 	}
 
+	// a dry run never writes the pages exported now
+	pngs := make(map[string]bool)
+
 	for _, item := range batchDir {
 		itemName := item.Name()
 		extension := filepath.Ext(itemName)
@@ -78,34 +177,148 @@ func ExportBatch(batchNumber int, pi *ProjectInfo, si *SystemInfo) (string, erro
 		dst := filepath.Join(exportPath,
 			ChangeFileExt(itemName, "png"))
 
-		ExportToPNG(src, dst)
+		if err = ExportToPNG(si, src, dst); err != nil {
+			return exported, &Error{Kind: ErrExportFailed, Path: src, Cause: err}
+		}
+		pngs[filepath.Base(dst)] = true
 	}
 
-	outputPath := filepath.Join(
-		batchPath, fmt.Sprintf("%s.pdf", filepath.Base(batchPath)))
+	exportDir, err := si.FS.ReadDir(exportPath)
+	if err != nil && !os.IsNotExist(err) {
+		return exported, err
+	}
+
+	pageRegexp := GetPageRegexp(".png")
+	for _, item := range exportDir {
+		itemName := item.Name()
+
+		if item.IsDir() || !pageRegexp.Match([]byte(itemName)) {
+			continue
+		}
+
+		pngs[itemName] = true
+	}
+
+	pngNames := make([]string, 0, len(pngs))
+	for pngName := range pngs {
+		pngNames = append(pngNames, pngName)
+	}
+	sort.Strings(pngNames)
+
+	annotations, err := GetAnnotations(si.FS, pi)
+	if err != nil {
+		si.Warn(err)
+	}
+	for i, pngName := range pngNames {
+		exported.pngs = append(exported.pngs, filepath.Join(exportPath, pngName))
+
+		var pageNumber int
+		if _, err = fmt.Sscanf(pngName, "page-%d.png", &pageNumber); err != nil {
+			continue
+		}
+		page := AnnotatedPage{Batch: batchNumber, Page: pageNumber,
+			Annotation: annotations.Get(batchNumber, pageNumber)}
+		if page.Marked() {
+			exported.marked[i] = page
+		}
+	}
+	return exported, nil
+}
 
+// runExportScript puts the pngs together into the pdf at outputPath,
+// with the given outline arguments
+func runExportScript(si *SystemInfo, ci *ConfigInfo, outputPath string, outline []string, pngs []string) error {
 	exportArgs := []string{
 		si.ExportScript,
 		"-o", outputPath,
-		"-q", fmt.Sprintf("%v", si.ExportQuality)}
+		"-q", fmt.Sprintf("%v", ci.ExportQuality)}
+	exportArgs = append(exportArgs, outline...)
+	exportArgs = append(exportArgs, pngs...)
 
-	exportDir, err := os.ReadDir(exportPath)
+	output, err := si.Actions.Run(
+		NewSimpleCommandRunner(si.PythonCommand), exportArgs)
 	if err != nil {
+		if output = strings.TrimSpace(output); output != "" {
+			err = fmt.Errorf("%w\n%s", err, output)
+		}
+		return &Error{Kind: ErrExportFailed, Path: outputPath, Cause: err}
+	}
+	return nil
+}
+
+// ExportProject exports every batch of a project to a single pdf with
+// a nested outline, and returns its path. Each batch keeps the quality
+// and markers its own configuration sets
+func ExportProject(pi *ProjectInfo, si *SystemInfo) (string, error) {
+	root := pi.Root()
+	ci := ResolveConfigInfo(si, &root, -1)
+	if err := ci.ConfigErr(); err != nil {
 		return "", err
 	}
+	outputPath := filepath.Join(
+		root.ProjectDir, fmt.Sprintf("%s.pdf", filepath.Base(root.ProjectDir)))
 
-	pageRegexp := GetPageRegexp(".png")
-	for _, item := range exportDir {
-		itemName := item.Name()
+	groups, err := GetBatchGroups(si.FS, &root)
+	if err != nil {
+		return "", err
+	}
 
-		if item.IsDir() || !pageRegexp.Match([]byte(itemName)) {
-			continue
+	var pngs, outline []string
+	var add func(group *BatchGroup, depth int) error
+	add = func(group *BatchGroup, depth int) error {
+		for _, batchNumber := range group.Batches {
+			batchCI := ResolveConfigInfo(si, &group.Info, batchNumber)
+			if err := batchCI.ConfigErr(); err != nil {
+				return err
+			}
+			exported, err := exportBatchPages(si, &group.Info, batchNumber)
+			if err != nil {
+				return err
+			}
+			if len(exported.pngs) == 0 {
+				continue
+			}
+			outline = append(outline, fmt.Sprintf("--outline=%d:%d:%s",
+				depth, len(pngs), GetBatchName(&group.Info, batchNumber)))
+			for _, i := range exported.markedIndices() {
+				outline = append(outline, fmt.Sprintf("--outline=%d:%d:%s",
+					depth+1, len(pngs)+i, exported.marked[i].outlineTitle()))
+				if batchCI.StampMarks {
+					outline = append(outline, fmt.Sprintf("--mark=%d", len(pngs)+i))
+				}
+			}
+			if batchCI.ExportQuality != ci.ExportQuality {
+				for i := range exported.pngs {
+					outline = append(outline, fmt.Sprintf("--quality=%d:%d", len(pngs)+i, batchCI.ExportQuality))
+				}
+			}
+			pngs = append(pngs, exported.pngs...)
 		}
 
-		exportArgs = append(exportArgs, filepath.Join(exportPath, itemName))
+		for i := range group.Groups {
+			nested := &group.Groups[i]
+			first, entry := len(pngs), len(outline)
+			if err := add(nested, depth+1); err != nil {
+				return err
+			}
+			if len(pngs) == first {
+				continue
+			}
+			heading := fmt.Sprintf("--outline=%d:%d:%s", depth, first, nested.Name())
+			outline = append(outline[:entry], append([]string{heading}, outline[entry:]...)...)
+		}
+		return nil
+	}
+	if err = add(&groups, 0); err != nil {
+		return "", err
 	}
 
-	cmd := exec.Command(si.PythonCommand, exportArgs...)
-
-	return outputPath, cmd.Run()
+	if len(pngs) == 0 {
+		return "", &Error{Kind: ErrExportFailed, Path: outputPath,
+			Cause: errors.New("the project has no pages")}
+	}
+	if err = runExportScript(si, &ci, outputPath, outline, pngs); err != nil {
+		return "", err
+	}
+	return outputPath, nil
 }
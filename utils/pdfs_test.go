@@ -0,0 +1,135 @@
+package knot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/png"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportToPNG(t *testing.T) {
+	si, mem := newTestSystemInfo(t)
+	mustMkdirAll(t, mem, "/batch/export")
+	mustWriteFile(t, mem, "/batch/page-0.kra", testKra(t, 5, 2))
+
+	if err := ExportToPNG(si, "/batch/page-0.kra", "/batch/export/page-0.png"); err != nil {
+		t.Fatal(err)
+	}
+
+	exported, err := mem.ReadFile("/batch/export/page-0.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(exported, testPNG(t, 5, 2)) {
+		t.Error("the exported png should be the merged image of the page")
+	}
+}
+
+func TestExportToPNGSkipsUpToDatePages(t *testing.T) {
+	si, mem := newTestSystemInfo(t)
+	mustMkdirAll(t, mem, "/batch/export")
+	mustWriteFile(t, mem, "/batch/page-0.kra", testKra(t, 5, 2))
+	mustWriteFile(t, mem, "/batch/export/page-0.png", []byte("newer"))
+
+	if err := ExportToPNG(si, "/batch/page-0.kra", "/batch/export/page-0.png"); err != nil {
+		t.Fatal(err)
+	}
+	if exported, _ := mem.ReadFile("/batch/export/page-0.png"); string(exported) != "newer" {
+		t.Error("a png newer than its page shouldn't be exported again")
+	}
+
+	mustWriteFile(t, mem, "/batch/page-0.kra", testKra(t, 5, 2))
+	if err := ExportToPNG(si, "/batch/page-0.kra", "/batch/export/page-0.png"); err != nil {
+		t.Fatal(err)
+	}
+	if exported, _ := mem.ReadFile("/batch/export/page-0.png"); string(exported) == "newer" {
+		t.Error("a png older than its page should be exported again")
+	}
+}
+
+func TestExportToPNGWithoutMergedImage(t *testing.T) {
+	si, mem := newTestSystemInfo(t)
+	mustMkdirAll(t, mem, "/batch")
+	mustWriteFile(t, mem, "/batch/page-0.kra", []byte("not a zip"))
+
+	if err := ExportToPNG(si, "/batch/page-0.kra", "/batch/page-0.png"); err == nil {
+		t.Error("exporting a page that isn't a .kra file should fail")
+	}
+	assertNotExists(t, mem, "/batch/page-0.png")
+}
+
+func TestExportBatchFailsOnBrokenPage(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+	mustWriteFile(t, mem, filepath.Join(GetBatchDir(&pi, 0), "page-2.kra"), []byte("not a zip"))
+
+	if _, err := ExportBatch(0, &pi, si); !errors.Is(err, ErrExportFailed) {
+		t.Errorf("expected ErrExportFailed for a broken page, got %v", err)
+	}
+}
+
+func TestResizePNG(t *testing.T) {
+	si, mem := newTestSystemInfo(t)
+	mustMkdirAll(t, mem, "/export")
+	mustWriteFile(t, mem, "/export/page-0.png", testPNG(t, 8, 4))
+
+	if err := ResizePNG(si, "/export/page-0.png", "/export/small.png", 2); err != nil {
+		t.Fatal(err)
+	}
+	resized, err := mem.ReadFile("/export/small.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(resized))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size.X != 2 || size.Y != 1 {
+		t.Errorf("expected a 2x1 copy, got %v", size)
+	}
+}
+
+func TestOpenExportedPage(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+	projects := Projects{"notes": pi}
+	if err := projects.Save(si, si.ProjectsFile); err != nil {
+		t.Fatal(err)
+	}
+	batchDir := GetBatchDir(&pi, 0)
+	mustMkdirAll(t, mem, filepath.Join(batchDir, "export"))
+	for _, page := range []string{"page-0.png", "page-2.png", "page-10.png"} {
+		mustWriteFile(t, mem, filepath.Join(batchDir, "export", page), testPNG(t, 4, 3))
+	}
+
+	if err := OpenExportedPage(si, &pi, 0, 2); !errors.Is(err, ErrExportFailed) {
+		t.Errorf("expected ErrExportFailed before the batch is exported, got %v", err)
+	}
+	mustWriteFile(t, mem, filepath.Join(batchDir, "notes-0.pdf"), []byte("pdf"))
+
+	si.configs = newConfigCache()
+	configFiles := resolvedConfigFiles(si, &pi, 0)
+	ci := LoadLayeredConfigInfo()
+	ci.PDFReader = NewTemplateCommandRunner("zathura", "--page={page}")
+	si.configs.entries[strings.Join(configFiles, "\x00")] = cachedConfig{
+		si: si, modTimes: make([]time.Time, len(configFiles)), ci: ci}
+
+	var started []string
+	si.Actions = &RecordingActions{Actions: NoActions{}, Record: func(action string) {
+		started = append(started, action)
+	}}
+	// the pngs are in the pdf in the order of their names
+	if err := OpenExportedPage(si, &pi, 0, 2); err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("start <zathura --page=3 %s>", filepath.Join(batchDir, "notes-0.pdf"))
+	if len(started) != 1 || started[0] != expected {
+		t.Errorf("expected %s, got %v", expected, started)
+	}
+
+	if err := OpenExportedPage(si, &pi, 0, 1); !errors.Is(err, ErrPageNotFound) {
+		t.Errorf("expected ErrPageNotFound for a page that wasn't exported, got %v", err)
+	}
+}
@@ -1,22 +1,33 @@
 package knot
 
 import (
+	"bytes"
 	"errors"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 )
 
 type PlatformDirs struct {
 	ConfigDir string
+	StateDir  string
+	DataDir   string
 	TempDir   string
 	BinDir    string
+	// where older versions of knot kept their files
+	LegacyConfigDir string
+	LegacyTempDir   string
 }
 
 type Platform interface {
 	GetPlatformDirs() (PlatformDirs, error)
 	GetPythonCommand() (string, error)
+	ProcessStartTime(pid int) (uint64, bool)
+	SessionLeader() (int, bool)
 }
 
 type Linux struct{}
@@ -27,13 +38,15 @@ func (linux Linux) GetPlatformDirs() (PlatformDirs, error) {
 		return PlatformDirs{}, err
 	}
 
-	configDir := filepath.Join(homeDir, ".config")
-	tempDir := "/tmp"
+	// see the XDG base directory specification
+	configDir := xdgDir("XDG_CONFIG_HOME", filepath.Join(homeDir, ".config"))
+	stateDir := xdgDir("XDG_STATE_HOME", filepath.Join(homeDir, ".local/state"))
+	dataDir := xdgDir("XDG_DATA_HOME", filepath.Join(homeDir, ".local/share"))
+	tempDir := xdgDir("XDG_RUNTIME_DIR", os.TempDir())
 
 	binDir := filepath.Join(homeDir, ".local/bin")
 	preferredBinDir := filepath.Join(homeDir, "bin")
-	// we prefer $HOME/bin to $HOME/.local/bin, so if
-	// it's in $PATH we will change binDir to that
+	// $HOME/bin is preferred if it is in $PATH
 	path := os.Getenv("PATH")
 	pathElements := strings.Split(path, ":")
 	for _, pathElement := range pathElements {
@@ -45,9 +58,22 @@ func (linux Linux) GetPlatformDirs() (PlatformDirs, error) {
 	}
 
 	return PlatformDirs{
-		ConfigDir: configDir,
-		TempDir:   tempDir,
-		BinDir:    binDir}, nil
+		ConfigDir:       configDir,
+		StateDir:        stateDir,
+		DataDir:         dataDir,
+		TempDir:         tempDir,
+		BinDir:          binDir,
+		LegacyConfigDir: filepath.Join(homeDir, ".config"),
+		LegacyTempDir:   "/tmp"}, nil
+}
+
+// xdgDir returns the absolute directory in env, or fallback
+func xdgDir(env string, defaultDir string) string {
+	dir := os.Getenv(env)
+	if dir == "" || !filepath.IsAbs(dir) {
+		return defaultDir
+	}
+	return dir
 }
 
 func (linux Linux) GetPythonCommand() (string, error) {
@@ -77,3 +103,48 @@ func (linux Linux) GetPythonCommand() (string, error) {
 
 	return string(python), nil
 }
+
+func (linux Linux) ProcessStartTime(pid int) (uint64, bool) {
+	if pid <= 0 {
+		return 0, false
+	}
+	fields, ok := procStat(strconv.Itoa(pid))
+	if !ok {
+		return 0, false
+	}
+	// the start time is the 22nd field, in clock ticks since boot
+	started, err := strconv.ParseUint(fields[19], 10, 64)
+	return started, err == nil
+}
+
+func (linux Linux) SessionLeader() (int, bool) {
+	fields, ok := procStat("self")
+	if !ok {
+		return 0, false
+	}
+	// the session id is the 6th field
+	sid, err := strconv.Atoi(fields[3])
+	return sid, err == nil && sid > 0
+}
+
+// procStat returns the fields of /proc/<pid>/stat that follow the name
+// of the process, which is in parentheses and may contain spaces
+func procStat(pid string) ([]string, bool) {
+	stat, err := os.ReadFile(filepath.Join("/proc", pid, "stat"))
+	if err != nil {
+		return nil, false
+	}
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 {
+		return nil, false
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	return fields, len(fields) >= 20
+}
+
+// OwnedByCurrentUser tells whether a file belongs to the user running
+// knot, which files without an owner do
+func OwnedByCurrentUser(info fs.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return !ok || int(stat.Uid) == os.Getuid()
+}
@@ -2,11 +2,12 @@ package knot
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"strings"
 )
 
 type ProjectInfo struct {
@@ -15,30 +16,127 @@ type ProjectInfo struct {
 	ContentName   string
 	ExportDirName string
 	TemplateName  string
+	// BatchNaming is the naming scheme of the batches, numbered if empty
+	BatchNaming string `json:",omitempty"`
+	// Group is the path of a group of batches in the content directory,
+	// which ContentDir then points into
+	Group string `json:"-"`
+}
+
+// Root returns the project that pi is a group of, or pi itself
+func (pi *ProjectInfo) Root() ProjectInfo {
+	result := *pi
+	if pi.Group != "" {
+		result.ContentDir = strings.TrimSuffix(
+			pi.ContentDir, string(filepath.Separator)+filepath.FromSlash(pi.Group))
+		result.Group = ""
+	}
+	return result
+}
+
+// InGroup returns the group of the project at the given path, relative
+// to its content directory. An empty path is the project itself
+func (pi *ProjectInfo) InGroup(group string) ProjectInfo {
+	result := pi.Root()
+	if group = path.Clean(group); group != "." {
+		result.ContentDir = filepath.Join(result.ContentDir, filepath.FromSlash(group))
+		result.Group = group
+	}
+	return result
+}
+
+// groups returns the groups pi is in, from the outermost to its own
+func (pi *ProjectInfo) groups() []ProjectInfo {
+	if pi.Group == "" {
+		return nil
+	}
+	names := strings.Split(pi.Group, "/")
+	result := make([]ProjectInfo, len(names))
+	for i := range names {
+		result[i] = pi.InGroup(path.Join(names[:i+1]...))
+	}
+	return result
+}
+
+// validGroup tells whether group is a relative path through the
+// groups of a project, which are never hidden
+func validGroup(group string) bool {
+	if group = path.Clean(group); group == "." {
+		return true
+	}
+	if path.IsAbs(group) {
+		return false
+	}
+	for _, name := range strings.Split(group, "/") {
+		if strings.HasPrefix(name, ".") {
+			return false
+		}
+	}
+	return true
+}
+
+// PathGroup returns the innermost group of the project that contains
+// dir. Only directories marked with a knot.zy are groups
+func PathGroup(fsys FileSystem, pi *ProjectInfo, dir string) string {
+	root := pi.Root()
+	rel, err := filepath.Rel(root.ContentDir, dir)
+	if err != nil || rel == "." || outsideDir(rel) {
+		return ""
+	}
+
+	var group []string
+	for _, name := range strings.Split(filepath.ToSlash(rel), "/") {
+		if _, isBatch := ParseBatchName(&root, name); isBatch || strings.HasPrefix(name, ".") {
+			break
+		}
+		info := root.InGroup(path.Join(append(group, name)...))
+		if !isGroup(fsys, &info) {
+			break
+		}
+		group = append(group, name)
+	}
+	return strings.Join(group, "/")
+}
+
+// isGroup tells whether the directory of a group has the knot.zy that
+// marks it as one
+func isGroup(fsys FileSystem, pi *ProjectInfo) bool {
+	stat, err := fsys.Stat(GetGroupConfigFile(pi))
+	return err == nil && !stat.IsDir()
 }
 
 type Projects map[string]ProjectInfo
 
-func (projects *Projects) Save(file string) error {
-	destination, err := os.Create(file)
+func (projects *Projects) Save(si *SystemInfo, file string) error {
+	infoAsBytes, err := json.MarshalIndent(*projects, "", "\t")
 	if err != nil {
 		return err
 	}
-	defer destination.Close()
 
-	infoAsBytes, err := json.MarshalIndent(*projects, "", "\t")
-	if err != nil {
+	if err = EnsureDir(si.FS, si.Actions, filepath.Dir(file)); err != nil {
 		return err
 	}
+	return si.Actions.WriteFile(file, infoAsBytes)
+}
 
-	_, err = destination.Write(infoAsBytes)
-	return err
+// Deregister removes a project from the list, given its name or the
+// path to its directory. It returns the removed project, if any
+func (projects *Projects) Deregister(project string) (string, ProjectInfo, bool) {
+	projectName := filepath.Base(project)
+	projectInfo, ok := (*projects)[projectName]
+	if ok {
+		delete(*projects, projectName)
+	}
+	return projectName, projectInfo, ok
 }
 
-func GetProjects(file string) (Projects, error) {
+func GetProjects(fsys FileSystem, file string) (Projects, error) {
 	var result Projects
 
-	fileBytes, err := os.ReadFile(file)
+	fileBytes, err := fsys.ReadFile(file)
+	if os.IsNotExist(err) { // nothing has been registered yet
+		return make(Projects), nil
+	}
 	if err != nil {
 		return result, err
 	}
@@ -47,45 +145,64 @@ func GetProjects(file string) (Projects, error) {
 	return result, err
 }
 
-func GetExistingProjectInfo(file string, projectName string) (ProjectInfo, error) {
-	projects, err := GetProjects(file)
+func GetExistingProjectInfo(fsys FileSystem, file string, projectName string) (ProjectInfo, error) {
+	projects, err := GetProjects(fsys, file)
 	if err != nil {
 		return ProjectInfo{}, err
 	}
 
 	result, ok := projects[projectName]
-	if ok {
-		err = nil
-	} else {
-		err = errors.New(fmt.Sprintf(
-			"no project called <%s> in project list", projectName))
+	if !ok {
+		return result, &Error{Kind: ErrProjectNotFound, Project: projectName}
 	}
 
-	return result, err
+	return result, nil
 }
 
-func GetProjectInfo(flags *Flags, si *SystemInfo, projects *Projects) (ProjectInfo, error) {
-	projectName := flags.InitDirName
-	if projectName == "" { // see if $PWD is inside a project
-		projectsByDir := ArrangeProjectsByDir(projects)
-		return FindFirstParentProjectInfo(
-			si.KnotWD, projects, &projectsByDir)
-	}
-
-	projectDir := filepath.Join(si.KnotWD, projectName)
+// ProjectOptions describe the layout of a new project. Empty fields
+// get their default value
+type ProjectOptions struct {
+	// ContentDirName is the directory of the batches in the project
+	ContentDirName string
+	// ContentName names the batches, after the project by default
+	ContentName   string
+	ExportDirName string
+	TemplateName  string
+	// Git makes the project a git repository
+	Git bool
+	// BatchNaming is the naming scheme of the batches
+	BatchNaming string
+}
 
-	contentDir := filepath.Join(projectDir, flags.ContentDirName)
-	contentName := flags.ContentName
+// NewProjectInfo describes a new project in projectDir
+func NewProjectInfo(projectDir string, opts *ProjectOptions) ProjectInfo {
+	contentName := opts.ContentName
 	if contentName == "" {
-		contentName = projectName
+		contentName = filepath.Base(projectDir)
+	}
+	exportDirName := opts.ExportDirName
+	if exportDirName == "" {
+		exportDirName = "export"
+	}
+	templateName := opts.TemplateName
+	if templateName == "" {
+		templateName = "default"
 	}
 
 	return ProjectInfo{
 		ProjectDir:    projectDir,
-		ContentDir:    contentDir,
+		ContentDir:    filepath.Join(projectDir, opts.ContentDirName),
 		ContentName:   contentName,
-		ExportDirName: flags.ExportDirName,
-		TemplateName:  flags.TemplateName}, nil
+		ExportDirName: exportDirName,
+		TemplateName:  templateName,
+		BatchNaming:   opts.BatchNaming}
+}
+
+// GetProjectInfo returns the project that contains the knot working
+// directory
+func GetProjectInfo(si *SystemInfo, projects *Projects) (ProjectInfo, error) {
+	projectsByDir := ArrangeProjectsByDir(projects)
+	return FindFirstParentProjectInfo(si.FS, si.KnotWD, projects, &projectsByDir)
 }
 
 func GetContentRegexp(name string) *regexp.Regexp {
@@ -94,14 +211,25 @@ func GetContentRegexp(name string) *regexp.Regexp {
 	return result
 }
 
+// GetBatchRegexp matches the names of the batch directories of a
+// project, in its naming scheme
+func GetBatchRegexp(pi *ProjectInfo) *regexp.Regexp {
+	pattern, ok := batchLabelPatterns[pi.BatchNaming]
+	if !ok {
+		pattern = batchLabelPatterns[BatchNumbered]
+	}
+	return regexp.MustCompile(fmt.Sprintf(
+		"^%s-%s$", regexp.QuoteMeta(pi.ContentName), pattern))
+}
+
 func GetPageRegexp(extension string) *regexp.Regexp {
 	result, _ := regexp.Compile(fmt.Sprintf(
 		"^page-[0-9]+%s$", extension))
 	return result
 }
 
-func NumberOfMatches(dirPath string, re *regexp.Regexp) (int, error) {
-	dir, err := os.ReadDir(dirPath)
+func NumberOfMatches(fsys FileSystem, dirPath string, re *regexp.Regexp) (int, error) {
+	dir, err := fsys.ReadDir(dirPath)
 	if err != nil {
 		return 0, err
 	}
@@ -123,15 +251,16 @@ func ArrangeProjectsByDir(projects *Projects) map[string]string {
 	return result
 }
 
-func FindFirstParentProjectInfo(wd string, projects *Projects, projectsByDir *map[string]string) (ProjectInfo, error) {
-	if projectName, ok := (*projectsByDir)[wd]; ok {
-		result, _ := (*projects)[projectName]
-		return result, nil
-	}
-	wdParent := filepath.Dir(wd)
-	if wd == wdParent {
-		return ProjectInfo{}, errors.New(
-			"working directory is not part of a project")
+// FindFirstParentProjectInfo returns the registered project that
+// contains wd, in the innermost group of it that contains wd
+func FindFirstParentProjectInfo(fsys FileSystem, wd string, projects *Projects, projectsByDir *map[string]string) (ProjectInfo, error) {
+	for dir := wd; ; dir = filepath.Dir(dir) {
+		if projectName, ok := (*projectsByDir)[dir]; ok {
+			pi := (*projects)[projectName]
+			return pi.InGroup(PathGroup(fsys, &pi, wd)), nil
+		}
+		if dir == filepath.Dir(dir) {
+			return ProjectInfo{}, &Error{Kind: ErrNotInProject, Path: wd}
+		}
 	}
-	return FindFirstParentProjectInfo(wdParent, projects, projectsByDir)
 }
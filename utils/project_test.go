@@ -0,0 +1,149 @@
+package knot
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestGetProjectsWithoutFile(t *testing.T) {
+	si, _ := newTestSystemInfo(t)
+
+	projects, err := GetProjects(si.FS, si.ProjectsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 0 {
+		t.Errorf("expected no projects, got %v", projects)
+	}
+}
+
+func TestDeregister(t *testing.T) {
+	si, _ := newTestSystemInfo(t)
+	projects := Projects{
+		"notes":  testProjectInfo("notes"),
+		"sketch": testProjectInfo("sketch")}
+	if err := projects.Save(si, si.ProjectsFile); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := GetProjects(si.FS, si.ProjectsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, pi, ok := loaded.Deregister("/projects/notes")
+	if !ok || name != "notes" || pi.ProjectDir != "/projects/notes" {
+		t.Errorf("deregistered <%s> in <%s>, %v", name, pi.ProjectDir, ok)
+	}
+	if _, _, ok = loaded.Deregister("missing"); ok {
+		t.Error("deregistering an unknown project should do nothing")
+	}
+	if err = loaded.Save(si, si.ProjectsFile); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := GetProjects(si.FS, si.ProjectsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok = reloaded["notes"]; ok || len(reloaded) != 1 {
+		t.Errorf("expected only sketch to be left, got %v", reloaded)
+	}
+}
+
+func TestFindFirstParentProjectInfo(t *testing.T) {
+	mem := NewMemFileSystem()
+	projects := Projects{"notes": testProjectInfo("notes")}
+	projectsByDir := ArrangeProjectsByDir(&projects)
+
+	for _, wd := range []string{"/projects/notes", "/projects/notes/content/notes-3"} {
+		pi, err := FindFirstParentProjectInfo(mem, wd, &projects, &projectsByDir)
+		if err != nil {
+			t.Errorf("<%s>: %v", wd, err)
+		} else if pi.ProjectDir != "/projects/notes" {
+			t.Errorf("<%s>: found <%s>", wd, pi.ProjectDir)
+		}
+	}
+
+	if _, err := FindFirstParentProjectInfo(mem, "/projects", &projects, &projectsByDir); err == nil {
+		t.Error("a directory outside every project should not resolve")
+	}
+}
+
+func TestKnotWorkingDirectory(t *testing.T) {
+	si, mem := newTestSystemInfo(t)
+
+	// with only the global state, it is used by every session
+	mustWriteFile(t, mem, si.TempConfigFile, []byte(`{"KnotWD": "/projects/sketch"}`))
+	tci, err := LoadTempConfigInfo(si.FS, si.SessionFile, si.SessionStarted, si.TempConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tci.KnotWD != "/projects/sketch" {
+		t.Errorf("expected the global working directory, got <%s>", tci.KnotWD)
+	}
+
+	if err = SetTempKnotWD(si, "/projects/notes/content"); err != nil {
+		t.Fatal(err)
+	}
+	assertExists(t, mem, si.SessionFile)
+
+	// another shell changes the global state only
+	mustWriteFile(t, mem, si.TempConfigFile, []byte(`{"KnotWD": "/elsewhere"}`))
+
+	tci, err = LoadTempConfigInfo(si.FS, si.SessionFile, si.SessionStarted, si.TempConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tci.KnotWD != "/projects/notes/content" {
+		t.Errorf("expected the session's working directory, got <%s>", tci.KnotWD)
+	}
+
+	// a later shell with the same id doesn't inherit the session
+	stale, err := LoadTempConfigInfo(si.FS, si.SessionFile, si.SessionStarted+1, si.TempConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stale.KnotWD != "/elsewhere" {
+		t.Errorf("expected a stale session to be ignored, got <%s>", stale.KnotWD)
+	}
+
+	si.TempConfigInfo = tci
+	projects := Projects{"notes": testProjectInfo("notes")}
+	pi, err := GetProjectInfo(si, &projects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pi.ProjectDir != "/projects/notes" {
+		t.Errorf("expected the project containing the working directory, got <%s>", pi.ProjectDir)
+	}
+}
+
+func TestFindFirstParentProjectInfoInGroup(t *testing.T) {
+	mem := NewMemFileSystem()
+	projects := Projects{"notes": testProjectInfo("notes")}
+	projectsByDir := ArrangeProjectsByDir(&projects)
+	for _, group := range []string{"unit-2", "unit-2/part-1"} {
+		dir := filepath.Join("/projects/notes/content", group)
+		mustMkdirAll(t, mem, dir)
+		mustWriteFile(t, mem, filepath.Join(dir, "knot.zy"), nil)
+	}
+
+	for wd, group := range map[string]string{
+		"/projects/notes/content/unit-2":                "unit-2",
+		"/projects/notes/content/unit-2/part-1/notes-3": "unit-2/part-1",
+		"/projects/notes/content/unit-2/drafts/notes-3": "unit-2",
+		"/projects/notes/content/notes-3/export":        "",
+		"/projects/notes/content/.knot-stage-1":         ""} {
+		pi, err := FindFirstParentProjectInfo(mem, wd, &projects, &projectsByDir)
+		if err != nil {
+			t.Fatalf("<%s>: %v", wd, err)
+		}
+		if pi.Group != group {
+			t.Errorf("<%s>: expected group <%s>, got <%s>", wd, group, pi.Group)
+		}
+		if root := pi.Root(); root != projects["notes"] {
+			t.Errorf("<%s>: expected the root of the group to be the project, got %+v", wd, root)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package knot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// SessionEnv identifies a shell session, see ShellInit
+const SessionEnv = "KNOT_SESSION"
+
+// Session is the knot working directory of a single shell
+type Session struct {
+	TempConfigInfo
+	Key     string
+	PID     int
+	Started uint64
+	Updated time.Time
+}
+
+var sessionKeyRegexp = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// GetSessionKey returns the key of the current shell session, and the
+// process id and start time of the shell
+func GetSessionKey(platform Platform) (string, int, uint64) {
+	// unlike the parent, the leader is the same in $(...) subshells
+	leader, ok := platform.SessionLeader()
+	if !ok {
+		leader = os.Getppid()
+	}
+
+	key := fmt.Sprintf("pid-%d", leader)
+	pid := leader
+	if session := os.Getenv(SessionEnv); session != "" {
+		key = sessionKeyRegexp.ReplaceAllString(session, "_")
+		if sessionPID, err := strconv.Atoi(session); err == nil {
+			pid = sessionPID
+		}
+	}
+
+	started, _ := platform.ProcessStartTime(pid)
+	return key, pid, started
+}
+
+func GetSessionFile(sessionsDir string, key string) string {
+	return filepath.Join(sessionsDir, fmt.Sprintf("%s.json", key))
+}
+
+func ReadSession(fsys FileSystem, file string) (Session, error) {
+	var session Session
+
+	sessionBytes, err := fsys.ReadFile(file)
+	if err != nil {
+		return session, err
+	}
+
+	err = json.Unmarshal(sessionBytes, &session)
+	return session, err
+}
+
+// WriteSession records tci as the state of the current shell session
+func WriteSession(si *SystemInfo, tci *TempConfigInfo) error {
+	if err := EnsureDir(si.FS, si.Actions, si.SessionsDir); err != nil {
+		return err
+	}
+
+	sessionBytes, err := json.MarshalIndent(Session{
+		TempConfigInfo: *tci,
+		Key:            si.SessionKey,
+		PID:            si.SessionPID,
+		Started:        si.SessionStarted,
+		Updated:        time.Now()}, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return si.Actions.WriteFile(si.SessionFile, sessionBytes)
+}
+
+// ListSessions returns the sessions of running shells, most recent
+// first
+func ListSessions(si *SystemInfo, platform Platform) ([]Session, error) {
+	sessions, err := pruneSessions(si.FS, si.Actions, si.SessionsDir, platform)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Updated.After(sessions[j].Updated)
+	})
+	return sessions, nil
+}
+
+// pruneSessions removes the sessions in dir whose shell has exited and
+// returns the others
+func pruneSessions(fsys FileSystem, actions Actions, dir string, platform Platform) ([]Session, error) {
+	entries, err := fsys.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]Session, 0, len(entries))
+	for _, item := range entries {
+		if item.IsDir() || filepath.Ext(item.Name()) != ".json" {
+			continue
+		}
+		file := filepath.Join(dir, item.Name())
+
+		session, err := ReadSession(fsys, file)
+		if err != nil || !isSessionAlive(platform, &session) {
+			if err = actions.RemoveAll(file); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// isSessionAlive tells whether the shell of session is still running,
+// and isn't another process that has since got its id
+func isSessionAlive(platform Platform, session *Session) bool {
+	started, ok := platform.ProcessStartTime(session.PID)
+	return ok && started == session.Started
+}
+
+func PrintSessions(w io.Writer, si *SystemInfo, sessions []Session, projects *Projects) error {
+	projectsByDir := ArrangeProjectsByDir(projects)
+
+	table := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(table, "session\tpid\tproject\tworking directory")
+	for _, session := range sessions {
+		projectName := "-"
+		pi, err := FindFirstParentProjectInfo(
+			si.FS, session.KnotWD, projects, &projectsByDir)
+		if err == nil {
+			projectName = projectsByDir[pi.ProjectDir]
+		}
+
+		current := ""
+		if session.Key == si.SessionKey {
+			current = " (current)"
+		}
+
+		fmt.Fprintf(table, "%s%s\t%d\t%s\t%s\n",
+			session.Key, current, session.PID, projectName, session.KnotWD)
+	}
+	return table.Flush()
+}
+
+// ShellInit returns the snippet that gives a shell a knot session of
+// its own. It is meant to be evaluated in the shell's rc file
+func ShellInit(shell string) (string, error) {
+	switch shell {
+	case "bash", "zsh", "sh", "":
+		return fmt.Sprintf("export %s=$$\n", SessionEnv), nil
+	case "fish":
+		return fmt.Sprintf("set -gx %s $fish_pid\n", SessionEnv), nil
+	default:
+		return "", UsageError("unsupported shell <%s>", shell)
+	}
+}
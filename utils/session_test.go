@@ -0,0 +1,52 @@
+package knot
+
+import "testing"
+
+// testPlatform runs the processes in started, which maps their ids to
+// their start times
+type testPlatform struct {
+	started map[int]uint64
+}
+
+func (platform testPlatform) GetPlatformDirs() (PlatformDirs, error) {
+	return PlatformDirs{}, nil
+}
+
+func (platform testPlatform) GetPythonCommand() (string, error) {
+	return "python3", nil
+}
+
+func (platform testPlatform) ProcessStartTime(pid int) (uint64, bool) {
+	started, ok := platform.started[pid]
+	return started, ok
+}
+
+func (platform testPlatform) SessionLeader() (int, bool) {
+	return 0, false
+}
+
+func TestListSessions(t *testing.T) {
+	si, mem := newTestSystemInfo(t)
+
+	for _, session := range []Session{
+		{Key: "running", PID: 10, Started: 100},
+		{Key: "exited", PID: 12, Started: 120},
+		{Key: "recycled", PID: 13, Started: 130}} {
+		si.SessionFile = GetSessionFile(si.SessionsDir, session.Key)
+		si.SessionKey, si.SessionPID, si.SessionStarted = session.Key, session.PID, session.Started
+		if err := WriteSession(si, &TempConfigInfo{KnotWD: "/projects"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	platform := testPlatform{started: map[int]uint64{10: 100, 13: 999}}
+	sessions, err := ListSessions(si, platform)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].Key != "running" {
+		t.Fatalf("expected the sessions of running shells only, got %+v", sessions)
+	}
+	assertNotExists(t, mem, GetSessionFile(si.SessionsDir, "exited"))
+	assertNotExists(t, mem, GetSessionFile(si.SessionsDir, "recycled"))
+}
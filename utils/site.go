@@ -0,0 +1,292 @@
+package knot
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type sitePage struct {
+	Title string
+	Image string
+	Prev  string
+	Next  string
+	Up    string
+}
+
+type siteBatch struct {
+	// Name is the directory of the batch in the site, inside those of
+	// its groups
+	Name  string
+	Title string
+	PDF   string
+	// Root leads from the batch back to the index of the site
+	Root  string
+	Pages []sitePage
+}
+
+type siteProject struct {
+	Title   string
+	Batches []siteBatch
+}
+
+const siteStyle = `
+body { font-family: sans-serif; margin: 0 auto; max-width: 60em; padding: 1em; }
+nav { display: flex; justify-content: space-between; margin: 1em 0; }
+img { display: block; max-width: 100%; height: auto; margin: 0 auto; border: 1px solid #ccc; }
+ul.pages { list-style: none; padding: 0; display: grid; grid-template-columns: repeat(auto-fill, minmax(12em, 1fr)); gap: 1em; }
+`
+
+var siteTemplates = template.Must(template.New("site").Parse(`
+{{define "head"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.}}</title>
+<link rel="stylesheet" href="style.css">
+</head>
+<body>
+{{end}}
+{{define "project"}}{{template "head" .Title}}<h1>{{.Title}}</h1>
+<ul>
+{{range .Batches}}<li><a href="{{.Name}}/index.html">{{.Title}}</a> ({{len .Pages}} pages){{if .PDF}} &middot; <a href="{{.Name}}/{{.PDF}}">pdf</a>{{end}}</li>
+{{end}}</ul>
+</body>
+</html>
+{{end}}
+{{define "batch"}}{{template "head" .Title}}<nav><a href="{{.Root}}index.html">up</a></nav>
+<h1>{{.Title}}</h1>
+{{if .PDF}}<p><a href="{{.PDF}}">download pdf</a></p>{{end}}
+<ul class="pages">
+{{range .Pages}}<li><a href="{{.Title}}.html"><img src="{{.Image}}" alt="{{.Title}}" loading="lazy"></a></li>
+{{end}}</ul>
+</body>
+</html>
+{{end}}
+{{define "page"}}{{template "head" .Title}}<nav>
+<span>{{if .Prev}}<a href="{{.Prev}}">prev</a>{{end}}</span>
+<a href="{{.Up}}">up</a>
+<span>{{if .Next}}<a href="{{.Next}}">next</a>{{end}}</span>
+</nav>
+<img src="{{.Image}}" alt="{{.Title}}">
+</body>
+</html>
+{{end}}
+`))
+
+// ExportSite writes the project as a static html site into siteDir
+// and returns the path to its index. The batches of groups go in the
+// directories of their groups
+func ExportSite(siteDir string, pi *ProjectInfo, si *SystemInfo) (string, error) {
+	groups, err := GetBatchGroups(si.FS, pi)
+	if err != nil {
+		return "", err
+	}
+
+	if err = EnsureDir(si.FS, si.Actions, siteDir); err != nil {
+		return "", err
+	}
+
+	project := siteProject{Title: filepath.Base(pi.ProjectDir)}
+	err = groups.Walk(func(group *ProjectInfo, batchNumber int) error {
+		batch, err := exportSiteBatch(siteDir, batchNumber, group, si)
+		if err != nil {
+			return err
+		}
+		project.Batches = append(project.Batches, batch)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// each directory gets its own copy of the stylesheet
+	if err = si.Actions.WriteFile(
+		filepath.Join(siteDir, "style.css"), []byte(siteStyle)); err != nil {
+		return "", err
+	}
+
+	index := filepath.Join(siteDir, "index.html")
+	return index, writeSiteTemplate(si.Actions, index, "project", project)
+}
+
+func exportSiteBatch(siteDir string, batchNumber int, pi *ProjectInfo, si *SystemInfo) (siteBatch, error) {
+	batchName := GetBatchName(pi, batchNumber)
+	batchPath := GetBatchDir(pi, batchNumber)
+	exportPath := filepath.Join(batchPath, pi.ExportDirName)
+	name := path.Join(pi.Group, batchName)
+	siteBatchDir := filepath.Join(siteDir, filepath.FromSlash(name))
+
+	if err := EnsureDir(si.FS, si.Actions, exportPath); err != nil {
+		return siteBatch{}, err
+	}
+	if err := EnsureDir(si.FS, si.Actions, siteBatchDir); err != nil {
+		return siteBatch{}, err
+	}
+
+	pageNumbers, err := GetPageNumbers(si.FS, batchPath, ".kra")
+	if err != nil {
+		return siteBatch{}, err
+	}
+
+	batch := siteBatch{
+		Name:  name,
+		Title: name,
+		Root:  strings.Repeat("../", strings.Count(name, "/")+1),
+		Pages: make([]sitePage, len(pageNumbers))}
+
+	for i, pageNumber := range pageNumbers {
+		pageName := FileWithoutExt(GetPageName(pageNumber))
+		imageName := fmt.Sprintf("%s.png", pageName)
+
+		exported := filepath.Join(exportPath, imageName)
+		err := ExportToPNG(
+			si, filepath.Join(batchPath, GetPageName(pageNumber)), exported)
+		if err != nil {
+			return siteBatch{}, err
+		}
+
+		if _, err = si.FS.Stat(exported); !os.IsNotExist(err) {
+			err = ResizePNG(
+				si, exported, filepath.Join(siteBatchDir, imageName),
+				si.SiteImageWidth)
+			if err != nil {
+				return siteBatch{}, err
+			}
+		}
+
+		batch.Pages[i] = sitePage{
+			Title: pageName,
+			Image: imageName,
+			Up:    "index.html"}
+		if i > 0 {
+			batch.Pages[i].Prev = fmt.Sprintf("%s.html", batch.Pages[i-1].Title)
+			batch.Pages[i-1].Next = fmt.Sprintf("%s.html", pageName)
+		}
+	}
+
+	pdfName := fmt.Sprintf("%s.pdf", batchName)
+	if _, err := si.FS.Stat(filepath.Join(batchPath, pdfName)); err == nil {
+		err = si.Actions.CopyFile(
+			filepath.Join(batchPath, pdfName),
+			filepath.Join(siteBatchDir, pdfName))
+		if err != nil {
+			return siteBatch{}, err
+		}
+		batch.PDF = pdfName
+	}
+
+	for _, page := range batch.Pages {
+		err := writeSiteTemplate(
+			si.Actions, filepath.Join(siteBatchDir, fmt.Sprintf("%s.html", page.Title)),
+			"page", page)
+		if err != nil {
+			return siteBatch{}, err
+		}
+	}
+
+	if err = si.Actions.WriteFile(
+		filepath.Join(siteBatchDir, "style.css"), []byte(siteStyle)); err != nil {
+		return siteBatch{}, err
+	}
+
+	return batch, writeSiteTemplate(
+		si.Actions, filepath.Join(siteBatchDir, "index.html"), "batch", batch)
+}
+
+func writeSiteTemplate(actions Actions, path string, name string, data any) error {
+	var page bytes.Buffer
+	if err := siteTemplates.ExecuteTemplate(&page, name, data); err != nil {
+		return err
+	}
+	return actions.WriteFile(path, page.Bytes())
+}
+
+// ResizePNG writes a copy of src to dst at most maxWidth wide, unless
+// dst is newer
+func ResizePNG(si *SystemInfo, src, dst string, maxWidth int) error {
+	srcStat, err := si.FS.Stat(src)
+	if err != nil {
+		return err
+	}
+	if dstStat, err := si.FS.Stat(dst); err == nil &&
+		srcStat.ModTime().Before(dstStat.ModTime()) {
+		return nil
+	}
+
+	srcBytes, err := si.FS.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	img, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		return err
+	}
+
+	if maxWidth > 0 && img.Bounds().Dx() > maxWidth {
+		img = scaleImage(img, maxWidth)
+	}
+
+	var dstBytes bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err = encoder.Encode(&dstBytes, img); err != nil {
+		return err
+	}
+	return si.Actions.WriteFile(dst, dstBytes.Bytes())
+}
+
+// scaleImage shrinks img to the given width by averaging the source
+// pixels that fall into each destination pixel. They are averaged
+// premultiplied, so that transparent pixels add no colour
+func scaleImage(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	height := srcHeight * width / srcWidth
+	if height < 1 {
+		height = 1
+	}
+
+	result := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*srcHeight/height
+		y1 := bounds.Min.Y + (y+1)*srcHeight/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*srcWidth/width
+			x1 := bounds.Min.X + (x+1)*srcWidth/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := color.RGBA64Model.Convert(
+						img.At(sx, sy)).(color.RGBA64)
+					r += uint64(c.R)
+					g += uint64(c.G)
+					b += uint64(c.B)
+					a += uint64(c.A)
+					n++
+				}
+			}
+			result.Set(x, y, color.RGBA64{
+				R: uint16(r / n), G: uint16(g / n),
+				B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+
+	return result
+}
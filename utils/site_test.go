@@ -0,0 +1,52 @@
+package knot
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestExportSiteInGroup(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+	si.SiteImageWidth = 2
+	unit := pi.InGroup("unit-2")
+	if err := MakeBatch(testTemplatePath, si, &unit, 3, false); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := ExportSite("/site", &pi, si)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := mem.ReadFile(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range []string{`href="notes-0/index.html"`, `href="unit-2/notes-3/index.html"`} {
+		if !strings.Contains(string(contents), link) {
+			t.Errorf("expected a link %s in the index, got\n%s", link, contents)
+		}
+	}
+
+	batch, err := mem.ReadFile("/site/unit-2/notes-3/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(batch), `<a href="../../index.html">up</a>`) {
+		t.Errorf("expected the grouped batch to link back to the index, got\n%s", batch)
+	}
+	assertExists(t, mem, "/site/unit-2/notes-3/page-0.png")
+}
+
+func TestScaleImageTransparentEdge(t *testing.T) {
+	// an opaque red pixel next to a transparent one that is green
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 255})
+	src.SetNRGBA(1, 0, color.NRGBA{G: 255})
+
+	scaled := scaleImage(src, 1).(*image.NRGBA)
+	if c := scaled.NRGBAAt(0, 0); c.R != 255 || c.G != 0 || c.B != 0 || c.A != 127 {
+		t.Errorf("expected half transparent red, got %v", c)
+	}
+}
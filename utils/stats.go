@@ -0,0 +1,366 @@
+package knot
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image/png"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Counts are the figures knot reports about a batch, a project or all
+// of them. CanvasArea is in pixels and the sizes are in bytes
+type Counts struct {
+	Pages       int
+	CanvasArea  int64
+	KraBytes    int64
+	ExportBytes int64
+	// FirstModified and LastModified are zero without pages
+	FirstModified time.Time
+	LastModified  time.Time
+}
+
+func (counts *Counts) add(other Counts) {
+	counts.Pages += other.Pages
+	counts.CanvasArea += other.CanvasArea
+	counts.KraBytes += other.KraBytes
+	counts.ExportBytes += other.ExportBytes
+	counts.modified(other.FirstModified)
+	counts.modified(other.LastModified)
+}
+
+// modified widens the modification times of counts to include t
+func (counts *Counts) modified(t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	if counts.FirstModified.IsZero() || t.Before(counts.FirstModified) {
+		counts.FirstModified = t
+	}
+	if t.After(counts.LastModified) {
+		counts.LastModified = t
+	}
+}
+
+// BatchStats are the statistics of a batch
+type BatchStats struct {
+	Number int
+	// Label is the batch's path, see BatchPath
+	Label string
+	Counts
+}
+
+// ProjectStats are the statistics of a project. Missing is set if its
+// directory doesn't exist, in which case it has no batches
+type ProjectStats struct {
+	Name    string
+	Dir     string
+	Missing bool `json:",omitempty"`
+	Counts
+	Batches []BatchStats
+	// Activity counts the pages last modified on each day, 2006-01-02
+	Activity map[string]int
+}
+
+// Stats are the statistics of a set of projects
+type Stats struct {
+	Projects []ProjectStats
+	Counts
+	Activity map[string]int
+	// Weekly counts them by ISO week, 2006-W01
+	Weekly map[string]int
+}
+
+const activityDay = "2006-01-02"
+
+// kraDocument is the part of a .kra's maindoc.xml that gives the size
+// of the canvas
+type kraDocument struct {
+	Image struct {
+		Width  int `xml:"width,attr"`
+		Height int `xml:"height,attr"`
+	} `xml:"IMAGE"`
+}
+
+// KraSize returns the size of the canvas of a .kra file, from its
+// maindoc.xml or, failing that, from its merged image
+func KraSize(kra []byte) (int, int, error) {
+	archive, err := zip.NewReader(bytes.NewReader(kra), int64(len(kra)))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if maindoc, err := archive.Open("maindoc.xml"); err == nil {
+		var document kraDocument
+		err = xml.NewDecoder(maindoc).Decode(&document)
+		maindoc.Close()
+		if err == nil && document.Image.Width > 0 && document.Image.Height > 0 {
+			return document.Image.Width, document.Image.Height, nil
+		}
+	}
+
+	merged, err := archive.Open("mergedimage.png")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer merged.Close()
+	config, err := png.DecodeConfig(merged)
+	if err != nil {
+		return 0, 0, err
+	}
+	return config.Width, config.Height, nil
+}
+
+// dirSize returns the total size of the files in dir and its
+// subdirectories, 0 if it doesn't exist
+func dirSize(fsys FileSystem, dir string) int64 {
+	entries, err := fsys.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	var result int64
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			result += dirSize(fsys, path)
+		} else if stat, err := fsys.Stat(path); err == nil {
+			result += stat.Size()
+		}
+	}
+	return result
+}
+
+// GetBatchStats reads the pages of a batch and their exports. Each
+// page counts towards the activity on the day it was last modified
+func GetBatchStats(fsys FileSystem, pi *ProjectInfo, batchNumber int, activity map[string]int) (BatchStats, error) {
+	stats := BatchStats{Number: batchNumber, Label: BatchPath(pi, batchNumber)}
+	batchDir := GetBatchDir(pi, batchNumber)
+
+	pageNumbers, err := GetPageNumbers(fsys, batchDir, ".kra")
+	if err != nil {
+		return stats, &Error{Kind: ErrBatchNotFound, Path: batchDir, Cause: err}
+	}
+	for _, pageNumber := range pageNumbers {
+		page := filepath.Join(batchDir, GetPageName(pageNumber))
+		stat, err := fsys.Stat(page)
+		if err != nil {
+			return stats, err
+		}
+		stats.Pages++
+		stats.KraBytes += stat.Size()
+		stats.modified(stat.ModTime())
+		activity[stat.ModTime().Format(activityDay)]++
+
+		kra, err := fsys.ReadFile(page)
+		if err != nil {
+			return stats, err
+		}
+		if width, height, err := KraSize(kra); err == nil {
+			stats.CanvasArea += int64(width) * int64(height)
+		}
+	}
+
+	stats.ExportBytes = dirSize(fsys, filepath.Join(batchDir, pi.ExportDirName))
+	pdf := filepath.Join(batchDir, filepath.Base(batchDir)+".pdf")
+	if stat, err := fsys.Stat(pdf); err == nil {
+		stats.ExportBytes += stat.Size()
+	}
+	return stats, nil
+}
+
+// GetProjectStats reads the statistics of every batch of a project and
+// of its groups
+func GetProjectStats(fsys FileSystem, name string, pi *ProjectInfo) (ProjectStats, error) {
+	stats := ProjectStats{Name: name, Dir: pi.ProjectDir, Activity: make(map[string]int)}
+	if _, err := fsys.Stat(pi.ProjectDir); err != nil {
+		stats.Missing = true
+		return stats, nil
+	}
+
+	groups, err := GetBatchGroups(fsys, pi)
+	if err != nil {
+		return stats, err
+	}
+	err = groups.Walk(func(group *ProjectInfo, batchNumber int) error {
+		batchStats, err := GetBatchStats(fsys, group, batchNumber, stats.Activity)
+		if err != nil {
+			return err
+		}
+		stats.add(batchStats.Counts)
+		stats.Batches = append(stats.Batches, batchStats)
+		return nil
+	})
+	return stats, err
+}
+
+// GetStats reads the statistics of the given registered projects, or
+// of all of them if names is empty
+func GetStats(fsys FileSystem, projects *Projects, names []string) (Stats, error) {
+	stats := Stats{Activity: make(map[string]int), Weekly: make(map[string]int)}
+
+	if len(names) == 0 {
+		for name := range *projects {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	for _, name := range names {
+		pi, ok := (*projects)[name]
+		if !ok {
+			return stats, &Error{Kind: ErrProjectNotFound, Project: name}
+		}
+		projectStats, err := GetProjectStats(fsys, name, &pi)
+		if err != nil {
+			return stats, err
+		}
+		stats.add(projectStats.Counts)
+		for day, pages := range projectStats.Activity {
+			stats.Activity[day] += pages
+		}
+		stats.Projects = append(stats.Projects, projectStats)
+	}
+
+	for day, pages := range stats.Activity {
+		date, _ := time.ParseInLocation(activityDay, day, time.Local)
+		year, week := date.ISOWeek()
+		stats.Weekly[fmt.Sprintf("%d-W%02d", year, week)] += pages
+	}
+	return stats, nil
+}
+
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, prefix := float64(size)/unit, 0
+	for value >= unit && prefix < 3 {
+		value /= unit
+		prefix++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGT"[prefix])
+}
+
+func formatArea(area int64) string {
+	return fmt.Sprintf("%.1f Mpx", float64(area)/1e6)
+}
+
+func formatModified(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format("2006-01-02 15:04")
+}
+
+// PrintStats writes the statistics as a table and a heatmap
+func PrintStats(w io.Writer, stats *Stats, now time.Time, weeks int) error {
+	table := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(table, "\tpages\tcanvas\t.kra\texports\tfirst modified\tlast modified")
+
+	row := func(name string, counts *Counts) {
+		fmt.Fprintf(table, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", name, counts.Pages,
+			formatArea(counts.CanvasArea), formatBytes(counts.KraBytes),
+			formatBytes(counts.ExportBytes),
+			formatModified(counts.FirstModified), formatModified(counts.LastModified))
+	}
+	for _, project := range stats.Projects {
+		if project.Missing {
+			fmt.Fprintf(table, "%s\tdirectory <%s> is missing\n", project.Name, project.Dir)
+			continue
+		}
+		row(project.Name, &project.Counts)
+		for _, batch := range project.Batches {
+			row("  batch "+batch.Label, &batch.Counts)
+		}
+	}
+	if len(stats.Projects) > 1 {
+		row("total", &stats.Counts)
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\npages modified per week:\n")
+	start := startOfWeek(now).AddDate(0, 0, -7*(weeks-1))
+	for week := 0; week < weeks; week++ {
+		day := start.AddDate(0, 0, 7*week)
+		year, number := day.ISOWeek()
+		pages := stats.Weekly[fmt.Sprintf("%d-W%02d", year, number)]
+		fmt.Fprintf(w, "%s\n", strings.TrimRight(fmt.Sprintf("%d-W%02d  %4d  %s",
+			year, number, pages, strings.Repeat("#", pages)), " "))
+	}
+
+	fmt.Fprintln(w)
+	PrintHeatmap(w, stats.Activity, now, weeks)
+	return nil
+}
+
+// startOfWeek returns the monday of the week of t, at midnight
+func startOfWeek(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+}
+
+// heatmapLevels are the characters of the heatmap, from no page
+// modified on a day to many
+var heatmapLevels = []struct {
+	pages int
+	char  byte
+}{{0, '.'}, {1, '-'}, {3, '+'}, {6, '*'}, {10, '#'}}
+
+func heatmapChar(pages int) byte {
+	char := heatmapLevels[0].char
+	for _, level := range heatmapLevels {
+		if pages >= level.pages {
+			char = level.char
+		}
+	}
+	return char
+}
+
+// PrintHeatmap writes the pages modified each day of the last weeks
+func PrintHeatmap(w io.Writer, activity map[string]int, now time.Time, weeks int) {
+	start := startOfWeek(now).AddDate(0, 0, -7*(weeks-1))
+	today := now.Format(activityDay)
+
+	header := []byte(strings.Repeat(" ", weeks))
+	for week := 0; week < weeks; week++ {
+		monday := start.AddDate(0, 0, 7*week)
+		first := monday.Day() <= 7 ||
+			week == 0 && monday.AddDate(0, 0, 21).Month() == monday.Month()
+		if first && (week == 0 || header[week-1] == ' ') {
+			copy(header[week:], monday.Format("Jan"))
+		}
+	}
+	fmt.Fprintf(w, "     %s\n", strings.TrimRight(string(header), " "))
+
+	for weekday := 0; weekday < 7; weekday++ {
+		line := make([]byte, 0, weeks)
+		for week := 0; week < weeks; week++ {
+			day := start.AddDate(0, 0, 7*week+weekday).Format(activityDay)
+			if day > today {
+				break
+			}
+			line = append(line, heatmapChar(activity[day]))
+		}
+		name := start.AddDate(0, 0, weekday).Format("Mon")
+		fmt.Fprintf(w, "%s  %s\n", name, line)
+	}
+
+	var legend []string
+	for _, level := range heatmapLevels {
+		if level.pages == 0 {
+			legend = append(legend, fmt.Sprintf("%c none", level.char))
+		} else {
+			legend = append(legend, fmt.Sprintf("%c %d+", level.char, level.pages))
+		}
+	}
+	fmt.Fprintf(w, "     %s\n", strings.Join(legend, "  "))
+}
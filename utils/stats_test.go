@@ -0,0 +1,60 @@
+package knot
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetStats(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+	if _, _, err := MakePage(testTemplatePath, si, &pi, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	exportDir := filepath.Join(GetBatchDir(&pi, 0), pi.ExportDirName)
+	mustMkdirAll(t, mem, exportDir)
+	mustWriteFile(t, mem, filepath.Join(exportDir, "page-0.png"), make([]byte, 100))
+	projects := Projects{"notes": pi, "gone": testProjectInfo("gone")}
+
+	stats, err := GetStats(mem, &projects, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Projects) != 2 || !stats.Projects[0].Missing {
+		t.Fatalf("expected gone to be missing, got %+v", stats.Projects)
+	}
+	notes := stats.Projects[1]
+	if notes.Pages != 2 || notes.CanvasArea != 2*4*3 || notes.ExportBytes != 100 {
+		t.Errorf("expected 2 pages of 4x3 and 100 bytes of exports, got %+v", notes.Counts)
+	}
+	if len(notes.Batches) != 1 || notes.Batches[0].Pages != 2 {
+		t.Errorf("expected a batch of 2 pages, got %+v", notes.Batches)
+	}
+	if stats.Activity[time.Now().Format(activityDay)] != 2 {
+		t.Errorf("expected 2 pages modified today, got %v", stats.Activity)
+	}
+
+	if _, err = GetStats(mem, &projects, []string{"other"}); !errors.Is(err, ErrProjectNotFound) {
+		t.Errorf("expected ErrProjectNotFound, got %v", err)
+	}
+}
+
+func TestPrintHeatmap(t *testing.T) {
+	// a wednesday
+	now := time.Date(2024, 3, 13, 12, 0, 0, 0, time.Local)
+	activity := map[string]int{"2024-03-04": 1, "2024-03-12": 12, "2024-03-14": 5}
+
+	var heatmap bytes.Buffer
+	PrintHeatmap(&heatmap, activity, now, 2)
+
+	lines := strings.Split(heatmap.String(), "\n")
+	expected := []string{"Mon  -.", "Tue  .#", "Wed  ..", "Thu  ."}
+	for i, line := range expected {
+		if lines[i+1] != line {
+			t.Errorf("expected <%s> on line %d, got <%s>", line, i+1, lines[i+1])
+		}
+	}
+}
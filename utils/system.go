@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"github.com/glycerine/zygomys/v6/zygo"
 	"io"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 )
 
 type CommandRunner interface {
@@ -18,23 +20,38 @@ type CommandRunner interface {
 
 type SimpleCommandRunner struct {
 	commandName string
+	args        []string
 }
 
-func NewSimpleCommandRunner(commandName string) *SimpleCommandRunner {
-	return &SimpleCommandRunner{commandName: commandName}
+// NewSimpleCommandRunner makes a runner for a command. The args are
+// passed to it before the inputs
+func NewSimpleCommandRunner(commandName string, args ...string) *SimpleCommandRunner {
+	return &SimpleCommandRunner{commandName: commandName, args: args}
 }
 
 func (runner *SimpleCommandRunner) Run(inputs []string) (string, error) {
-	cmd := exec.Command(runner.commandName, inputs...)
+	cmd := exec.Command(runner.commandName, runner.arguments(inputs)...)
 	output, err := cmd.CombinedOutput()
 	return string(output), err
 }
 
 func (runner *SimpleCommandRunner) Start(inputs []string) error {
-	cmd := exec.Command(runner.commandName, inputs...)
+	cmd := exec.Command(runner.commandName, runner.arguments(inputs)...)
 	return cmd.Start()
 }
 
+func (runner *SimpleCommandRunner) arguments(inputs []string) []string {
+	return append(append([]string{}, runner.args...), inputs...)
+}
+
+func (runner *SimpleCommandRunner) commandLine(inputs []string) []string {
+	return append([]string{runner.commandName}, runner.arguments(inputs)...)
+}
+
+func (runner *SimpleCommandRunner) String() string {
+	return strings.Join(append([]string{runner.commandName}, runner.args...), " ")
+}
+
 type ZygoCommandRunner struct {
 	zygoEnv  *zygo.Zlisp
 	zygoFunc *zygo.SexpFunction
@@ -59,20 +76,70 @@ func (runner *ZygoCommandRunner) Start(inputs []string) error {
 	return err
 }
 
+func (runner *ZygoCommandRunner) String() string {
+	return runner.zygoFunc.SexpString(zygo.NewPrintState())
+}
+
 func CommandRunnerFromZygoEnv(zygoEnv *zygo.Zlisp, sexpName string, defaultRunner CommandRunner) CommandRunner {
 	sexp, found := zygoEnv.FindObject(sexpName)
 	if !found {
 		return defaultRunner
 	}
 
+	runner, err := CommandRunnerFromSexp(zygoEnv, sexp)
+	if err != nil {
+		return defaultRunner
+	}
+	return runner
+}
+
+// CommandRunnerFromSexp makes a runner out of a command name, a list
+// of arguments or a zygo function
+func CommandRunnerFromSexp(zygoEnv *zygo.Zlisp, sexp zygo.Sexp) (CommandRunner, error) {
+	var elements []zygo.Sexp
+
 	switch sexp.(type) {
 	case *zygo.SexpStr:
-		return NewSimpleCommandRunner(string(sexp.(*zygo.SexpStr).S))
+		command := sexp.(*zygo.SexpStr).S
+		if hasPlaceholder([]string{command}) {
+			fields := strings.Fields(command)
+			if len(fields) == 0 {
+				return nil, errors.New("expected a command name, got an empty string")
+			}
+			return NewTemplateCommandRunner(fields[0], fields[1:]...), nil
+		}
+		return NewSimpleCommandRunner(command), nil
 	case *zygo.SexpFunction:
-		return NewZygoCommandRunner(zygoEnv, sexp.(*zygo.SexpFunction))
+		return NewZygoCommandRunner(zygoEnv, sexp.(*zygo.SexpFunction)), nil
+	case *zygo.SexpArray:
+		elements = sexp.(*zygo.SexpArray).Val
+	case *zygo.SexpPair:
+		var err error
+		if elements, err = zygo.ListToArray(sexp); err != nil {
+			return nil, err
+		}
 	default:
-		return defaultRunner
+		return nil, fmt.Errorf(
+			"expected a command name, a list of arguments or a function, got %s",
+			sexp.SexpString(zygo.NewPrintState()))
+	}
+
+	if len(elements) == 0 {
+		return nil, errors.New("expected a command name, got an empty list")
+	}
+	args := make([]string, len(elements))
+	for i, element := range elements {
+		str, ok := element.(*zygo.SexpStr)
+		if !ok {
+			return nil, fmt.Errorf("expected only strings in the argument list, got %s",
+				element.SexpString(zygo.NewPrintState()))
+		}
+		args[i] = str.S
+	}
+	if hasPlaceholder(args) {
+		return NewTemplateCommandRunner(args[0], args[1:]...), nil
 	}
+	return NewSimpleCommandRunner(args[0], args[1:]...), nil
 }
 
 func IntFromZygoEnv(zygoEnv *zygo.Zlisp, sexpName string, defaultInt int) int {
@@ -92,38 +159,61 @@ func IntFromZygoEnv(zygoEnv *zygo.Zlisp, sexpName string, defaultInt int) int {
 type ConfigInfo struct {
 	PDFReader     CommandRunner
 	FileExplorer  CommandRunner
-	ExportQuality int
+	KritaCommand  CommandRunner
+	DefaultViewer CommandRunner
+	// PDFRasterizer is given a pdf and the prefix of the pngs to make
+	PDFRasterizer CommandRunner
+	// Viewers are the commands defined with defviewer
+	Viewers        map[string]CommandRunner
+	ExportQuality  int
+	SiteImageWidth int
+	// the history store's retention, 0 for no limit, and the minutes
+	// between the snapshots of knot versions watch
+	HistoryKeep     int
+	HistoryDays     int
+	HistoryInterval int
+	// StampMarks marks the starred and bookmarked pages of exports
+	StampMarks bool
+	Hooks      map[string]CommandRunner
+	FatalHooks bool
+	Commands   map[string]*zygo.SexpFunction
+	ZygoEnv    *zygo.Zlisp
+	// Sources records where each setting came from
+	Sources map[string]string
+	// ConfigFiles are the files layered over the defaults, in order,
+	// whether they exist or not
+	ConfigFiles    []string
+	ConfigErrors   []error
+	ConfigWarnings []string
+	builtins       *zygoBuiltins
+	loadingFile    string
 }
 
-func LoadConfigInfo(configFile string) ConfigInfo {
-	var configInfo ConfigInfo
-	configInfo.PDFReader = NewSimpleCommandRunner("evince")
-	configInfo.FileExplorer = NewSimpleCommandRunner("nautilus")
-	configInfo.ExportQuality = 100
-
-	zygoEnv := zygo.NewZlisp()
+// KnotDirs are the directories knot keeps its own files in
+type KnotDirs struct {
+	ConfigDir string
+	StateDir  string
+	DataDir   string
+}
 
-	sexps, err := zygoEnv.ParseFile(configFile)
-	if err != nil {
-		return configInfo
-	}
-	err = zygoEnv.LoadExpressions(sexps)
-	if err != nil {
-		return configInfo
-	}
-	_, err = zygoEnv.Run()
-	if err != nil {
-		return configInfo
+// GetKnotDirs places knot's directories inside the platform ones, or
+// in $KNOT_HOME
+func GetKnotDirs(platformDirs *PlatformDirs) (KnotDirs, error) {
+	if knotHome := os.Getenv("KNOT_HOME"); knotHome != "" {
+		knotHome, err := filepath.Abs(knotHome)
+		if err != nil {
+			return KnotDirs{}, err
+		}
+		return KnotDirs{
+			ConfigDir: knotHome,
+			StateDir:  filepath.Join(knotHome, "state"),
+			DataDir:   knotHome}, nil
 	}
 
-	configInfo.PDFReader = CommandRunnerFromZygoEnv(
-		zygoEnv, "PDFReader", configInfo.PDFReader)
-	configInfo.FileExplorer = CommandRunnerFromZygoEnv(
-		zygoEnv, "FileExplorer", configInfo.FileExplorer)
-	configInfo.ExportQuality = IntFromZygoEnv(
-		zygoEnv, "ExportQuality", configInfo.ExportQuality)
-
-	return configInfo
+	return KnotDirs{
+		ConfigDir: filepath.Join(platformDirs.ConfigDir, "knot"),
+		StateDir:  filepath.Join(platformDirs.StateDir, "knot"),
+		DataDir:   filepath.Join(platformDirs.DataDir, "knot")}, nil
 }
 
 type TempConfigInfo struct {
@@ -136,85 +226,169 @@ type SystemInfo struct {
 	ConfigDir      string
 	ConfigFile     string
 	TempConfigFile string
+	SessionsDir    string
+	SessionFile    string
+	SessionKey     string
+	SessionPID     int
+	SessionStarted uint64
 	ProjectsFile   string
 	TemplateDir    string
 	ExportScript   string
 	PythonCommand  string
+	// FS is read from directly, while every change goes through Actions
+	FS      FileSystem
+	Actions Actions
+	// Warnings gets the problems that don't stop knot, if not nil
+	Warnings io.Writer
+	configs  *configCache
+}
+
+// Warn reports a problem that doesn't stop knot
+func (si *SystemInfo) Warn(err error) {
+	warn(si.Warnings, err)
+}
+
+func warn(w io.Writer, err error) {
+	if w != nil {
+		fmt.Fprintf(w, "warning: %v\n", err)
+	}
 }
 
-func GetSystemInfo(platform Platform) (SystemInfo, error) {
+func GetSystemInfo(platform Platform, fsys FileSystem, actions Actions, warnings io.Writer) (SystemInfo, error) {
 	platformDirs, err := platform.GetPlatformDirs()
 	if err != nil {
 		return SystemInfo{}, err
 	}
-	tempDir := platformDirs.TempDir
-	sysConfigDir := platformDirs.ConfigDir
-
-	tempConfigFile := filepath.Join(tempDir, "knotconfig.json")
+	knotDirs, err := GetKnotDirs(&platformDirs)
+	if err != nil {
+		return SystemInfo{}, err
+	}
 
-	tempConfigBytes, errRead := os.ReadFile(tempConfigFile)
+	if os.Getenv("KNOT_HOME") == "" {
+		for _, err := range MigrateLegacyFiles(fsys, actions, &platformDirs, &knotDirs) {
+			warn(warnings, err)
+		}
+	}
 
-	var tci TempConfigInfo
-	errUnmarshal := json.Unmarshal(tempConfigBytes, &tci)
+	if err = EnsureDir(fsys, actions, knotDirs.ConfigDir); err != nil {
+		return SystemInfo{}, err
+	}
+	if err = EnsureDir(fsys, actions, knotDirs.StateDir); err != nil {
+		return SystemInfo{}, err
+	}
 
-	if errRead != nil || errUnmarshal != nil {
-		knotWD, err := os.Getwd()
-		if err != nil {
-			return SystemInfo{}, err
+	si, err := readSystemInfo(platform, fsys, &knotDirs)
+	if err != nil {
+		return SystemInfo{}, err
+	}
+	// a new session clears those of the shells that have exited
+	if session, err := ReadSession(fsys, si.SessionFile); err != nil || session.Started != si.SessionStarted {
+		if _, err = pruneSessions(fsys, actions, si.SessionsDir, platform); err != nil {
+			warn(warnings, err)
 		}
+	}
 
-		tci = TempConfigInfo{KnotWD: knotWD}
+	// bound to the SystemInfo of the workspace by NewWorkspace
+	si.configs = newConfigCache()
+	si.ConfigInfo = LoadLayeredConfigInfo(si.ConfigFile)
+
+	si.PythonCommand, err = platform.GetPythonCommand()
+	if err != nil {
+		return SystemInfo{}, err
 	}
 
-	configDir := filepath.Join(sysConfigDir, "knot")
-	projectsFile := filepath.Join(configDir, "projects.json")
-	templateDir := filepath.Join(configDir, "templates")
-	configFile := filepath.Join(configDir, "config.zy")
-	exportScript := filepath.Join(configDir, "export.py")
+	si.Actions = actions
+	si.Warnings = warnings
+	return si, nil
+}
+
+// GetCompletionSystemInfo is a GetSystemInfo that neither migrates nor
+// loads the configuration, nor changes anything
+func GetCompletionSystemInfo(platform Platform, fsys FileSystem) (SystemInfo, error) {
+	platformDirs, err := platform.GetPlatformDirs()
+	if err != nil {
+		return SystemInfo{}, err
+	}
+	knotDirs, err := GetKnotDirs(&platformDirs)
+	if err != nil {
+		return SystemInfo{}, err
+	}
+
+	si, err := readSystemInfo(platform, fsys, &knotDirs)
+	if err != nil {
+		return SystemInfo{}, err
+	}
+	si.Actions = NoActions{}
+	return si, nil
+}
 
-	ci := LoadConfigInfo(configFile)
+// readSystemInfo returns the paths of knot's files and the knot working
+// directory of the current session
+func readSystemInfo(platform Platform, fsys FileSystem, knotDirs *KnotDirs) (SystemInfo, error) {
+	tempConfigFile := filepath.Join(knotDirs.StateDir, "knotconfig.json")
+	sessionsDir := filepath.Join(knotDirs.StateDir, "sessions")
+	sessionKey, sessionPID, sessionStarted := GetSessionKey(platform)
+	sessionFile := GetSessionFile(sessionsDir, sessionKey)
 
-	pythonCommand, err := platform.GetPythonCommand()
+	tci, err := LoadTempConfigInfo(fsys, sessionFile, sessionStarted, tempConfigFile)
 	if err != nil {
 		return SystemInfo{}, err
 	}
 
+	configDir := knotDirs.ConfigDir
 	return SystemInfo{
-		ConfigInfo:     ci,
 		TempConfigInfo: tci,
 		ConfigDir:      configDir,
-		ConfigFile:     configFile,
+		ConfigFile:     filepath.Join(configDir, "config.zy"),
 		TempConfigFile: tempConfigFile,
-		ProjectsFile:   projectsFile,
-		TemplateDir:    templateDir,
-		ExportScript:   exportScript,
-		PythonCommand:  pythonCommand}, nil
+		SessionsDir:    sessionsDir,
+		SessionFile:    sessionFile,
+		SessionKey:     sessionKey,
+		SessionPID:     sessionPID,
+		SessionStarted: sessionStarted,
+		ProjectsFile:   filepath.Join(configDir, "projects.json"),
+		TemplateDir:    filepath.Join(configDir, "templates"),
+		ExportScript:   filepath.Join(knotDirs.DataDir, "export.py"),
+		FS:             fsys}, nil
 }
 
-func SetTempConfigInfo(si *SystemInfo, tci *TempConfigInfo) error {
-	tempConfigInfoBytes, err := json.MarshalIndent(*tci, "", "\t")
-	if err != nil {
-		return err
+// LoadTempConfigInfo returns the knot working directory of the shell
+// session, or else the last one set, or else $PWD
+func LoadTempConfigInfo(fsys FileSystem, sessionFile string, started uint64, tempConfigFile string) (TempConfigInfo, error) {
+	session, err := ReadSession(fsys, sessionFile)
+	// a session of another shell that had the same id is stale
+	if err == nil && session.Started == started {
+		return session.TempConfigInfo, nil
 	}
 
-	tempConfigFileName := si.TempConfigFile
+	var tci TempConfigInfo
+	tempConfigBytes, errRead := fsys.ReadFile(tempConfigFile)
+
+	errUnmarshal := json.Unmarshal(tempConfigBytes, &tci)
 
-	_, err = os.Stat(tempConfigFileName)
-	if err == nil {
-		err = os.Remove(tempConfigFileName)
+	if errRead != nil || errUnmarshal != nil {
+		knotWD, err := os.Getwd()
 		if err != nil {
-			return err
+			return TempConfigInfo{}, err
 		}
+
+		tci = TempConfigInfo{KnotWD: knotWD}
 	}
+	return tci, nil
+}
 
-	tempConfigFile, err := os.Create(tempConfigFileName)
+func SetTempConfigInfo(si *SystemInfo, tci *TempConfigInfo) error {
+	tempConfigInfoBytes, err := json.MarshalIndent(*tci, "", "\t")
 	if err != nil {
 		return err
 	}
-	defer tempConfigFile.Close()
 
-	_, err = tempConfigFile.Write(tempConfigInfoBytes)
-	return err
+	err = si.Actions.WriteFile(si.TempConfigFile, tempConfigInfoBytes)
+	if err != nil {
+		return err
+	}
+
+	return WriteSession(si, tci)
 }
 
 func SetTempKnotWD(si *SystemInfo, knotWD string) error {
@@ -228,34 +402,15 @@ func SetTempKnotWD(si *SystemInfo, knotWD string) error {
 }
 
 func OpenFile(si *SystemInfo, file string, open bool) error {
-	if !open {
-		return nil
-	}
-
-	extension := filepath.Ext(file)
-
-	switch extension {
-	case ".kra":
-		cmd := exec.Command("krita", file)
-		return cmd.Start()
-	case ".pdf":
-		return si.PDFReader.Start([]string{file})
-	case "": // directory
-		return si.FileExplorer.Start([]string{file})
-	default:
-		return errors.New(fmt.Sprintf(
-			"extension %s is unsupported", extension))
-	}
+	return OpenFileAt(si, file, 1, open)
 }
 
-func CreateFile(path string) error {
-	file, ok := os.Create(path)
-	file.Close()
-	return ok
+func CreateFile(fsys FileSystem, path string) error {
+	return fsys.WriteFile(path, nil, 0644)
 }
 
-func CopyFile(src, dst string) (int64, error) {
-	srcStat, err := os.Stat(src)
+func CopyFile(fsys FileSystem, src, dst string) (int64, error) {
+	srcStat, err := fsys.Stat(src)
 	if err != nil {
 		return 0, err
 	}
@@ -265,59 +420,49 @@ func CopyFile(src, dst string) (int64, error) {
 			"%s is not a regular file", src))
 	}
 
-	source, err := os.Open(src)
-	defer source.Close()
+	sourceBytes, err := fsys.ReadFile(src)
 	if err != nil {
 		return 0, err
 	}
 
-	dstStat, err := os.Stat(dst)
+	dstStat, err := fsys.Stat(dst)
 	if err == nil && dstStat.IsDir() {
 		dst = filepath.Join(dst, filepath.Base(src))
 	}
 
-	destination, err := os.Create(dst)
-	if err != nil {
+	if err = fsys.WriteFile(dst, sourceBytes, srcStat.Mode().Perm()); err != nil {
 		return 0, err
 	}
-	defer destination.Close()
-
-	nBytes, err := io.Copy(destination, source)
-	return nBytes, err
+	return int64(len(sourceBytes)), nil
 }
 
-func CopyDir(src, dst string) error {
-	srcName := filepath.Base(src)
-	dstDir := dst
-
-	_, ok := os.Stat(dstDir)
-	if ok == nil { // if the dir exists, create a new dir inside it
-		dstDir = filepath.Join(dst, srcName)
-		_, ok = os.Stat(dstDir)
-		if ok == nil {
-			return errors.New(fmt.Sprintf("directory <%s> already exists", dstDir))
-		}
+// CopyDir copies the directory src to dst, which must not exist yet
+func CopyDir(fsys FileSystem, src, dst string) error {
+	if _, err := fsys.Stat(dst); err == nil {
+		return &fs.PathError{Op: "copy", Path: dst, Err: fs.ErrExist}
 	}
 
-	os.Mkdir(dstDir, 0750)
-
-	srcDir, ok := os.ReadDir(src)
+	srcDir, ok := fsys.ReadDir(src)
 	if ok != nil {
 		return ok
 	}
 
+	if ok = fsys.MkdirAll(dst, 0750); ok != nil {
+		return ok
+	}
+
 	for _, item := range srcDir {
 		itemName := item.Name()
-		destination := filepath.Join(dstDir, itemName)
+		destination := filepath.Join(dst, itemName)
 		source := filepath.Join(src, itemName)
 
 		if item.IsDir() {
-			ok := CopyDir(source, destination)
+			ok := CopyDir(fsys, source, destination)
 			if ok != nil {
 				return ok
 			}
 		} else {
-			_, ok := CopyFile(source, destination)
+			_, ok := CopyFile(fsys, source, destination)
 			if ok != nil {
 				return ok
 			}
@@ -326,8 +471,8 @@ func CopyDir(src, dst string) error {
 	return nil
 }
 
-func MoveFile(src, dst string) (int, error) {
-	srcStat, err := os.Stat(src)
+func MoveFile(fsys FileSystem, src, dst string) (int, error) {
+	srcStat, err := fsys.Stat(src)
 	if err != nil {
 		return 0, err
 	}
@@ -336,31 +481,28 @@ func MoveFile(src, dst string) (int, error) {
 			"%s is not a regular file", src))
 	}
 
-	sourceBytes, err := os.ReadFile(src)
-	if err != nil {
-		return 0, err
-	}
-
-	if os.Remove(src) != nil {
-		return 0, err
+	if dstStat, err := fsys.Stat(dst); err == nil && dstStat.IsDir() {
+		dst = filepath.Join(dst, filepath.Base(src))
 	}
 
-	if dstStat, err := os.Stat(dst); err == nil && dstStat.IsDir() {
-		dst = filepath.Join(dst, filepath.Base(src))
+	if err = fsys.Rename(src, dst); err == nil {
+		return int(srcStat.Size()), nil
 	}
 
-	destination, err := os.Create(dst)
+	sourceBytes, err := fsys.ReadFile(src)
 	if err != nil {
 		return 0, err
 	}
-	defer destination.Close()
 
-	return destination.Write(sourceBytes)
+	if err = fsys.WriteFile(dst, sourceBytes, srcStat.Mode().Perm()); err != nil {
+		return 0, err
+	}
+	return len(sourceBytes), fsys.Remove(src)
 }
 
-func EnsureDirExists(dirName string) error {
-	if _, err := os.Stat(dirName); err != nil {
-		err = os.MkdirAll(dirName, os.ModePerm)
+func EnsureDirExists(fsys FileSystem, dirName string) error {
+	if _, err := fsys.Stat(dirName); err != nil {
+		err = fsys.MkdirAll(dirName, os.ModePerm)
 		return err
 	}
 	return nil
@@ -374,3 +516,9 @@ func ChangeFileExt(fileName string, newExtension string) string {
 	return fmt.Sprintf(
 		"%s.%s", FileWithoutExt(fileName), newExtension)
 }
+
+// outsideDir tells whether a path from filepath.Rel leads out of the
+// directory
+func outsideDir(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,75 @@
+package knot
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// transaction prepares files in a staging directory and renames them
+// into place
+type transaction struct {
+	si       *SystemInfo
+	stageDir string
+	created  []string
+}
+
+// withTransaction runs body in a transaction staged in dir, undoing
+// its commits if it fails. An error undoing them comes along with the
+// one of body
+func withTransaction(si *SystemInfo, dir string, body func(tx *transaction) error) error {
+	tx := &transaction{
+		si: si,
+		stageDir: filepath.Join(
+			dir, fmt.Sprintf(".knot-stage-%d", time.Now().UnixNano()))}
+
+	if err := si.Actions.MkdirAll(tx.stageDir); err != nil {
+		return err
+	}
+
+	err := body(tx)
+	if err != nil {
+		if rollbackErr := tx.rollback(); rollbackErr != nil {
+			err = fmt.Errorf("%w, and undoing it failed: %v", err, rollbackErr)
+		}
+	}
+	if removeErr := si.Actions.RemoveAll(tx.stageDir); removeErr != nil {
+		si.Warn(removeErr)
+	}
+	return err
+}
+
+// staged returns the path of name in the staging directory
+func (tx *transaction) staged(name string) string {
+	return filepath.Join(tx.stageDir, name)
+}
+
+// commit moves the staged name to dst. If dst already exists, nothing
+// is moved and the error is of the given kind
+func (tx *transaction) commit(name string, dst string, existsKind error) error {
+	if _, err := tx.si.FS.Stat(dst); err == nil {
+		return &Error{Kind: existsKind, Path: dst}
+	}
+	if err := tx.si.Actions.Rename(tx.staged(name), dst); err != nil {
+		return err
+	}
+	tx.created = append(tx.created, dst)
+	return nil
+}
+
+// rollback removes what was committed, as much of it as it can
+func (tx *transaction) rollback() error {
+	var failed []string
+	for i := len(tx.created) - 1; i >= 0; i-- {
+		if err := tx.si.Actions.RemoveAll(tx.created[i]); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	tx.created = nil
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
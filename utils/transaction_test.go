@@ -0,0 +1,47 @@
+package knot
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// failingActions fails to remove files and to start commands
+type failingActions struct {
+	Actions
+	err error
+}
+
+func (actions failingActions) RemoveAll(path string) error {
+	return actions.err
+}
+
+func (actions failingActions) Start(runner CommandRunner, inputs []string) error {
+	return actions.err
+}
+
+func TestTransactionRollbackFails(t *testing.T) {
+	si, mem := newTestSystemInfo(t)
+	mustMkdirAll(t, mem, "/projects")
+	var warnings bytes.Buffer
+	si.Warnings = &warnings
+	si.Actions = failingActions{Actions: si.Actions, err: errors.New("read-only")}
+
+	failed := errors.New("second batch")
+	err := withTransaction(si, "/projects", func(tx *transaction) error {
+		if err := si.Actions.MkdirAll(tx.staged("batch")); err != nil {
+			return err
+		}
+		if err := tx.commit("batch", "/projects/notes-0", ErrBatchExists); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) || !strings.Contains(err.Error(), "undoing it failed: read-only") {
+		t.Errorf("expected the failure along with the one undoing it, got %v", err)
+	}
+	if !strings.Contains(warnings.String(), "read-only") {
+		t.Errorf("expected a warning about the staging directory, got %q", warnings.String())
+	}
+}
@@ -0,0 +1,176 @@
+package knot
+
+import (
+	"fmt"
+	"mime"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ViewerContext holds the values of the placeholders that viewer
+// command templates may contain: {file}, {page} and {project}
+type ViewerContext struct {
+	File    string
+	Page    int
+	Project string
+}
+
+func (ctx *ViewerContext) inputs() []string {
+	return []string{ctx.File, strconv.Itoa(ctx.Page), ctx.Project}
+}
+
+var viewerPlaceholders = []string{"{file}", "{page}", "{project}"}
+
+func hasPlaceholder(args []string) bool {
+	for _, arg := range args {
+		for _, placeholder := range viewerPlaceholders {
+			if strings.Contains(arg, placeholder) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TemplateCommandRunner runs a command whose arguments contain
+// placeholders for the file, page and project
+type TemplateCommandRunner struct {
+	commandName string
+	args        []string
+}
+
+func NewTemplateCommandRunner(commandName string, args ...string) *TemplateCommandRunner {
+	return &TemplateCommandRunner{commandName: commandName, args: args}
+}
+
+func (runner *TemplateCommandRunner) commandLine(inputs []string) []string {
+	values := make([]string, len(viewerPlaceholders))
+	copy(values, inputs)
+
+	replacer := strings.NewReplacer(
+		"{file}", values[0], "{page}", values[1], "{project}", values[2])
+
+	args := make([]string, 0, len(runner.args)+1)
+	hasFile := false
+	for _, arg := range runner.args {
+		hasFile = hasFile || strings.Contains(arg, "{file}")
+		args = append(args, replacer.Replace(arg))
+	}
+	if !hasFile && values[0] != "" {
+		args = append(args, values[0])
+	}
+
+	return append([]string{replacer.Replace(runner.commandName)}, args...)
+}
+
+func (runner *TemplateCommandRunner) command(inputs []string) *exec.Cmd {
+	line := runner.commandLine(inputs)
+	return exec.Command(line[0], line[1:]...)
+}
+
+func (runner *TemplateCommandRunner) Run(inputs []string) (string, error) {
+	output, err := runner.command(inputs).CombinedOutput()
+	return string(output), err
+}
+
+func (runner *TemplateCommandRunner) Start(inputs []string) error {
+	return runner.command(inputs).Start()
+}
+
+func (runner *TemplateCommandRunner) String() string {
+	return strings.Join(append([]string{runner.commandName}, runner.args...), " ")
+}
+
+// viewerFromRunner turns a plain command into a template, so that
+// every viewer in the registry takes the same inputs
+func viewerFromRunner(runner CommandRunner) CommandRunner {
+	if simple, ok := runner.(*SimpleCommandRunner); ok {
+		return NewTemplateCommandRunner(simple.commandName, simple.args...)
+	}
+	return runner
+}
+
+// GetFileType returns the MIME type of a file, inode/directory for
+// directories, or an empty string if it is unknown
+func GetFileType(fsys FileSystem, file string) string {
+	if stat, err := fsys.Stat(file); err == nil && stat.IsDir() {
+		return "inode/directory"
+	}
+	fileType := mime.TypeByExtension(filepath.Ext(file))
+	if i := strings.Index(fileType, ";"); i >= 0 {
+		fileType = fileType[:i]
+	}
+	return fileType
+}
+
+// FindViewer picks the command that opens a file, trying defviewer
+// first and DefaultViewer last
+func (ci *ConfigInfo) FindViewer(fsys FileSystem, file string) CommandRunner {
+	extension := strings.ToLower(filepath.Ext(file))
+	fileType := GetFileType(fsys, file)
+
+	keys := []string{extension, fileType}
+	if i := strings.Index(fileType, "/"); i >= 0 {
+		keys = append(keys, fileType[:i]+"/*")
+	}
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if viewer, ok := ci.Viewers[key]; ok {
+			return viewer
+		}
+	}
+
+	switch {
+	case extension == ".kra":
+		return ci.KritaCommand
+	case extension == ".pdf":
+		return ci.PDFReader
+	case fileType == "inode/directory":
+		return ci.FileExplorer
+	default:
+		return ci.DefaultViewer
+	}
+}
+
+// OpenFileAt opens a file with its viewer, at page if it takes one
+func OpenFileAt(si *SystemInfo, file string, page int, open bool) error {
+	if !open {
+		return nil
+	}
+
+	if absFile, err := filepath.Abs(file); err == nil {
+		file = absFile
+	}
+
+	pi, projectName, _ := findPathProject(si, file)
+	ci := ResolveConfigInfo(si, &pi, GetPathBatchNumber(&pi, file))
+	if err := ci.ConfigErr(); err != nil {
+		return err
+	}
+	return openFileWith(si, &ci, file, page, projectName)
+}
+
+// openFileWith opens a file of the given project with its viewer in
+// the configuration ci
+func openFileWith(si *SystemInfo, ci *ConfigInfo, file string, page int, projectName string) error {
+	ctx := ViewerContext{File: file, Page: page, Project: projectName}
+
+	viewer := ci.FindViewer(si.FS, file)
+	if _, ok := viewer.(*TemplateCommandRunner); ok {
+		return si.Actions.Start(viewer, ctx.inputs())
+	}
+	return si.Actions.Start(viewer, []string{file})
+}
+
+func viewerKey(key string) (string, error) {
+	key = strings.ToLower(strings.TrimSpace(key))
+	if strings.HasPrefix(key, ".") || strings.Contains(key, "/") {
+		return key, nil
+	}
+	return "", fmt.Errorf(
+		"<%s> is neither an extension like .pdf nor a MIME type like image/png", key)
+}
@@ -0,0 +1,816 @@
+package knot
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Options control how a Workspace reaches the disk. The zero value
+// works on the OS's filesystem and performs every action
+type Options struct {
+	// FS is read from directly, the OS's filesystem by default
+	FS FileSystem
+	// DryRun adds every action to Plan instead of performing it
+	DryRun bool
+	Plan   *Plan
+	// Log, if set, gets a line for every action performed
+	Log io.Writer
+	// Warnings gets the problems that don't stop an operation, if not
+	// nil
+	Warnings io.Writer
+}
+
+// Workspace gives access to the projects registered with knot
+type Workspace struct {
+	si       *SystemInfo
+	projects Projects
+}
+
+// Project is a registered project
+type Project struct {
+	w    *Workspace
+	name string
+	info ProjectInfo
+}
+
+// Batch is a batch of a project. It may not exist yet
+type Batch struct {
+	project *Project
+	number  int
+}
+
+// Page is a .kra page of a batch. It may not exist yet
+type Page struct {
+	batch  *Batch
+	number int
+}
+
+// OpenWorkspace loads knot's configuration and project list
+func OpenWorkspace(ctx context.Context, platform Platform, opts Options) (*Workspace, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if opts.FS == nil {
+		opts.FS = OSFileSystem{}
+	}
+
+	si, err := GetSystemInfo(
+		platform, opts.FS, GetActions(&opts, opts.FS), opts.Warnings)
+	if err != nil {
+		return nil, err
+	}
+	return NewWorkspace(&si)
+}
+
+// NewWorkspace makes a workspace out of an existing SystemInfo
+func NewWorkspace(si *SystemInfo) (*Workspace, error) {
+	projects, err := GetProjects(si.FS, si.ProjectsFile)
+	if err != nil {
+		return nil, err
+	}
+	si.BindZygoBuiltins()
+	return &Workspace{si: si, projects: projects}, nil
+}
+
+// SystemInfo returns the SystemInfo of the workspace, for the lower
+// level functions of the package
+func (w *Workspace) SystemInfo() *SystemInfo {
+	return w.si
+}
+
+// Config returns the global configuration
+func (w *Workspace) Config() *ConfigInfo {
+	return &w.si.ConfigInfo
+}
+
+// withContext returns a copy of the SystemInfo of the workspace whose
+// actions stop once ctx is done
+func (w *Workspace) withContext(ctx context.Context) (*SystemInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	si := *w.si
+	si.Actions = &contextActions{ctx: ctx, actions: w.si.Actions}
+	return &si, nil
+}
+
+// configured is withContext with the configuration of a project and
+// batch
+func (w *Workspace) configured(ctx context.Context, pi *ProjectInfo, batchNumber int) (*SystemInfo, error) {
+	si, err := w.withContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if pi == nil {
+		pi = &ProjectInfo{}
+	}
+	si.ConfigInfo = ResolveConfigInfo(si, pi, batchNumber)
+	if err = si.ConfigErr(); err != nil {
+		return nil, err
+	}
+	return si, nil
+}
+
+// WD returns the knot working directory
+func (w *Workspace) WD() string {
+	return w.si.KnotWD
+}
+
+// SetWD changes the knot working directory of the current session
+func (w *Workspace) SetWD(ctx context.Context, dir string) error {
+	si, err := w.withContext(ctx)
+	if err != nil {
+		return err
+	}
+	if err = SetTempKnotWD(si, dir); err != nil {
+		return err
+	}
+	w.si.KnotWD, _ = filepath.Abs(dir)
+	return nil
+}
+
+// Projects returns the registered projects, sorted by name
+func (w *Workspace) Projects() []*Project {
+	result := make([]*Project, 0, len(w.projects))
+	for name, info := range w.projects {
+		result = append(result, &Project{w: w, name: name, info: info})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].name < result[j].name
+	})
+	return result
+}
+
+// Project returns the registered project with the given name
+func (w *Workspace) Project(name string) (*Project, error) {
+	info, ok := w.projects[name]
+	if !ok {
+		return nil, &Error{Kind: ErrProjectNotFound, Project: name}
+	}
+	return &Project{w: w, name: name, info: info}, nil
+}
+
+// ProjectAt returns the registered project that contains path
+func (w *Workspace) ProjectAt(path string) (*Project, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	projectsByDir := ArrangeProjectsByDir(&w.projects)
+	info, err := FindFirstParentProjectInfo(w.si.FS, absPath, &w.projects, &projectsByDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Project{w: w, name: projectsByDir[info.ProjectDir], info: info}, nil
+}
+
+// CurrentProject returns the project that contains the knot working
+// directory
+func (w *Workspace) CurrentProject() (*Project, error) {
+	return w.ProjectAt(w.si.KnotWD)
+}
+
+// InitProject creates a project in dir, registers it and makes it the
+// knot working directory
+func (w *Workspace) InitProject(ctx context.Context, dir string, opts ProjectOptions) (*Project, error) {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(w.si.KnotWD, dir)
+	}
+	info := NewProjectInfo(filepath.Clean(dir), &opts)
+
+	si, err := w.configured(ctx, nil, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ValidBatchNaming(info.BatchNaming) {
+		return nil, UsageError("unknown batch naming <%s>, use date or week", info.BatchNaming)
+	}
+
+	firstBatch := FirstBatchNumber(&info, time.Now())
+	templatePath := filepath.Join(si.TemplateDir, info.TemplateName)
+	created, err := createProject(templatePath, si, &info, firstBatch, false)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Git {
+		if err = InitGitRepo(si, &info); err != nil {
+			return nil, err
+		}
+	}
+
+	// registering an existing directory isn't creating a project
+	if created {
+		firstBatchDir := GetBatchDir(&info, firstBatch)
+		err = RunHook(si, HookProjectInit,
+			info.ProjectDir, firstBatchDir, filepath.Join(firstBatchDir, GetPageName(0)))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	name := filepath.Base(info.ProjectDir)
+	w.projects[name] = info
+	if err = w.projects.Save(si, si.ProjectsFile); err != nil {
+		return nil, err
+	}
+
+	if err = w.SetWD(ctx, info.ProjectDir); err != nil {
+		return nil, err
+	}
+	return &Project{w: w, name: name, info: info}, nil
+}
+
+// Deregister removes a project from the project list, given its name
+// or the path to its directory. Its files are left alone
+func (w *Workspace) Deregister(ctx context.Context, project string) (*Project, error) {
+	si, err := w.withContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	name, info, ok := w.projects.Deregister(project)
+	if !ok {
+		return nil, &Error{Kind: ErrProjectNotFound, Project: name}
+	}
+	if err = w.projects.Save(si, si.ProjectsFile); err != nil {
+		w.projects[name] = info
+		return nil, err
+	}
+	return &Project{w: w, name: name, info: info}, nil
+}
+
+// Import unpacks the .knotpack at src into dir and registers it
+func (w *Workspace) Import(ctx context.Context, src string, dir string) (*Project, error) {
+	si, err := w.withContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if dir == "" {
+		manifest, err := VerifyPack(si.FS, src)
+		if err != nil {
+			return nil, err
+		}
+		dir = manifest.Name
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(w.si.KnotWD, dir)
+	}
+
+	info, err := ImportPack(si, &w.projects, src, filepath.Clean(dir))
+	if err != nil {
+		return nil, err
+	}
+	return &Project{w: w, name: filepath.Base(info.ProjectDir), info: info}, nil
+}
+
+// VerifyPack checks the hashes of the files in the .knotpack at src
+// and returns its manifest
+func (w *Workspace) VerifyPack(ctx context.Context, src string) (PackManifest, error) {
+	if err := ctx.Err(); err != nil {
+		return PackManifest{}, err
+	}
+	return VerifyPack(w.si.FS, src)
+}
+
+// Backup mirrors every registered project into dst, see BackupProjects
+func (w *Workspace) Backup(ctx context.Context, dst string, prune bool) (BackupReport, error) {
+	si, err := w.withContext(ctx)
+	if err != nil {
+		return BackupReport{}, err
+	}
+	return BackupProjects(si, &w.projects, dst, prune)
+}
+
+// RestoreBackup registers the projects of the backup in src again,
+// copying back those that are missing, see RestoreBackup
+func (w *Workspace) RestoreBackup(ctx context.Context, src string, dir string) (BackupReport, error) {
+	si, err := w.withContext(ctx)
+	if err != nil {
+		return BackupReport{}, err
+	}
+	return RestoreBackup(si, &w.projects, src, dir)
+}
+
+// MissingProjects returns the names of the registered projects whose
+// directory doesn't exist
+func (w *Workspace) MissingProjects() []string {
+	return MissingProjects(w.si.FS, &w.projects)
+}
+
+// Stats reads the statistics of the given projects, or of every
+// registered project if names is empty
+func (w *Workspace) Stats(ctx context.Context, names []string) (Stats, error) {
+	if err := ctx.Err(); err != nil {
+		return Stats{}, err
+	}
+	return GetStats(w.si.FS, &w.projects, names)
+}
+
+// Open opens a file or directory with its viewer
+func (w *Workspace) Open(ctx context.Context, path string) error {
+	si, err := w.withContext(ctx)
+	if err != nil {
+		return err
+	}
+	return OpenFile(si, path, true)
+}
+
+// RunCommand calls a custom command defined in config.zy
+func (w *Workspace) RunCommand(ctx context.Context, name string, args []string) error {
+	si, err := w.configured(ctx, nil, -1)
+	if err != nil {
+		return err
+	}
+	return RunCommand(si, name, args)
+}
+
+func (p *Project) Name() string {
+	return p.name
+}
+
+func (p *Project) Dir() string {
+	return p.info.ProjectDir
+}
+
+func (p *Project) Info() ProjectInfo {
+	return p.info
+}
+
+// Group returns the path of the group the project stands for
+func (p *Project) Group() string {
+	return p.info.Group
+}
+
+// InGroup returns the group of the project at path, whether it exists
+// or not
+func (p *Project) InGroup(group string) *Project {
+	return &Project{w: p.w, name: p.name, info: p.info.InGroup(group)}
+}
+
+// Root returns the project itself, out of any group
+func (p *Project) Root() *Project {
+	return p.InGroup("")
+}
+
+func (p *Project) root() *ProjectInfo {
+	root := p.info.Root()
+	return &root
+}
+
+// Config returns the configuration of the project, with its knot.zy
+// layered over config.zy
+func (p *Project) Config() ConfigInfo {
+	return ResolveConfigInfo(p.w.si, &p.info, -1)
+}
+
+// Batches returns the batches of the project, in order
+func (p *Project) Batches(ctx context.Context) ([]*Batch, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	batchNumbers, err := GetBatchNumbers(p.w.si.FS, &p.info)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*Batch, len(batchNumbers))
+	for i, batchNumber := range batchNumbers {
+		result[i] = p.Batch(batchNumber)
+	}
+	return result, nil
+}
+
+// Batch returns the batch with the given number, whether it exists or
+// not
+func (p *Project) Batch(number int) *Batch {
+	return &Batch{project: p, number: number}
+}
+
+// BatchLabeled returns the batch with the given label or path, see
+// ParseBatchPath
+func (p *Project) BatchLabeled(label string) (*Batch, error) {
+	group, number, err := ParseBatchPath(&p.info, label)
+	if err != nil {
+		return nil, err
+	}
+	return p.InGroup(group.Group).Batch(number), nil
+}
+
+// AllBatches returns the batches of the project and of the groups
+// nested in it, in order
+func (p *Project) AllBatches(ctx context.Context) ([]*Batch, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	groups, err := GetBatchGroups(p.w.si.FS, &p.info)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*Batch
+	groups.Walk(func(group *ProjectInfo, batchNumber int) error {
+		result = append(result, p.InGroup(group.Group).Batch(batchNumber))
+		return nil
+	})
+	return result, nil
+}
+
+// LatestBatch returns the batch with the highest number
+func (p *Project) LatestBatch(ctx context.Context) (*Batch, error) {
+	batches, err := p.Batches(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(batches) == 0 {
+		return nil, &Error{
+			Kind: ErrBatchNotFound, Project: p.name, Path: p.info.ContentDir}
+	}
+	return batches[len(batches)-1], nil
+}
+
+// NewBatch creates the batch after the latest one or, if the batches
+// are dated or weekly, the batch of today
+func (p *Project) NewBatch(ctx context.Context) (*Batch, error) {
+	if p.info.BatchNaming != BatchNumbered {
+		return p.NewNumberedBatch(ctx, BatchForDate(&p.info, time.Now()))
+	}
+
+	batches, err := p.Batches(ctx)
+	if err != nil {
+		return nil, err
+	}
+	number := 0
+	if len(batches) > 0 {
+		number = batches[len(batches)-1].number + 1
+	}
+	return p.NewNumberedBatch(ctx, number)
+}
+
+// NewNumberedBatch creates the batch with the given number
+func (p *Project) NewNumberedBatch(ctx context.Context, number int) (*Batch, error) {
+	si, err := p.w.configured(ctx, &p.info, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	templatePath := filepath.Join(si.TemplateDir, p.info.TemplateName)
+	if err = MakeBatch(templatePath, si, &p.info, number, false); err != nil {
+		return nil, err
+	}
+
+	batch := p.Batch(number)
+	err = RunHook(si, HookBatchCreate,
+		p.info.ProjectDir, batch.Dir(), batch.Page(0).Path())
+	if err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// MigrateBatchNaming renames the batches of the project after their
+// day or week, see MigrateBatchNaming
+func (p *Project) MigrateBatchNaming(ctx context.Context, naming string) ([]BatchRename, error) {
+	si, err := p.w.withContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := p.info.BatchNaming
+	return migrateBatchNaming(si, p.root(), naming, func() error {
+		p.info.BatchNaming = naming
+		p.w.projects[p.name] = *p.root()
+		err := p.w.projects.Save(si, si.ProjectsFile)
+		if err != nil {
+			p.info.BatchNaming = previous
+			p.w.projects[p.name] = *p.root()
+		}
+		return err
+	})
+}
+
+// Open opens the project directory with the file explorer
+func (p *Project) Open(ctx context.Context) error {
+	return p.w.Open(ctx, p.info.ProjectDir)
+}
+
+// ExportSite exports the project as a static html site in dir and
+// returns the path to its index
+func (p *Project) ExportSite(ctx context.Context, dir string) (string, error) {
+	si, err := p.w.configured(ctx, p.root(), -1)
+	if err != nil {
+		return "", err
+	}
+	return ExportSite(dir, p.root(), si)
+}
+
+// ExportMarkdown exports the project as markdown notes and returns the
+// path to their index
+func (p *Project) ExportMarkdown(ctx context.Context, opts MarkdownOptions) (string, error) {
+	si, err := p.w.configured(ctx, p.root(), -1)
+	if err != nil {
+		return "", err
+	}
+	return ExportMarkdown(&opts, p.root(), si)
+}
+
+// RunCommand calls a custom command defined in config.zy or in the
+// project's knot.zy
+func (p *Project) RunCommand(ctx context.Context, name string, args []string) error {
+	si, err := p.w.configured(ctx, &p.info, -1)
+	if err != nil {
+		return err
+	}
+	return RunCommand(si, name, args)
+}
+
+// Snapshot commits the changed pages to the project's git repository
+func (p *Project) Snapshot(ctx context.Context) (string, error) {
+	si, err := p.w.withContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	return Snapshot(si, p.root())
+}
+
+// SaveVersions stores the changed pages in the history store
+func (p *Project) SaveVersions(ctx context.Context) ([]string, error) {
+	si, err := p.w.configured(ctx, p.root(), -1)
+	if err != nil {
+		return nil, err
+	}
+	return SnapshotPages(si, p.root(), "snapshot")
+}
+
+// WatchVersions saves versions of the pages every interval until ctx
+// is done, calling report after each time
+func (p *Project) WatchVersions(ctx context.Context, interval time.Duration, report func(pages []string, err error)) error {
+	si, err := p.w.configured(ctx, p.root(), -1)
+	if err != nil {
+		return err
+	}
+	WatchHistory(ctx, si, p.root(), interval, report)
+	return nil
+}
+
+// PruneVersions drops the versions the retention policy doesn't keep
+// from the history store, and returns how many it dropped
+func (p *Project) PruneVersions(ctx context.Context) (int, error) {
+	si, err := p.w.configured(ctx, p.root(), -1)
+	if err != nil {
+		return 0, err
+	}
+	return PruneHistory(si, p.root())
+}
+
+// Export exports the project to a single pdf, see ExportProject
+func (p *Project) Export(ctx context.Context) (string, error) {
+	si, err := p.w.configured(ctx, p.root(), -1)
+	if err != nil {
+		return "", err
+	}
+	return ExportProject(p.root(), si)
+}
+
+// Archive writes the project to a .knotpack at dst, with the exported
+// pages if exports is set, and returns the manifest of the pack
+func (p *Project) Archive(ctx context.Context, dst string, exports bool) (PackManifest, error) {
+	si, err := p.w.withContext(ctx)
+	if err != nil {
+		return PackManifest{}, err
+	}
+	return ArchiveProject(si, p.name, p.root(), dst, exports)
+}
+
+// Annotations returns the stars, bookmarks and notes of the pages of
+// the project
+func (p *Project) Annotations(ctx context.Context) (Annotations, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return GetAnnotations(p.w.si.FS, &p.info)
+}
+
+func (b *Batch) Project() *Project {
+	return b.project
+}
+
+func (b *Batch) Number() int {
+	return b.number
+}
+
+// Label returns the number, date or week of the batch, prefixed with
+// its group if it has one, as the command line gives it
+func (b *Batch) Label() string {
+	return BatchPath(&b.project.info, b.number)
+}
+
+func (b *Batch) Dir() string {
+	return GetBatchDir(&b.project.info, b.number)
+}
+
+// Config returns the configuration of the batch, with the knot.zy of
+// its project and its own layered over config.zy
+func (b *Batch) Config() ConfigInfo {
+	return ResolveConfigInfo(b.project.w.si, &b.project.info, b.number)
+}
+
+// Pages returns the .kra pages of the batch, in order
+func (b *Batch) Pages(ctx context.Context) ([]*Page, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	pageNumbers, err := GetPageNumbers(b.project.w.si.FS, b.Dir(), ".kra")
+	if err != nil {
+		return nil, &Error{
+			Kind: ErrBatchNotFound, Project: b.project.name, Path: b.Dir(), Cause: err}
+	}
+
+	result := make([]*Page, len(pageNumbers))
+	for i, pageNumber := range pageNumbers {
+		result[i] = b.Page(pageNumber)
+	}
+	return result, nil
+}
+
+// Page returns the page with the given number, whether it exists or
+// not
+func (b *Batch) Page(number int) *Page {
+	return &Page{batch: b, number: number}
+}
+
+// NewPage adds a copy of the template page after the last page of the
+// batch
+func (b *Batch) NewPage(ctx context.Context) (*Page, error) {
+	pi := &b.project.info
+	si, err := b.project.w.configured(ctx, pi, b.number)
+	if err != nil {
+		return nil, err
+	}
+
+	path, number, err := MakePage(
+		filepath.Join(si.TemplateDir, pi.TemplateName), si, pi, b.number, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = RunHook(si, HookPageCreate, pi.ProjectDir, b.Dir(), path); err != nil {
+		return nil, err
+	}
+	return b.Page(number), nil
+}
+
+// Import adds a page to the batch for each image or pdf page in files,
+// see ImportPages, and runs the page create hook on each of them
+func (b *Batch) Import(ctx context.Context, files []string, fit bool) ([]*Page, error) {
+	pi := &b.project.info
+	si, err := b.project.w.configured(ctx, pi, b.number)
+	if err != nil {
+		return nil, err
+	}
+
+	numbers, err := ImportPages(
+		filepath.Join(si.TemplateDir, pi.TemplateName), si, pi, b.number, files, fit)
+	if err != nil {
+		return nil, err
+	}
+
+	var pages []*Page
+	for _, number := range numbers {
+		page := b.Page(number)
+		if err = RunHook(si, HookPageCreate, pi.ProjectDir, b.Dir(), page.Path()); err != nil {
+			return pages, err
+		}
+		pages = append(pages, page)
+	}
+	return pages, nil
+}
+
+// Export exports the pages of the batch to pngs and merges them into a
+// pdf, whose path it returns
+func (b *Batch) Export(ctx context.Context) (string, error) {
+	pi := &b.project.info
+	si, err := b.project.w.configured(ctx, pi, b.number)
+	if err != nil {
+		return "", err
+	}
+
+	if err = RunHook(si, HookBeforeExport, pi.ProjectDir, b.Dir(), ""); err != nil {
+		return "", err
+	}
+
+	output, err := ExportBatch(b.number, pi, si)
+	if err != nil {
+		return "", err
+	}
+
+	if err = RunHook(si, HookAfterExport, pi.ProjectDir, b.Dir(), output); err != nil {
+		return "", err
+	}
+	return output, nil
+}
+
+// Open opens the pages of the batch in krita. If recent is positive,
+// only that many of the most recently modified pages are opened
+func (b *Batch) Open(ctx context.Context, recent int) error {
+	si, err := b.project.w.withContext(ctx)
+	if err != nil {
+		return err
+	}
+	return OpenKraFilesInBatch(si, &b.project.info, b.number, recent, true)
+}
+
+func (page *Page) Batch() *Batch {
+	return page.batch
+}
+
+func (page *Page) Number() int {
+	return page.number
+}
+
+func (page *Page) Path() string {
+	return filepath.Join(page.batch.Dir(), GetPageName(page.number))
+}
+
+// History returns the commits of the project's git repository that
+// changed the page, latest first
+func (page *Page) History(ctx context.Context) ([]Revision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return PageHistory(&page.batch.project.info, page.Path())
+}
+
+// Restore adds the version of the page from a revision to its batch,
+// as a new page, and returns it
+func (page *Page) Restore(ctx context.Context, revision string) (*Page, error) {
+	si, err := page.batch.project.w.withContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, number, err := RestorePage(si, &page.batch.project.info, page.Path(), revision)
+	if err != nil {
+		return nil, err
+	}
+	return page.batch.Page(number), nil
+}
+
+// Versions returns the versions of the page in the history store,
+// latest first
+func (page *Page) Versions(ctx context.Context) ([]Revision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return StoredPageHistory(
+		page.batch.project.w.si.FS, &page.batch.project.info, page.Path())
+}
+
+// RestoreVersion replaces the page with one of its versions from the
+// history store, after saving its current version there
+func (page *Page) RestoreVersion(ctx context.Context, revision string) error {
+	pi := &page.batch.project.info
+	si, err := page.batch.project.w.configured(ctx, pi, page.batch.number)
+	if err != nil {
+		return err
+	}
+	return RestoreStoredPage(si, pi, page.Path(), revision)
+}
+
+// CompareVersions writes two versions of the page side by side
+func (page *Page) CompareVersions(ctx context.Context, from, to string) (string, error) {
+	si, err := page.batch.project.w.withContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	return VersionPreview(si, &page.batch.project.info, page.Path(), from, to)
+}
+
+// Annotate changes the annotation of the page with change, saves it and
+// returns it
+func (page *Page) Annotate(ctx context.Context, change func(*Annotation)) (Annotation, error) {
+	si, err := page.batch.project.w.withContext(ctx)
+	if err != nil {
+		return Annotation{}, err
+	}
+	return Annotate(si, &page.batch.project.info, page.batch.number, page.number, change)
+}
+
+// Open opens the page with its viewer, krita by default
+func (page *Page) Open(ctx context.Context) error {
+	return page.batch.project.w.Open(ctx, page.Path())
+}
+
+// OpenExported opens the pdf of the page's batch at the page, see
+// OpenExportedPage
+func (page *Page) OpenExported(ctx context.Context) error {
+	project := page.batch.project
+	si, err := project.w.withContext(ctx)
+	if err != nil {
+		return err
+	}
+	return OpenExportedPage(si, &project.info, page.batch.number, page.number)
+}
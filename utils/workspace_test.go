@@ -0,0 +1,140 @@
+package knot
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestWorkspace(t *testing.T) {
+	si, mem := newTestSystemInfo(t)
+	ctx := context.Background()
+
+	w, err := NewWorkspace(si)
+	if err != nil {
+		t.Fatal(err)
+	}
+	project, err := w.InitProject(ctx, "/projects/notes", ProjectOptions{ContentDirName: "content"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.WD() != "/projects/notes" {
+		t.Errorf("expected the new project to be the working directory, got <%s>", w.WD())
+	}
+
+	batch, err := project.NewBatch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := batch.NewPage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch.Number() != 1 || page.Number() != 1 {
+		t.Errorf("expected page 1 of batch 1, got page %d of batch %d", page.Number(), batch.Number())
+	}
+	assertExists(t, mem, "/projects/notes/content/notes-1/page-1.kra")
+
+	latest, err := project.LatestBatch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := latest.Pages(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 || pages[1].Path() != page.Path() {
+		t.Errorf("expected pages 0 and 1 in the latest batch, got %d", len(pages))
+	}
+
+	reloaded, err := NewWorkspace(si)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current, err := reloaded.ProjectAt(batch.Dir()); err != nil || current.Name() != "notes" {
+		t.Errorf("expected <%s> to be in project notes, got %v", batch.Dir(), err)
+	}
+
+	if _, err = w.Deregister(ctx, "notes"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Project("notes"); !errors.Is(err, ErrProjectNotFound) {
+		t.Errorf("expected ErrProjectNotFound, got %v", err)
+	}
+	assertExists(t, mem, "/projects/notes")
+}
+
+func TestWorkspaceCancelled(t *testing.T) {
+	si, mem, pi := newTestProject(t)
+	projects := Projects{"notes": pi}
+	if err := projects.Save(si, si.ProjectsFile); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewWorkspace(si)
+	if err != nil {
+		t.Fatal(err)
+	}
+	project, err := w.Project("notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = project.NewBatch(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the context's error, got %v", err)
+	}
+	assertNotExists(t, mem, GetBatchDir(&pi, 1))
+}
+
+func TestNewBatchAfterDeletedBatch(t *testing.T) {
+	si, mem := newTestSystemInfo(t)
+	ctx := context.Background()
+
+	w, err := NewWorkspace(si)
+	if err != nil {
+		t.Fatal(err)
+	}
+	project, err := w.InitProject(ctx, "/projects/notes", ProjectOptions{ContentDirName: "content"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustMkdirAll(t, mem, "/projects/notes/content/notes-2")
+
+	batch, err := project.NewBatch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch.Number() != 3 {
+		t.Errorf("expected batch 3 after batches 0 and 2, got %d", batch.Number())
+	}
+}
+
+func TestMigrateBatchNamingRollsBack(t *testing.T) {
+	si, mem := newTestSystemInfo(t)
+	ctx := context.Background()
+
+	w, err := NewWorkspace(si)
+	if err != nil {
+		t.Fatal(err)
+	}
+	project, err := w.InitProject(ctx, "/projects/notes", ProjectOptions{ContentDirName: "content"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the project list can't be written over a directory
+	if err = mem.Remove(si.ProjectsFile); err != nil {
+		t.Fatal(err)
+	}
+	mustMkdirAll(t, mem, si.ProjectsFile)
+
+	if _, err = project.MigrateBatchNaming(ctx, BatchDated); err == nil {
+		t.Fatal("expected the migration to fail")
+	}
+	if naming := project.Info().BatchNaming; naming != BatchNumbered {
+		t.Errorf("expected the project to stay numbered, got %q", naming)
+	}
+	// the batch is renamed back
+	assertExists(t, mem, "/projects/notes/content/notes-0/page-0.kra")
+}